@@ -1556,6 +1556,50 @@ func (c *config) CFIEnabledForPath(path string) bool {
 	return HasAnyPrefix(path, c.productVariables.CFIIncludePaths) && !c.CFIDisabledForPath(path)
 }
 
+// HeaderAbiCheckerEnabledForPath returns whether the header ABI checker is enabled by default for
+// the libraries under path that don't set header_abi_checker.enabled themselves.
+func (c *config) HeaderAbiCheckerEnabledForPath(path string) bool {
+	if len(c.productVariables.HeaderAbiCheckerIncludePaths) == 0 {
+		return false
+	}
+	return HasAnyPrefix(path, c.productVariables.HeaderAbiCheckerIncludePaths)
+}
+
+// StaticLibraryObjectLimit returns the number of objects above which a static library is reported
+// as too large, or 0 if there is no limit.
+func (c *config) StaticLibraryObjectLimit() int {
+	return proptools.IntDefault(c.productVariables.StaticLibraryObjectLimit, 0)
+}
+
+// StaticLibraryObjectLimitIsError returns true if exceeding StaticLibraryObjectLimit is an error
+// instead of a warning.
+func (c *config) StaticLibraryObjectLimitIsError() bool {
+	return Bool(c.productVariables.StaticLibraryObjectLimitIsError)
+}
+
+// TestStaticVariants returns true if the shared-only libraries that set test_static_variant
+// should also have a static variant.
+func (c *config) TestStaticVariants() bool {
+	return Bool(c.productVariables.TestStaticVariants)
+}
+
+// ExportedSymbolDenylist returns the symbols that device shared libraries must not export.
+func (c *config) ExportedSymbolDenylist() []string {
+	return c.productVariables.ExportedSymbolDenylist
+}
+
+// ExportedSymbolDenylistAllowedForPath returns whether the libraries under path may export the
+// symbols of ExportedSymbolDenylist.
+func (c *config) ExportedSymbolDenylistAllowedForPath(path string) bool {
+	return HasAnyPrefix(path, c.productVariables.ExportedSymbolDenylistAllowedPaths)
+}
+
+// TocScript returns the script that extracts the table of contents of the shared libraries built
+// for os, or an empty string if the default script should be used.
+func (c *config) TocScript(os OsType) string {
+	return c.productVariables.TocScripts[os.Name]
+}
+
 func (c *config) MemtagHeapDisabledForPath(path string) bool {
 	if len(c.productVariables.MemtagHeapExcludePaths) == 0 {
 		return false
@@ -318,6 +318,26 @@ type ProductVariables struct {
 	CFIExcludePaths []string `json:",omitempty"`
 	CFIIncludePaths []string `json:",omitempty"`
 
+	HeaderAbiCheckerIncludePaths []string `json:",omitempty"`
+
+	// The number of objects above which a static library, including the objects of its
+	// whole_static_libs, triggers a warning, or an error if StaticLibraryObjectLimitIsError is set.
+	StaticLibraryObjectLimit        *int  `json:",omitempty"`
+	StaticLibraryObjectLimitIsError *bool `json:",omitempty"`
+
+	// Create a static variant of the shared-only libraries that set test_static_variant, for
+	// builds of tests that link them statically. Never set for production builds.
+	TestStaticVariants *bool `json:",omitempty"`
+
+	// Map from the name of an OS (e.g. "linux_bionic") to the script that extracts the table of
+	// contents of the shared libraries built for it, replacing build/soong/scripts/toc.sh.
+	TocScripts map[string]string `json:",omitempty"`
+
+	// Symbols that no device shared library may export, except the libraries under
+	// ExportedSymbolDenylistAllowedPaths.
+	ExportedSymbolDenylist             []string `json:",omitempty"`
+	ExportedSymbolDenylistAllowedPaths []string `json:",omitempty"`
+
 	DisableScudo *bool `json:",omitempty"`
 
 	MemtagHeapExcludePaths      []string `json:",omitempty"`
@@ -19,6 +19,8 @@ package cc
 // functions.
 
 import (
+	"encoding/json"
+	"fmt"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -26,6 +28,7 @@ import (
 
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/pathtools"
+	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
 	"android/soong/cc/config"
@@ -37,15 +40,70 @@ const (
 	staticLibraryExtension = ".a"
 )
 
+// maxConcurrentCompilesLimit is the largest value accepted for max_concurrent_compiles.
+const maxConcurrentCompilesLimit = 8
+
+// restatReplace ends the command of restat rules that write their output to ${out}.tmp. It only
+// replaces ${out} if the content changed, so that its dependents aren't rebuilt otherwise.
+const restatReplace = "(cmp -s ${out}.tmp ${out} && rm ${out}.tmp || mv ${out}.tmp ${out})"
+
 var (
 	pctx = android.NewPackageContext("android/soong/cc")
 
+	ccRuleParams = blueprint.RuleParams{
+		Depfile:     "${out}.d",
+		Deps:        blueprint.DepsGCC,
+		Command:     "$relPwd ${config.CcWrapper}$ccCmd -c $cFlags -MD -MF ${out}.d -o $out $in",
+		CommandDeps: []string{"$ccCmd"},
+	}
+
 	// Rule to invoke gcc with given command, flags, and dependencies. Outputs a .d depfile.
 	cc = pctx.AndroidRemoteStaticRule("cc", android.RemoteRuleSupports{Goma: true, RBE: true},
+		ccRuleParams, "ccCmd", "cFlags")
+
+	// Rules identical to cc, but run in a pool of depth i+1, for libraries that set
+	// max_concurrent_compiles to i+1. The pools are shared by all the libraries with the same
+	// max_concurrent_compiles. Like cc they support remote execution, which also keeps the pool
+	// from being replaced by the local pool when USE_GOMA or USE_RBE is set.
+	ccLimited = func() []blueprint.Rule {
+		var rules []blueprint.Rule
+		for depth := 1; depth <= maxConcurrentCompilesLimit; depth++ {
+			params := ccRuleParams
+			params.Pool = pctx.StaticPool("ccLimitedPool"+strconv.Itoa(depth), blueprint.PoolParams{
+				Depth: depth,
+			})
+			rules = append(rules, pctx.AndroidRemoteStaticRule("ccLimited"+strconv.Itoa(depth),
+				android.RemoteRuleSupports{Goma: true, RBE: true}, params, "ccCmd", "cFlags"))
+		}
+		return rules
+	}()
+
+	// Rule to invoke clang to only preprocess a source file.
+	ccPreprocess = pctx.AndroidStaticRule("ccPreprocess",
+		blueprint.RuleParams{
+			Depfile:     "${out}.d",
+			Deps:        blueprint.DepsGCC,
+			Command:     "$relPwd $ccCmd -E $cFlags -MD -MF ${out}.d -o $out $in",
+			CommandDeps: []string{"$ccCmd"},
+		},
+		"ccCmd", "cFlags")
+
+	// Rule to invoke clang to only compile a source file to assembly.
+	ccAsmListing = pctx.AndroidStaticRule("ccAsmListing",
+		blueprint.RuleParams{
+			Depfile:     "${out}.d",
+			Deps:        blueprint.DepsGCC,
+			Command:     "$relPwd $ccCmd -S $cFlags -MD -MF ${out}.d -o $out $in",
+			CommandDeps: []string{"$ccCmd"},
+		},
+		"ccCmd", "cFlags")
+
+	// Rule to check that a header compiles on its own as C.
+	cHeaderCheck = pctx.AndroidStaticRule("cHeaderCheck",
 		blueprint.RuleParams{
 			Depfile:     "${out}.d",
 			Deps:        blueprint.DepsGCC,
-			Command:     "$relPwd ${config.CcWrapper}$ccCmd -c $cFlags -MD -MF ${out}.d -o $out $in",
+			Command:     "$relPwd $ccCmd -x c -fsyntax-only $cFlags -MD -MF ${out}.d $in && touch $out",
 			CommandDeps: []string{"$ccCmd"},
 		},
 		"ccCmd", "cFlags")
@@ -125,6 +183,90 @@ var (
 		},
 		"objcopyCmd", "prefix")
 
+	// Rule to print a warning once, when the timestamp is first built
+	buildWarningRule = pctx.AndroidStaticRule("buildWarning",
+		blueprint.RuleParams{
+			Command: "echo ${message} >&2 && touch ${out}",
+		},
+		"message")
+
+	// Rule to package split DWARF files into a DWARF package file
+	dwp = pctx.AndroidStaticRule("dwp",
+		blueprint.RuleParams{
+			Command:        "rm -f ${out} && ${config.ClangBin}/llvm-dwp @${out}.rsp -o ${out}",
+			CommandDeps:    []string{"${config.ClangBin}/llvm-dwp"},
+			Rspfile:        "${out}.rsp",
+			RspfileContent: "${in}",
+		})
+
+	// Rule to build an llvm-bolt instrumented copy of a shared library
+	boltInstrument = pctx.AndroidStaticRule("boltInstrument",
+		blueprint.RuleParams{
+			Command:     "rm -f ${out} && ${config.ClangBin}/llvm-bolt -instrument ${in} -o ${out}",
+			CommandDeps: []string{"${config.ClangBin}/llvm-bolt"},
+		})
+
+	// Rule to count the dynamic relocations of a shared library by relocation type
+	relocStats = pctx.AndroidStaticRule("relocStats",
+		blueprint.RuleParams{
+			Command: "${config.ClangBin}/llvm-readelf --relocs --wide ${in} | " +
+				"awk '$$3 ~ /^R_/ { count[$$3]++ } END { for (type in count) print type, count[type] }' | " +
+				"LC_ALL=C sort > ${out}.tmp && " + restatReplace,
+			CommandDeps: []string{"${config.ClangBin}/llvm-readelf"},
+			Restat:      true,
+		})
+
+	// Rules to write compressed copies of a file, by compression format
+	compressRules = map[string]blueprint.Rule{
+		"gzip": pctx.AndroidStaticRule("gzipCompress",
+			blueprint.RuleParams{
+				Command:     "${minigzipCmd} -9 -c ${in} > ${out}",
+				CommandDeps: []string{"${minigzipCmd}"},
+			}),
+		"zstd": pctx.AndroidStaticRule("zstdCompress",
+			blueprint.RuleParams{
+				Command:     "${zstdCmd} -q -f -19 ${in} -o ${out}",
+				CommandDeps: []string{"${zstdCmd}"},
+			}),
+		"lz4": pctx.AndroidStaticRule("lz4Compress",
+			blueprint.RuleParams{
+				Command:     "${lz4Cmd} -q -f -12 ${in} ${out}",
+				CommandDeps: []string{"${lz4Cmd}"},
+			}),
+	}
+
+	// File extensions of the compressed copies written by compressRules
+	compressExtensions = map[string]string{
+		"gzip": ".gz",
+		"zstd": ".zst",
+		"lz4":  ".lz4",
+	}
+
+	// Rule to write the SHA-256 checksum of a file in the format of sha256sum, relative to the
+	// directory of the file so that it can be verified next to it with sha256sum -c
+	checksum = pctx.AndroidStaticRule("checksum",
+		blueprint.RuleParams{
+			Command: "(cd $$(dirname ${in}) && sha256sum $$(basename ${in})) > ${out}",
+		})
+
+	// Rule to run objcopy --add-section to add a section with the contents of a file
+	addSection = pctx.AndroidStaticRule("addSection",
+		blueprint.RuleParams{
+			Command: "rm -f ${out} && $objcopyCmd --add-section ${section}=${sectionFile} " +
+				"--set-section-flags ${section}=readonly ${in} ${out}",
+			CommandDeps: []string{"$objcopyCmd"},
+		},
+		"objcopyCmd", "section", "sectionFile")
+
+	// Rule to check that an ELF file has a section
+	checkSection = pctx.AndroidStaticRule("checkSection",
+		blueprint.RuleParams{
+			Command: "${config.ClangBin}/llvm-readelf --section-headers --wide ${in} | grep -qF ' ${section} ' || " +
+				"(echo \"error: ${in} has no ${section} section\" >&2 && false) && touch ${out}",
+			CommandDeps: []string{"${config.ClangBin}/llvm-readelf"},
+		},
+		"section")
+
 	// Rule to run objcopy --remove-section=.llvm_addrsig on a partially linked object
 	noAddrSig = pctx.AndroidStaticRule("noAddrSig",
 		blueprint.RuleParams{
@@ -138,21 +280,31 @@ var (
 	_ = pctx.SourcePathVariable("createMiniDebugInfo", "prebuilts/build-tools/${config.HostPrebuiltTag}/bin/create_minidebuginfo")
 
 	// Rule to invoke `strip` (to discard symbols and data from object files).
-	strip = pctx.AndroidStaticRule("strip",
-		blueprint.RuleParams{
-			Depfile: "${out}.d",
-			Deps:    blueprint.DepsGCC,
-			Command: "XZ=$xzCmd CREATE_MINIDEBUGINFO=$createMiniDebugInfo CLANG_BIN=${config.ClangBin} $stripPath ${args} -i ${in} -o ${out} -d ${out}.d",
-			CommandDeps: func() []string {
-				if runtime.GOOS != "darwin" {
-					return []string{"$stripPath", "$xzCmd", "$createMiniDebugInfo"}
-				} else {
-					return []string{"$stripPath", "$xzCmd"}
-				}
-			}(),
-			Pool: darwinStripPool,
-		},
-		"args")
+	gnuStripCmd = "${config.LinuxGccRoot}/${config.LinuxGccTriple}/bin/strip"
+
+	stripRuleParams = blueprint.RuleParams{
+		Depfile: "${out}.d",
+		Deps:    blueprint.DepsGCC,
+		Command: "XZ=$xzCmd CREATE_MINIDEBUGINFO=$createMiniDebugInfo CLANG_BIN=${config.ClangBin} " +
+			"GNU_STRIP=" + gnuStripCmd + " $stripPath ${args} -i ${in} -o ${out} -d ${out}.d",
+		CommandDeps: func() []string {
+			if runtime.GOOS != "darwin" {
+				return []string{"$stripPath", "$xzCmd", "$createMiniDebugInfo"}
+			} else {
+				return []string{"$stripPath", "$xzCmd"}
+			}
+		}(),
+		Pool: darwinStripPool,
+	}
+
+	strip = pctx.AndroidStaticRule("strip", stripRuleParams, "args")
+
+	// Rule identical to strip, that also depends on the GNU strip it runs for use_gnu_strip.
+	gnuStrip = pctx.AndroidStaticRule("gnuStrip", func() blueprint.RuleParams {
+		params := stripRuleParams
+		params.CommandDeps = append(android.CopyOf(params.CommandDeps), gnuStripCmd)
+		return params
+	}(), "args")
 
 	// Rule to invoke `strip` (to discard symbols and data from object files) on darwin architecture.
 	darwinStrip = pctx.AndroidStaticRule("darwinStrip",
@@ -191,6 +343,16 @@ var (
 		},
 		"objects")
 
+	// Rule to drop the objects of an archive (.a) that are not reachable from a set of root
+	// symbols.
+	trimArchive = pctx.AndroidStaticRule("trimArchive",
+		blueprint.RuleParams{
+			Command: "rm -f ${out} && $trimArchiveCmd --nm ${config.ClangBin}/llvm-nm " +
+				"--ar ${config.ClangBin}/llvm-ar --roots ${roots} --output ${out} ${in}",
+			CommandDeps: []string{"$trimArchiveCmd", "${config.ClangBin}/llvm-nm", "${config.ClangBin}/llvm-ar"},
+		},
+		"roots")
+
 	// Rule to create an empty file at a given path.
 	emptyFile = pctx.AndroidStaticRule("emptyFile",
 		blueprint.RuleParams{
@@ -210,6 +372,49 @@ var (
 		},
 		"clangBin", "format")
 
+	// A rule for extracting a table of contents from a shared library (.so) with a script
+	// configured by the product instead of toc.sh. The script takes the same arguments.
+	customToc = pctx.AndroidStaticRule("customToc",
+		blueprint.RuleParams{
+			Depfile: "${out}.d",
+			Deps:    blueprint.DepsGCC,
+			Command: "CLANG_BIN=$clangBin $tocCmd $format -i ${in} -o ${out} -d ${out}.d",
+			Restat:  true,
+		},
+		"clangBin", "tocCmd", "format")
+
+	// A rule for writing the sorted list of global symbols defined by a static library (.a),
+	// without the archive member headers that llvm-nm prints.
+	symbolIndex = pctx.AndroidStaticRule("symbolIndex",
+		blueprint.RuleParams{
+			Command: "$nmCmd --defined-only --extern-only --format=just-symbols ${in} | " +
+				"sed -e '/:$$/d' -e '/^$$/d' | LC_ALL=C sort -u > ${out}.tmp && " + restatReplace,
+			CommandDeps: []string{"$nmCmd"},
+			Restat:      true,
+		},
+		"nmCmd")
+
+	// A rule for writing the sorted list of symbols exported by a shared library (.so). The symbol
+	// versions that llvm-nm appends to the names (e.g. foo@@LIBFOO_1) are dropped.
+	exportedSymbols = pctx.AndroidStaticRule("exportedSymbols",
+		blueprint.RuleParams{
+			Command: "$nmCmd -D --defined-only --extern-only --format=just-symbols ${in} | " +
+				"sed 's/@.*//' | LC_ALL=C sort -u > ${out}.tmp && " + restatReplace,
+			CommandDeps: []string{"$nmCmd"},
+			Restat:      true,
+		},
+		"nmCmd")
+
+	// A rule for writing the sorted list of symbols imported by a shared library (.so).
+	importedSymbols = pctx.AndroidStaticRule("importedSymbols",
+		blueprint.RuleParams{
+			Command: "$nmCmd -D --undefined-only --format=just-symbols ${in} | " +
+				"LC_ALL=C sort -u > ${out}.tmp && " + restatReplace,
+			CommandDeps: []string{"$nmCmd"},
+			Restat:      true,
+		},
+		"nmCmd")
+
 	// Rules for invoking clang-tidy (a clang-based linter).
 	clangTidy, clangTidyRE = pctx.RemoteStaticRules("clangTidy",
 		blueprint.RuleParams{
@@ -347,6 +552,10 @@ func init() {
 	pctx.StaticVariable("relPwd", PwdPrefix())
 
 	pctx.HostBinToolVariable("SoongZipCmd", "soong_zip")
+	pctx.HostBinToolVariable("minigzipCmd", "minigzip")
+	pctx.HostBinToolVariable("zstdCmd", "zstd")
+	pctx.HostBinToolVariable("lz4Cmd", "lz4")
+	pctx.HostBinToolVariable("trimArchiveCmd", "trim_archive")
 }
 
 // builderFlags contains various types of command line flags (and settings) for use in building
@@ -390,6 +599,17 @@ type builderFlags struct {
 	sAbiDump      bool
 	emitXrefs     bool
 
+	compileCommands bool // True if the compile command of each source should be recorded.
+
+	maxConcurrentCompiles int // If non-zero, the number of sources that may be compiled concurrently.
+
+	preprocessSrcs android.Paths // Sources to also write the preprocessed output (.i) of.
+	asmListingSrcs android.Paths // Sources to also write the assembly listing (.s) of.
+
+	perFileCflags map[string][]string // Extra cflags of single sources, keyed by source path.
+
+	splitDwarf bool // True if the debug info of C and C++ sources is written to .dwo files.
+
 	assemblerWithCpp bool // True if .s files should be processed with the c preprocessor.
 
 	systemIncludeFlags string
@@ -410,6 +630,7 @@ type StripFlags struct {
 	StripKeepSymbolsList          string
 	StripKeepSymbolsAndDebugFrame bool
 	StripKeepMiniDebugInfo        bool
+	StripKeepDynamicOnly          bool
 	StripAddGnuDebuglink          bool
 	StripUseGnuStrip              bool
 }
@@ -422,6 +643,26 @@ type Objects struct {
 	coverageFiles android.Paths
 	sAbiDumpFiles android.Paths
 	kytheFiles    android.Paths
+
+	// The preprocessed outputs of the sources listed in builderFlags.preprocessSrcs.
+	preprocessedFiles android.Paths
+
+	// The assembly listings of the sources listed in builderFlags.asmListingSrcs.
+	asmListingFiles android.Paths
+
+	// The split DWARF files of the objects, only written if builderFlags.splitDwarf is set.
+	dwoFiles android.Paths
+
+	// The compile commands of the sources, only recorded if builderFlags.compileCommands is set.
+	compileCommands []compileCommand
+}
+
+// compileCommand is the command used to compile a single source file, as recorded in a
+// compile_commands.json fragment.
+type compileCommand struct {
+	src     android.Path
+	obj     android.Path
+	command string
 }
 
 func (a Objects) Copy() Objects {
@@ -432,6 +673,12 @@ func (a Objects) Copy() Objects {
 		coverageFiles: append(android.Paths{}, a.coverageFiles...),
 		sAbiDumpFiles: append(android.Paths{}, a.sAbiDumpFiles...),
 		kytheFiles:    append(android.Paths{}, a.kytheFiles...),
+
+		preprocessedFiles: append(android.Paths{}, a.preprocessedFiles...),
+		asmListingFiles:   append(android.Paths{}, a.asmListingFiles...),
+		dwoFiles:          append(android.Paths{}, a.dwoFiles...),
+
+		compileCommands: append([]compileCommand{}, a.compileCommands...),
 	}
 }
 
@@ -443,6 +690,12 @@ func (a Objects) Append(b Objects) Objects {
 		coverageFiles: append(a.coverageFiles, b.coverageFiles...),
 		sAbiDumpFiles: append(a.sAbiDumpFiles, b.sAbiDumpFiles...),
 		kytheFiles:    append(a.kytheFiles, b.kytheFiles...),
+
+		preprocessedFiles: append(a.preprocessedFiles, b.preprocessedFiles...),
+		asmListingFiles:   append(a.asmListingFiles, b.asmListingFiles...),
+		dwoFiles:          append(a.dwoFiles, b.dwoFiles...),
+
+		compileCommands: append(a.compileCommands, b.compileCommands...),
 	}
 }
 
@@ -476,6 +729,27 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 	if flags.emitXrefs {
 		kytheFiles = make(android.Paths, 0, len(srcFiles))
 	}
+	var preprocessedFiles android.Paths
+	var dwoFiles android.Paths
+	preprocessSrcsMap := make(map[string]bool)
+	for _, path := range flags.preprocessSrcs {
+		preprocessSrcsMap[path.String()] = true
+	}
+	var asmListingFiles android.Paths
+	asmListingSrcsMap := make(map[string]bool)
+	for _, path := range flags.asmListingSrcs {
+		asmListingSrcsMap[path.String()] = true
+	}
+	var compileCommands []compileCommand
+	noCompileCommandsSrcs := make(map[string]bool)
+	if flags.compileCommands {
+		compileCommands = make([]compileCommand, 0, len(srcFiles))
+		// Sources excluded from clang-tidy are excluded from the compile_commands.json fragment
+		// too, as it is mostly consumed by the same kind of tooling.
+		for _, path := range noTidySrcs {
+			noCompileCommandsSrcs[path.String()] = true
+		}
+	}
 
 	// Produce fully expanded flags for use by C tools, C compiles, C++ tools, C++ compiles, and asm compiles
 	// respectively.
@@ -601,6 +875,7 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 		dump := flags.sAbiDump
 		rule := cc
 		emitXref := flags.emitXrefs
+		splitDwarf := flags.splitDwarf
 
 		switch srcFile.Ext() {
 		case ".s":
@@ -615,6 +890,7 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 			coverage = false
 			dump = false
 			emitXref = false
+			splitDwarf = false
 		case ".c":
 			ccCmd = "clang"
 			moduleFlags = cflags
@@ -631,6 +907,15 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 			continue
 		}
 
+		if rule == cc && flags.maxConcurrentCompiles > 0 {
+			rule = ccLimited[flags.maxConcurrentCompiles-1]
+		}
+
+		if extraFlags := flags.perFileCflags[srcFile.String()]; len(extraFlags) > 0 {
+			moduleFlags += " " + strings.Join(extraFlags, " ")
+			moduleToolingFlags += " " + strings.Join(extraFlags, " ")
+		}
+
 		// ccCmd is "clang" or "clang++"
 		ccDesc := ccCmd
 
@@ -642,6 +927,11 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 			implicitOutputs = append(implicitOutputs, gcnoFile)
 			coverageFiles = append(coverageFiles, gcnoFile)
 		}
+		if splitDwarf {
+			dwoFile := android.ObjPathWithExt(ctx, subdir, srcFile, "dwo")
+			implicitOutputs = append(implicitOutputs, dwoFile)
+			dwoFiles = append(dwoFiles, dwoFile)
+		}
 
 		ctx.Build(pctx, android.BuildParams{
 			Rule:            rule,
@@ -657,6 +947,53 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 			},
 		})
 
+		if preprocessSrcsMap[srcFile.String()] && rule != ccNoDeps {
+			// Preprocessed C++ is recognized by the .ii extension, .i is preprocessed C.
+			preprocessedExt := "i"
+			if ccDesc == "clang++" {
+				preprocessedExt = "ii"
+			}
+			preprocessedFile := android.ObjPathWithExt(ctx, subdir, srcFile, preprocessedExt)
+			ctx.Build(pctx, android.BuildParams{
+				Rule:        ccPreprocess,
+				Description: "preprocess " + srcFile.Rel(),
+				Output:      preprocessedFile,
+				Input:       srcFile,
+				Implicits:   cFlagsDeps,
+				OrderOnly:   pathDeps,
+				Args: map[string]string{
+					"cFlags": shareFlags("cFlags", moduleFlags),
+					"ccCmd":  ccCmd,
+				},
+			})
+			preprocessedFiles = append(preprocessedFiles, preprocessedFile)
+		}
+
+		if asmListingSrcsMap[srcFile.String()] && rule != ccNoDeps {
+			asmListingFile := android.ObjPathWithExt(ctx, subdir, srcFile, "s")
+			ctx.Build(pctx, android.BuildParams{
+				Rule:        ccAsmListing,
+				Description: "asm listing " + srcFile.Rel(),
+				Output:      asmListingFile,
+				Input:       srcFile,
+				Implicits:   cFlagsDeps,
+				OrderOnly:   pathDeps,
+				Args: map[string]string{
+					"cFlags": shareFlags("cFlags", moduleFlags),
+					"ccCmd":  ccCmd,
+				},
+			})
+			asmListingFiles = append(asmListingFiles, asmListingFile)
+		}
+
+		if flags.compileCommands && !noCompileCommandsSrcs[srcFile.String()] {
+			compileCommands = append(compileCommands, compileCommand{
+				src:     srcFile,
+				obj:     objFile,
+				command: ccCmd + " -c " + moduleFlags + " -o " + objFile.String() + " " + srcFile.String(),
+			})
+		}
+
 		// Register post-process build statements (such as for tidy or kythe).
 		if emitXref {
 			kytheFile := android.ObjPathWithExt(ctx, subdir, srcFile, "kzip")
@@ -743,9 +1080,71 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 		coverageFiles: coverageFiles,
 		sAbiDumpFiles: sAbiDumpFiles,
 		kytheFiles:    kytheFiles,
+
+		preprocessedFiles: preprocessedFiles,
+		asmListingFiles:   asmListingFiles,
+		dwoFiles:          dwoFiles,
+
+		compileCommands: compileCommands,
 	}
 }
 
+// Generate a rule checking that a header compiles on its own as C, with the C flags of a module.
+// The returned timestamp file is only written when the header has no C++-only constructs.
+func transformHeaderToCSyntaxCheck(ctx ModuleContext, header android.Path, flags builderFlags,
+	pathDeps android.Paths) android.Path {
+
+	timestampFile := android.PathForModuleOut(ctx, "c_api_headers", header.Rel()+".c_check.timestamp")
+	cflags := flags.globalCommonFlags + " " +
+		flags.globalCFlags + " " +
+		flags.globalConlyFlags + " " +
+		flags.localCommonFlags + " " +
+		flags.localCFlags + " " +
+		flags.localConlyFlags + " " +
+		flags.systemIncludeFlags
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        cHeaderCheck,
+		Description: "check C header " + header.Rel(),
+		Output:      timestampFile,
+		Input:       header,
+		OrderOnly:   pathDeps,
+		Args: map[string]string{
+			"ccCmd":  "${config.ClangBin}/clang",
+			"cFlags": cflags,
+		},
+	})
+	return timestampFile
+}
+
+// Generate a rule for writing the compile_commands.json fragment of a module. The ninja variables
+// referenced by the commands are resolved, which is only possible in a singleton.
+func transformCompileCommandsToFragment(ctx android.SingletonContext, module android.Module,
+	commands []compileCommand, outputFile android.WritablePath) {
+
+	entries := make([]compDbEntry, 0, len(commands))
+	for _, c := range commands {
+		command, err := ctx.Eval(pctx, c.command)
+		if err != nil {
+			ctx.ModuleErrorf(module, "failed to resolve the compile command of %s: %s", c.src, err)
+			return
+		}
+		entries = append(entries, compDbEntry{
+			Directory: android.AbsSrcDirForExistingUseCases(),
+			Command:   command,
+			File:      c.src.String(),
+			Output:    c.obj.String(),
+		})
+	}
+	content, err := json.Marshal(entries)
+	if err != nil {
+		ctx.ModuleErrorf(module, "failed to marshal compile commands: %s", err)
+		return
+	}
+
+	android.WriteFileRuleVerbatim(ctx, outputFile, string(content))
+}
+
 // Generate a rule for compiling multiple .o files to a static library (.a)
 func transformObjToStaticLib(ctx android.ModuleContext,
 	objFiles android.Paths, wholeStaticLibs android.Paths,
@@ -921,6 +1320,20 @@ func transformDumpToLinkedDump(ctx android.ModuleContext, sAbiDumps android.Path
 	return android.OptionalPathForPath(outputFile)
 }
 
+// Generate a rule for writing a JSON summary of the exported functions, variables and types of
+// a linked ABI dump
+func transformLinkedDumpToJsonSummary(ctx android.ModuleContext, linkedDump android.Path) android.Path {
+	outputFile := android.PathForModuleOut(ctx, linkedDump.Base()+".summary.json")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		BuiltTool("lsdump_to_json_summary").
+		Input(linkedDump).
+		FlagWithOutput("--output ", outputFile)
+	rule.Build("abiJsonSummary", "abi json summary "+linkedDump.Base())
+
+	return outputFile
+}
+
 func transformAbiDumpToAbiDiff(ctx android.ModuleContext, inputDump, referenceDump android.Path,
 	baseName, nameExt string, extraFlags []string, errorMessage string) android.Path {
 
@@ -961,6 +1374,23 @@ func TransformSharedObjectToToc(ctx android.ModuleContext, inputFile android.Pat
 		format = "--elf"
 	}
 
+	if script := ctx.Config().TocScript(ctx.Os()); script != "" {
+		tocCmd := android.PathForSource(ctx, script)
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        customToc,
+			Description: "generate toc " + inputFile.Base(),
+			Output:      outputFile,
+			Input:       inputFile,
+			Implicit:    tocCmd,
+			Args: map[string]string{
+				"clangBin": "${config.ClangBin}",
+				"tocCmd":   tocCmd.String(),
+				"format":   format,
+			},
+		})
+		return
+	}
+
 	ctx.Build(pctx, android.BuildParams{
 		Rule:        toc,
 		Description: "generate toc " + inputFile.Base(),
@@ -999,6 +1429,190 @@ func transformObjsToObj(ctx android.ModuleContext, objFiles android.Paths,
 	})
 }
 
+// Generate a rule for checking that the members of a static library have zeroed timestamps, uids
+// and gids. Returns the timestamp file to use as a validation of the archive.
+func transformStaticLibToDeterminismCheck(ctx android.ModuleContext, inputFile android.Path) android.Path {
+	timestampFile := android.PathForModuleOut(ctx, inputFile.Base()+".deterministic.timestamp")
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		BuiltTool("check_deterministic_archive").
+		Input(inputFile).
+		FlagWithOutput("--stamp ", timestampFile)
+	rule.Build("checkDeterministicArchive", "check deterministic archive "+inputFile.Base())
+
+	return timestampFile
+}
+
+// Generate a rule running a symbol visibility audit tool over a shared library. The returned
+// timestamp file is only written when the tool finds no denied symbol exported.
+func transformSharedObjectToSymbolVisibilityAudit(ctx android.ModuleContext, tool, denylist,
+	inputFile android.Path) android.Path {
+
+	timestampFile := android.PathForModuleOut(ctx, inputFile.Base()+".symbol_visibility_audit.timestamp")
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		Tool(tool).
+		FlagWithInput("--denylist ", denylist).
+		Input(inputFile)
+	rule.Command().Text("touch").Output(timestampFile)
+	rule.Build("symbolVisibilityAudit", "symbol visibility audit "+inputFile.Base())
+
+	return timestampFile
+}
+
+// Generate a rule running the index-th post link validator over a shared library. The returned
+// timestamp file is only written when the validator succeeds.
+func transformSharedObjectToPostLinkValidation(ctx android.ModuleContext, index int, tool android.Path,
+	args []string, inputFile android.Path) android.Path {
+
+	timestampFile := android.PathForModuleOut(ctx, "post_link_validators", strconv.Itoa(index)+".timestamp")
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		Tool(tool).
+		Flags(args).
+		Input(inputFile)
+	rule.Command().Text("touch").Output(timestampFile)
+	rule.Build("postLinkValidator"+strconv.Itoa(index),
+		fmt.Sprintf("post link validator %d %s", index, inputFile.Base()))
+
+	return timestampFile
+}
+
+// Generate a rule for listing the symbols exported by a shared library, one per line
+func transformSharedObjectToExportedSymbols(ctx android.ModuleContext, inputFile android.Path) android.Path {
+	symbolList := android.PathForModuleOut(ctx, inputFile.Base()+".exported_symbols")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        exportedSymbols,
+		Description: "exported symbols " + inputFile.Base(),
+		Output:      symbolList,
+		Input:       inputFile,
+		Args: map[string]string{
+			"nmCmd": "${config.ClangBin}/llvm-nm",
+		},
+	})
+	return symbolList
+}
+
+// Generate a rule for listing the undefined dynamic symbols of a shared library, one per line
+func transformSharedObjectToImportedSymbols(ctx android.ModuleContext, inputFile android.Path,
+	outputFile android.WritablePath) {
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        importedSymbols,
+		Description: "imported symbols " + inputFile.Base(),
+		Output:      outputFile,
+		Input:       inputFile,
+		Args: map[string]string{
+			"nmCmd": "${config.ClangBin}/llvm-nm",
+		},
+	})
+}
+
+// Generate a rule checking that a shared library exports none of the denied symbols, given the list
+// of the symbols it exports
+func transformExportedSymbolsToDenylistCheck(ctx android.ModuleContext, libName string,
+	symbolList android.Path, denylist []string) android.Path {
+
+	timestampFile := android.PathForModuleOut(ctx, libName+".exported_symbol_denylist.timestamp")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		Text("if grep -Fx").
+		FlagForEachArg("-e ", proptools.ShellEscapeList(denylist)).
+		Input(symbolList).
+		Text("; then echo").
+		Text(proptools.ShellEscape(fmt.Sprintf("error: %s exports the symbols listed above, which "+
+			"are denied by the product's ExportedSymbolDenylist", libName))).
+		Text(">&2; exit 1; fi")
+	rule.Command().Text("touch").Output(timestampFile)
+	rule.Build("exportedSymbolDenylist", "check exported symbol denylist "+libName)
+
+	return timestampFile
+}
+
+// Generate a rule comparing the symbols exported by a shared library with a checked-in golden
+// list. The returned timestamp file is only written when both lists are identical.
+func transformSharedObjectToGoldenExportedSymbolsCheck(ctx android.ModuleContext, golden,
+	inputFile, symbolList android.Path) android.Path {
+
+	timestampFile := android.PathForModuleOut(ctx, inputFile.Base()+".golden_exported_symbols.timestamp")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		Text("diff -u").Input(golden).Input(symbolList).
+		Text("|| (echo").
+		Text(proptools.ShellEscape(fmt.Sprintf("error: the symbols exported by %s differ from %s. "+
+			"If the change is intended, update the golden list with: cp %s %s",
+			inputFile.Base(), golden.String(), symbolList.String(), golden.String()))).
+		Text("&& exit 1)")
+	rule.Command().Text("touch").Output(timestampFile)
+	rule.Build("goldenExportedSymbols", "check exported symbols "+inputFile.Base())
+
+	return timestampFile
+}
+
+// Generate a rule for checking that exported headers only include headers under exportedDirs
+func transformHeadersToClosureCheck(ctx android.ModuleContext, headers android.Paths,
+	exportedDirs, includeDirs android.Paths) android.Path {
+
+	timestampFile := android.PathForModuleOut(ctx, "exported_header_closure.timestamp")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().
+		BuiltTool("check_exported_header_closure").
+		FlagForEachArg("--exported-dir ", exportedDirs.Strings()).
+		FlagForEachArg("--include-dir ", includeDirs.Strings()).
+		FlagWithOutput("--stamp ", timestampFile)
+	cmd.Inputs(headers)
+	rule.Build("exportedHeaderClosure", "check exported header closure "+ctx.ModuleName())
+
+	return timestampFile
+}
+
+// Generate a rule for writing the symbols exported by the global sections of a version script
+func transformVersionScriptToSymbolList(ctx android.ModuleContext, versionScript android.Path,
+	outputFile android.WritablePath) {
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		BuiltTool("version_script_symbols").
+		Input(versionScript).
+		FlagWithOutput("--output ", outputFile)
+	rule.Build("versionScriptSymbols", "exported symbols "+outputFile.Base())
+}
+
+// Generate a rule for dropping the objects of a static library that are not reachable from the
+// symbols listed in rootsFile
+func transformStaticLibToTrimmed(ctx android.ModuleContext, inputFile, rootsFile android.Path,
+	outputFile android.WritablePath) {
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        trimArchive,
+		Description: "trim archive " + outputFile.Base(),
+		Output:      outputFile,
+		Input:       inputFile,
+		Implicit:    rootsFile,
+		Args: map[string]string{
+			"roots": rootsFile.String(),
+		},
+	})
+}
+
+// Generate a rule for writing the symbol index (.a.sym) of a static library
+func transformStaticLibToSymbolIndex(ctx android.ModuleContext, inputFile android.Path,
+	outputFile android.WritablePath) {
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        symbolIndex,
+		Description: "symbol index " + inputFile.Base(),
+		Output:      outputFile,
+		Input:       inputFile,
+		Args: map[string]string{
+			"nmCmd": "${config.ClangBin}/llvm-nm",
+		},
+	})
+}
+
 // Generate a rule for running objcopy --prefix-symbols on a binary
 func transformBinaryPrefixSymbols(ctx android.ModuleContext, prefix string, inputFile android.Path,
 	flags builderFlags, outputFile android.WritablePath) {
@@ -1017,6 +1631,116 @@ func transformBinaryPrefixSymbols(ctx android.ModuleContext, prefix string, inpu
 	})
 }
 
+// Generate a rule for printing a warning about this module once, when <name>.timestamp is first
+// built, and return the timestamp file to depend on.
+func buildWarning(ctx android.ModuleContext, name, message string) android.Path {
+	timestampFile := android.PathForModuleOut(ctx, name+".timestamp")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        buildWarningRule,
+		Description: "check " + strings.ReplaceAll(name, "_", " ") + " " + ctx.ModuleName(),
+		Output:      timestampFile,
+		Args: map[string]string{
+			"message": proptools.ShellEscapeIncludingSpaces("warning: " + ctx.ModuleName() + ": " + message),
+		},
+	})
+	return timestampFile
+}
+
+// Generate a rule for packaging split DWARF files into a DWARF package file
+func transformDwoFilesToDwp(ctx android.ModuleContext, dwoFiles android.Paths, outputFile android.WritablePath) {
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        dwp,
+		Description: "dwp " + outputFile.Base(),
+		Output:      outputFile,
+		Inputs:      dwoFiles,
+	})
+}
+
+// Generate a rule for instrumenting a shared library with llvm-bolt
+func transformSharedObjectToBoltInstrumented(ctx android.ModuleContext, inputFile android.Path,
+	outputFile android.WritablePath) {
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        boltInstrument,
+		Description: "bolt instrument " + outputFile.Base(),
+		Output:      outputFile,
+		Input:       inputFile,
+	})
+}
+
+// Generate a rule for writing the number of relocations of each type in a shared library
+func transformSharedObjectToRelocStats(ctx android.ModuleContext, inputFile android.Path,
+	outputFile android.WritablePath) {
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        relocStats,
+		Description: "relocation stats " + inputFile.Base(),
+		Output:      outputFile,
+		Input:       inputFile,
+	})
+}
+
+// Generate a rule for writing a copy of a file compressed with the given format, one of the keys
+// of compressRules
+func transformFileToCompressedCopy(ctx android.ModuleContext, inputFile android.Path,
+	outputFile android.WritablePath, format string) {
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        compressRules[format],
+		Description: format + " " + inputFile.Base(),
+		Output:      outputFile,
+		Input:       inputFile,
+	})
+}
+
+// Generate a rule for writing the SHA-256 checksum of a file
+func transformFileToChecksum(ctx android.ModuleContext, inputFile android.Path,
+	outputFile android.WritablePath) {
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        checksum,
+		Description: "sha256 " + inputFile.Base(),
+		Output:      outputFile,
+		Input:       inputFile,
+	})
+}
+
+// Generate a rule for running objcopy --add-section on a shared library
+func transformSharedObjectAddSection(ctx android.ModuleContext, inputFile android.Path,
+	outputFile android.WritablePath, section string, sectionFile android.Path) {
+
+	objcopyCmd := "${config.ClangBin}/llvm-objcopy"
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        addSection,
+		Description: "add section " + section + " " + outputFile.Base(),
+		Output:      outputFile,
+		Input:       inputFile,
+		Implicit:    sectionFile,
+		Args: map[string]string{
+			"objcopyCmd":  objcopyCmd,
+			"section":     section,
+			"sectionFile": sectionFile.String(),
+		},
+	})
+}
+
+// Generate a rule for checking that an ELF file has a section, and return the timestamp file to
+// depend on
+func transformElfToSectionCheck(ctx android.ModuleContext, inputFile android.Path, section string) android.Path {
+	timestampFile := android.PathForModuleOut(ctx, "check_section", strings.TrimPrefix(section, ".")+".timestamp")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        checkSection,
+		Description: "check section " + section + " " + inputFile.Base(),
+		Output:      timestampFile,
+		Input:       inputFile,
+		Args: map[string]string{
+			"section": section,
+		},
+	})
+	return timestampFile
+}
+
 // Generate a rule for running objcopy --remove-section=.llvm_addrsig on a partially linked object
 func transformObjectNoAddrSig(ctx android.ModuleContext, inputFile android.Path, outputFile android.WritablePath) {
 	objcopyCmd := "${config.ClangBin}/llvm-objcopy"
@@ -1052,12 +1776,20 @@ func transformStrip(ctx android.ModuleContext, inputFile android.Path,
 	if flags.StripKeepSymbolsAndDebugFrame {
 		args += " --keep-symbols-and-debug-frame"
 	}
+	if flags.StripKeepDynamicOnly {
+		args += " --keep-dynamic-symbols-only"
+	}
 	if ctx.Windows() {
 		args += " --windows"
 	}
+	rule := strip
+	if flags.StripUseGnuStrip && !ctx.Darwin() {
+		args += " --use-gnu-strip"
+		rule = gnuStrip
+	}
 
 	ctx.Build(pctx, android.BuildParams{
-		Rule:        strip,
+		Rule:        rule,
 		Description: "strip " + outputFile.Base(),
 		Output:      outputFile,
 		Input:       inputFile,
@@ -80,6 +80,8 @@ func RegisterCCBuildComponents(ctx android.RegistrationContext) {
 		ctx.BottomUp("lto", ltoMutator).Parallel()
 
 		ctx.BottomUp("check_linktype", checkLinkTypeMutator).Parallel()
+		ctx.BottomUp("check_allowed_dependents_partitions", checkAllowedDependentsPartitionsMutator).Parallel()
+		ctx.BottomUp("check_dlopen_only", checkDlopenOnlyMutator).Parallel()
 		ctx.TopDown("double_loadable", checkDoubleLoadableLibraries).Parallel()
 	})
 
@@ -89,6 +91,7 @@ func RegisterCCBuildComponents(ctx android.RegistrationContext) {
 	})
 
 	ctx.RegisterParallelSingletonType("kythe_extract_all", kytheExtractAllFactory)
+	ctx.RegisterParallelSingletonType("compile_commands_fragments", compileCommandsFragmentsSingletonFactory)
 }
 
 // Deps is a struct containing module names of dependencies, separated by the kind of dependency.
@@ -109,6 +112,10 @@ type Deps struct {
 	// prevent automatically exporting symbols.
 	UnexportedStaticLibs []string
 
+	// ImplementationWholeStaticLibs are the subset of WholeStaticLibs whose exported headers are
+	// not reexported.
+	ImplementationWholeStaticLibs []string
+
 	// Used for data dependencies adjacent to tests
 	DataLibs []string
 	DataBins []string
@@ -124,6 +131,9 @@ type Deps struct {
 
 	ReexportSharedLibHeaders, ReexportStaticLibHeaders, ReexportHeaderLibHeaders []string
 
+	// Libraries whose exported system include directories are used as regular ones.
+	NonSystemIncludeLibs []string
+
 	ObjFiles []string
 
 	GeneratedSources []string
@@ -196,6 +206,13 @@ type PathDeps struct {
 
 	// Paths to direct srcs and transitive include dirs from direct aidl_library deps
 	AidlLibraryInfos []aidl_library.AidlLibraryInfo
+
+	// Map from the name of each shared library dependency linked against a stub variant to the
+	// version of the stub.
+	ResolvedStubVersions map[string]string
+
+	// Hash of the flags the reused objects of the static variant were compiled with.
+	ReuseObjectsFlagsHash string
 }
 
 // LocalOrGlobalFlags contains flags that need to have values set globally by the build system or locally by the module
@@ -239,6 +256,22 @@ type Flags struct {
 	SAbiDump      bool // True if header abi dumps should be generated.
 	EmitXrefs     bool // If true, generate Ninja rules to generate emitXrefs input files for Kythe
 
+	// True if the compile command of each source should be recorded for a compile_commands.json
+	// fragment.
+	CompileCommands bool
+
+	// If non-zero, the number of sources of the module that may be compiled concurrently.
+	MaxConcurrentCompiles int
+
+	// Sources whose preprocessed output should be written in addition to their object file.
+	PreprocessSrcs android.Paths
+
+	// Sources whose assembly listing should be written in addition to their object file.
+	AsmListingSrcs android.Paths
+
+	// Extra cflags of single sources, keyed by the path of the source.
+	PerFileCflags map[string][]string
+
 	// The instruction set required for clang ("arm" or "thumb").
 	RequiredInstructionSet string
 	// The target-device system path to the dynamic linker.
@@ -718,6 +751,9 @@ type libraryDependencyTag struct {
 	dataLib             bool
 	ndk                 bool
 
+	// If true, the exported system include directories of the dependency are used with -I.
+	nonSystemIncludes bool
+
 	staticUnwinder bool
 
 	makeSuffix string
@@ -2134,6 +2170,7 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 		ctx.SetProvider(testing.TestModuleProviderKey, testing.TestModuleProviderData{})
 	}
 	ctx.SetProvider(blueprint.SrcsFileProviderKey, blueprint.SrcsFileProviderData{SrcPaths: deps.GeneratedSources.Strings()})
+	ctx.SetProvider(ResolvedStubVersionsProvider, ResolvedStubVersionsInfo{Versions: deps.ResolvedStubVersions})
 
 	aconfig.CollectDependencyAconfigFiles(ctx, &c.mergedAconfigFiles)
 
@@ -2271,6 +2308,12 @@ func (c *Module) deps(ctx DepsContext) Deps {
 		}
 	}
 
+	for _, lib := range deps.NonSystemIncludeLibs {
+		if !inList(lib, deps.HeaderLibs) && !inList(lib, deps.StaticLibs) && !inList(lib, deps.SharedLibs) {
+			ctx.PropertyErrorf("non_system_include_libs", "Library not in header_libs, static_libs or shared_libs: '%s'", lib)
+		}
+	}
+
 	for _, gen := range deps.ReexportGeneratedHeaders {
 		if !inList(gen, deps.GeneratedHeaders) {
 			ctx.PropertyErrorf("export_generated_headers", "Generated header module not in generated_headers: '%s'", gen)
@@ -2570,6 +2613,9 @@ func (c *Module) DepsMutator(actx android.BottomUpMutatorContext) {
 		if inList(lib, deps.ReexportHeaderLibHeaders) {
 			depTag.reexportFlags = true
 		}
+		if inList(lib, deps.NonSystemIncludeLibs) {
+			depTag.nonSystemIncludes = true
+		}
 
 		// Check header lib replacement from API surface first, and then check again with VSDK
 		if c.shouldUseApiSurface() {
@@ -2599,7 +2645,8 @@ func (c *Module) DepsMutator(actx android.BottomUpMutatorContext) {
 	}
 
 	for _, lib := range deps.WholeStaticLibs {
-		depTag := libraryDependencyTag{Kind: staticLibraryDependency, wholeStatic: true, reexportFlags: true}
+		depTag := libraryDependencyTag{Kind: staticLibraryDependency, wholeStatic: true,
+			reexportFlags: !inList(lib, deps.ImplementationWholeStaticLibs)}
 
 		lib = GetReplaceModuleName(lib, GetSnapshot(c, &snapshotInfo, actx).StaticLibs)
 
@@ -2613,6 +2660,9 @@ func (c *Module) DepsMutator(actx android.BottomUpMutatorContext) {
 		if inList(lib, deps.ReexportStaticLibHeaders) {
 			depTag.reexportFlags = true
 		}
+		if inList(lib, deps.NonSystemIncludeLibs) {
+			depTag.nonSystemIncludes = true
+		}
 		if inList(lib, deps.ExcludeLibsForApex) {
 			depTag.excludeInApex = true
 		}
@@ -2642,6 +2692,9 @@ func (c *Module) DepsMutator(actx android.BottomUpMutatorContext) {
 		if inList(lib, deps.ReexportSharedLibHeaders) {
 			depTag.reexportFlags = true
 		}
+		if inList(lib, deps.NonSystemIncludeLibs) {
+			depTag.nonSystemIncludes = true
+		}
 		if inList(lib, deps.ExcludeLibsForApex) {
 			depTag.excludeInApex = true
 		}
@@ -2981,6 +3034,17 @@ func checkDoubleLoadableLibraries(ctx android.TopDownMutatorContext) {
 	}
 }
 
+// consumerApiLevel returns the API level of the min_sdk_version of the module, which is the
+// future API level if it isn't built against a specific API level.
+func consumerApiLevel(ctx ModuleContext) android.ApiLevel {
+	if ver := ctx.minSdkVersion(); ver != "" {
+		if apiLevel, err := android.ApiLevelFromUser(ctx, ver); err == nil {
+			return apiLevel
+		}
+	}
+	return android.FutureApiLevel
+}
+
 func findApexSdkVersion(ctx android.BaseModuleContext, apexInfo android.ApexInfo) android.ApiLevel {
 	// For the dependency from platform to apex, use the latest stubs
 	apexSdkVersion := android.FutureApiLevel
@@ -2999,11 +3063,12 @@ func findApexSdkVersion(ctx android.BaseModuleContext, apexInfo android.ApexInfo
 }
 
 // Convert dependencies to paths.  Returns a PathDeps containing paths
-func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
+func (c *Module) depsToPaths(ctx ModuleContext) PathDeps {
 	var depPaths PathDeps
 
 	var directStaticDeps []StaticLibraryInfo
 	var directSharedDeps []SharedLibraryInfo
+	var deprecatedIncludeDirUses []string
 
 	reexportExporter := func(exporter FlagExporterInfo) {
 		depPaths.ReexportedDirs = append(depPaths.ReexportedDirs, exporter.IncludeDirs...)
@@ -3147,6 +3212,7 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 				staticAnalogue := ctx.OtherModuleProvider(dep, StaticLibraryInfoProvider).(StaticLibraryInfo)
 				objs := staticAnalogue.ReuseObjects
 				depPaths.Objs = depPaths.Objs.Append(objs)
+				depPaths.ReuseObjectsFlagsHash = staticAnalogue.ReuseObjectsFlagsHash
 				depExporterInfo := ctx.OtherModuleProvider(dep, FlagExporterInfoProvider).(FlagExporterInfo)
 				reexportExporter(depExporterInfo)
 			}
@@ -3198,6 +3264,13 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 				sharedLibraryInfo, returnedDepExporterInfo := ChooseStubOrImpl(ctx, dep)
 				depExporterInfo = returnedDepExporterInfo
 
+				if version := resolvedStubVersion(ctx, dep, sharedLibraryInfo); version != "" {
+					if depPaths.ResolvedStubVersions == nil {
+						depPaths.ResolvedStubVersions = make(map[string]string)
+					}
+					depPaths.ResolvedStubVersions[depName] = version
+				}
+
 				// Stubs lib doesn't link to the shared lib dependencies. Don't set
 				// linkFile, depFile, and ptr.
 				if c.IsStubs() {
@@ -3264,6 +3337,14 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 					case earlyLibraryDependency:
 						panic(fmt.Errorf("early static libs not suppported"))
 					case normalLibraryDependency:
+						if staticLibraryInfo.AlwayslinkInApex && !apexInfo.IsForPlatform() && !c.static() {
+							// The library asked to be linked whole into APEX binaries and
+							// shared libraries. Its own static dependencies are still ordered
+							// with the other transitive static dependencies.
+							directStaticDeps = append(directStaticDeps, staticLibraryInfo)
+							ptr = &depPaths.WholeStaticLibs
+							break
+						}
 						// static dependencies will be handled separately so they can be ordered
 						// using transitive dependencies.
 						ptr = nil
@@ -3323,9 +3404,21 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 			}
 
 			depPaths.IncludeDirs = append(depPaths.IncludeDirs, depExporterInfo.IncludeDirs...)
-			depPaths.SystemIncludeDirs = append(depPaths.SystemIncludeDirs, depExporterInfo.SystemIncludeDirs...)
+			if libDepTag.nonSystemIncludes {
+				depPaths.IncludeDirs = append(depPaths.IncludeDirs, depExporterInfo.SystemIncludeDirs...)
+			} else {
+				depPaths.SystemIncludeDirs = append(depPaths.SystemIncludeDirs, depExporterInfo.SystemIncludeDirs...)
+			}
 			depPaths.GeneratedDeps = append(depPaths.GeneratedDeps, depExporterInfo.Deps...)
 			depPaths.Flags = append(depPaths.Flags, depExporterInfo.Flags...)
+			for _, macro := range depExporterInfo.ConsumerApiLevelMacros {
+				depPaths.Flags = append(depPaths.Flags,
+					"-D"+macro+"="+strconv.Itoa(consumerApiLevel(ctx).FinalOrPreviewInt()))
+			}
+			if len(depExporterInfo.DeprecatedIncludeDirs) > 0 {
+				deprecatedIncludeDirUses = append(deprecatedIncludeDirUses, fmt.Sprintf("%s (%s)",
+					depName, strings.Join(depExporterInfo.DeprecatedIncludeDirs.Strings(), ", ")))
+			}
 
 			if libDepTag.reexportFlags {
 				reexportExporter(depExporterInfo)
@@ -3394,6 +3487,14 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 		}
 	})
 
+	if len(deprecatedIncludeDirUses) > 0 {
+		// Compiling any source of this module prints the warning, once as the timestamp is kept.
+		depPaths.GeneratedDeps = append(depPaths.GeneratedDeps,
+			buildWarning(ctx, "deprecated_include_dirs", "depends on libraries exporting deprecated "+
+				"include directories, stop including headers through them: "+
+				strings.Join(android.FirstUniqueStrings(deprecatedIncludeDirUses), "; ")))
+	}
+
 	// use the ordered dependencies as this module's dependencies
 	orderedStaticPaths, transitiveStaticLibs := orderStaticModuleDeps(directStaticDeps, directSharedDeps)
 	depPaths.TranstiveStaticLibrariesForOrdering = transitiveStaticLibs
@@ -3529,6 +3630,25 @@ func ChooseStubOrImpl(ctx android.ModuleContext, dep android.Module) (SharedLibr
 	return sharedLibraryInfo, depExporterInfo
 }
 
+// resolvedStubVersion returns the version of the stubs of dep that sharedLibraryInfo, as returned
+// by ChooseStubOrImpl, belongs to, or "" if it is the implementation of the library.
+func resolvedStubVersion(ctx android.ModuleContext, dep android.Module, sharedLibraryInfo SharedLibraryInfo) string {
+	if linkable, ok := dep.(LinkableInterface); ok && linkable.IsStubs() {
+		return linkable.StubsVersion()
+	}
+	if sharedLibraryInfo.SharedLibrary == nil {
+		return ""
+	}
+	stubsInfo := ctx.OtherModuleProvider(dep, SharedLibraryStubsProvider).(SharedLibraryStubsInfo)
+	for _, stub := range stubsInfo.SharedStubLibraries {
+		if stub.SharedLibraryInfo.SharedLibrary != nil &&
+			stub.SharedLibraryInfo.SharedLibrary.String() == sharedLibraryInfo.SharedLibrary.String() {
+			return stub.Version
+		}
+	}
+	return ""
+}
+
 // orderStaticModuleDeps rearranges the order of the static library dependencies of the module
 // to match the topological order of the dependency tree, including any static analogues of
 // direct shared libraries.  It returns the ordered static dependencies, and an android.DepSet
@@ -3671,6 +3791,66 @@ func (c *Module) OutputFiles(tag string) (android.Paths, error) {
 			return android.PathsIfNonNil(c.linker.strippedAllOutputFilePath()), nil
 		}
 		return nil, nil
+	case ".compile_commands":
+		if library, ok := c.linker.(*libraryDecorator); ok && library.compileCommandsFile.Valid() {
+			return android.Paths{library.compileCommandsFile.Path()}, nil
+		}
+		return nil, nil
+	case ".bolt_instrumented":
+		if library, ok := c.linker.(*libraryDecorator); ok && library.boltInstrumentedOutputFile.Valid() {
+			return android.Paths{library.boltInstrumentedOutputFile.Path()}, nil
+		}
+		return nil, nil
+	case ".preprocessed":
+		if library, ok := c.linker.(*libraryDecorator); ok {
+			return library.preprocessedFiles, nil
+		}
+		return nil, nil
+	case ".asm_listing":
+		if library, ok := c.linker.(*libraryDecorator); ok {
+			return library.asmListingFiles, nil
+		}
+		return nil, nil
+	case ".headers_zip":
+		if library, ok := c.linker.(*libraryDecorator); ok && library.headersZip.Valid() {
+			return android.Paths{library.headersZip.Path()}, nil
+		}
+		return nil, nil
+	case ".dwp":
+		if library, ok := c.linker.(*libraryDecorator); ok && library.dwpFile.Valid() {
+			return android.Paths{library.dwpFile.Path()}, nil
+		}
+		return nil, nil
+	case ".reloc_stats":
+		if library, ok := c.linker.(*libraryDecorator); ok && library.relocStatsFile.Valid() {
+			return android.Paths{library.relocStatsFile.Path()}, nil
+		}
+		return nil, nil
+	case ".compressed":
+		if library, ok := c.linker.(*libraryDecorator); ok {
+			return library.compressedFiles, nil
+		}
+		return nil, nil
+	case ".imports":
+		if library, ok := c.linker.(*libraryDecorator); ok && library.importsFile.Valid() {
+			return android.Paths{library.importsFile.Path()}, nil
+		}
+		return nil, nil
+	case ".abi_summary":
+		if library, ok := c.linker.(*libraryDecorator); ok && library.sAbiJsonSummary.Valid() {
+			return android.Paths{library.sAbiJsonSummary.Path()}, nil
+		}
+		return nil, nil
+	case ".sha256":
+		if library, ok := c.linker.(*libraryDecorator); ok && library.checksumFile.Valid() {
+			return android.Paths{library.checksumFile.Path()}, nil
+		}
+		return nil, nil
+	case ".pc":
+		if library, ok := c.linker.(*libraryDecorator); ok && library.pkgConfigFile.Valid() {
+			return android.Paths{library.pkgConfigFile.Path()}, nil
+		}
+		return nil, nil
 	default:
 		return nil, fmt.Errorf("unsupported module reference tag %q", tag)
 	}
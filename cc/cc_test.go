@@ -22,6 +22,7 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 
 	"android/soong/aidl_library"
@@ -3139,7 +3140,7 @@ func TestVersionedStubs(t *testing.T) {
 
 	libBarLinkRule := ctx.ModuleForTests("libBar", "android_arm64_armv8-a_shared").Rule("ld")
 	libFlags := libBarLinkRule.Args["libFlags"]
-	libFoo1StubPath := "libFoo/android_arm64_armv8-a_shared_1/libFoo.so"
+	libFoo1StubPath := "libFoo/android_arm64_armv8-a_shared_1/stubs/1/libFoo.so"
 	if !strings.Contains(libFlags, libFoo1StubPath) {
 		t.Errorf("%q is not found in %q", libFoo1StubPath, libFlags)
 	}
@@ -3247,7 +3248,7 @@ func TestStubsForLibraryInMultipleApexes(t *testing.T) {
 		}
 	}
 
-	libFooStubPath := "libFoo/android_arm64_armv8-a_shared_current/libFoo.so"
+	libFooStubPath := "libFoo/android_arm64_armv8-a_shared_current/stubs/current/libFoo.so"
 	for _, lib := range linkAgainstFooStubs {
 		libLinkRule := ctx.ModuleForTests(lib, "android_arm64_armv8-a_shared").Rule("ld")
 		libFlags := libLinkRule.Args["libFlags"]
@@ -3270,6 +3271,60 @@ func TestVersioningMacro(t *testing.T) {
 	}
 }
 
+func TestVersioningMacroNameConflictsConcurrently(t *testing.T) {
+	t.Parallel()
+	config := TestConfig(t.TempDir(), android.Android, nil, "", nil)
+
+	const numModules = 1000
+	owners := make([]string, 2*numModules)
+	var wg sync.WaitGroup
+	for i := 0; i < numModules; i++ {
+		// "libfoo-N" and "libfoo.N" share the macro name __LIBFOO_N_API__.
+		for j, name := range []string{fmt.Sprintf("libfoo-%d", i), fmt.Sprintf("libfoo.%d", i)} {
+			wg.Add(1)
+			go func(index int, name string) {
+				defer wg.Done()
+				owners[index] = registerVersioningMacroName(config, versioningMacroName(name), name)
+			}(2*i+j, name)
+		}
+	}
+	wg.Wait()
+
+	for i := 0; i < numModules; i++ {
+		dash, dot := owners[2*i], owners[2*i+1]
+		if dash != dot {
+			t.Fatalf("modules %d registered different owners %q and %q for the same macro name", i, dash, dot)
+		}
+		if dash != fmt.Sprintf("libfoo-%d", i) && dash != fmt.Sprintf("libfoo.%d", i) {
+			t.Fatalf("unexpected owner %q for module %d", dash, i)
+		}
+	}
+
+	// Registering again keeps the first owner, and the same module can register again.
+	first := owners[0]
+	android.AssertStringEquals(t, "owner after re-registering", first,
+		registerVersioningMacroName(config, "__LIBFOO_0_API__", "libfoo-0"))
+	android.AssertStringEquals(t, "owner after re-registering", first,
+		registerVersioningMacroName(config, "__LIBFOO_0_API__", first))
+
+	testCcError(t, `Macro name "__LIBFOO_V1_API__" for versioning conflicts with macro name from module "libfoo[-.]v1"`, `
+		cc_library {
+			name: "libfoo-v1",
+			srcs: ["foo.c"],
+			stubs: {
+				versions: ["29"],
+			},
+		}
+
+		cc_library {
+			name: "libfoo.v1",
+			srcs: ["foo.c"],
+			stubs: {
+				versions: ["29"],
+			},
+		}`)
+}
+
 func pathsToBase(paths android.Paths) []string {
 	var ret []string
 	for _, p := range paths {
@@ -54,7 +54,8 @@ const (
 // A compdb entry. The compile_commands.json file is a list of these.
 type compDbEntry struct {
 	Directory string   `json:"directory"`
-	Arguments []string `json:"arguments"`
+	Arguments []string `json:"arguments,omitempty"`
+	Command   string   `json:"command,omitempty"`
 	File      string   `json:"file"`
 	Output    string   `json:"output,omitempty"`
 }
@@ -199,3 +200,23 @@ func evalAndSplitVariable(ctx android.SingletonContext, str string) ([]string, e
 	}
 	return []string{""}, err
 }
+
+// compileCommandsFragmentsSingleton writes the compile_commands.json fragments of the libraries
+// with generate_compile_commands. Unlike modules, singletons can resolve the ninja variables
+// referenced by the compile commands.
+type compileCommandsFragmentsSingleton struct{}
+
+func compileCommandsFragmentsSingletonFactory() android.Singleton {
+	return &compileCommandsFragmentsSingleton{}
+}
+
+func (c *compileCommandsFragmentsSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	ctx.VisitAllModules(func(module android.Module) {
+		if ccModule, ok := module.(*Module); ok {
+			if library, ok := ccModule.linker.(*libraryDecorator); ok && library.compileCommandsFile.Valid() {
+				transformCompileCommandsToFragment(ctx, module, library.compileCommands,
+					library.compileCommandsFile.Path().(android.WritablePath))
+			}
+		}
+	})
+}
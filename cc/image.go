@@ -261,6 +261,32 @@ var _ ImageMutatableModule = (*Module)(nil)
 func (m *Module) ImageMutatorBegin(mctx android.BaseModuleContext) {
 	m.CheckVndkProperties(mctx)
 	MutateImage(mctx, m)
+	m.restrictToPlatformIfNeeded(mctx)
+}
+
+// restrictToPlatformIfNeeded drops the vendor, product and recovery variants of a library with
+// `platform_only: true`, so that modules in those partitions can't depend on it.
+func (m *Module) restrictToPlatformIfNeeded(mctx android.BaseModuleContext) {
+	library, ok := m.linker.(*libraryDecorator)
+	if !ok || !Bool(library.Properties.Platform_only) {
+		return
+	}
+
+	if mctx.SocSpecific() || mctx.DeviceSpecific() || mctx.ProductSpecific() || m.InstallInRecovery() {
+		mctx.PropertyErrorf("platform_only",
+			"doesn't make sense for a module installed in the vendor, product or recovery partition")
+		return
+	}
+	if m.VendorAvailable() || m.OdmAvailable() || m.ProductAvailable() || m.RecoveryAvailable() ||
+		m.NeedsLlndkVariants() || m.NeedsVendorPublicLibraryVariants() {
+		mctx.PropertyErrorf("platform_only",
+			"doesn't make sense at the same time as `vendor_available`, `odm_available`, "+
+				"`product_available`, `recovery_available`, `llndk` or `vendor_public_library`")
+		return
+	}
+
+	m.Properties.ExtraVersionedImageVariations = nil
+	m.SetRecoveryVariantNeeded(false)
 }
 
 // CheckVndkProperties checks whether the VNDK-related properties are set correctly.
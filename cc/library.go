@@ -15,6 +15,7 @@
 package cc
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"path/filepath"
@@ -24,6 +25,7 @@ import (
 	"sync"
 
 	"android/soong/android"
+	"android/soong/cc/config"
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/pathtools"
 	"github.com/google/blueprint/proptools"
@@ -38,12 +40,130 @@ type LibraryProperties struct {
 	// local file name to pass to the linker as -force_symbols_weak_list
 	Force_symbols_weak_list *string `android:"path,arch_variant"`
 
+	// local file name to pass to lld as -Wl,--symbol-ordering-file to lay out the functions of
+	// the shared library in the listed order, e.g. to speed up startup. Only applies to shared
+	// libraries, and can't be used together with orderfile.load_order_file.
+	Symbol_ordering_file *string `android:"path,arch_variant"`
+
+	// compression applied by lld to the debug sections of the shared library, passed as
+	// -Wl,--compress-debug-sections. One of "none", "zlib" or "zstd". This only shrinks the
+	// unstripped output, so it is only useful when debug info is kept.
+	Compress_debug_sections *string `android:"arch_variant"`
+
+	// the linker to link the shared library with instead of lld, passed as -fuse-ld. One of "lld",
+	// "gold", "bfd" or "mold". Only meant for experiments such as benchmarking link times; linkers
+	// other than lld must be added to the bin directory of the clang prebuilts, as linkers from the
+	// host are not used.
+	Use_linker *string
+
+	// runpath entries added to a host shared library with -Wl,-rpath. Each entry must be
+	// "$ORIGIN" or start with "$ORIGIN/" so that it is resolved relative to the library. Not
+	// allowed on device, where libraries are found through the linker namespaces instead, nor
+	// on Windows.
+	Runpaths []string `android:"arch_variant"`
+
+	// ensure that the library is compiled with -ffunction-sections and -fdata-sections on targets
+	// where it is linked with -Wl,--gc-sections, even if its cflags turn them off. Each function
+	// and variable then gets its own section that the linker can drop when it is unreferenced,
+	// which usually shrinks the stripped output at the cost of slightly larger objects.
+	Split_sections *bool `android:"arch_variant"`
+
+	// Run a tool over the linked shared library to check that none of the symbols listed in the
+	// denylist is exported. The tool is invoked as `<tool> --denylist <denylist> <library>` and
+	// must exit with a non-zero status when a denied symbol is visible.
+	Symbol_visibility_audit struct {
+		// the tool to run, either a source file or the output of a module (":module").
+		Tool *string `android:"path"`
+
+		// the file listing the symbols that must not be exported.
+		Denylist *string `android:"path"`
+	}
+
+	// Extra checks to run over the linked shared library, such as verifying that it has no
+	// TEXTRELs or that its sections are aligned. Each tool is run with its args followed by the
+	// path of the linked library, and fails the build by exiting with a non-zero status.
+	Post_link_validators []PostLinkValidatorProperties
+
+	// Link the shared library with --allow-multiple-definition, so that the first definition of
+	// a symbol wins instead of duplicate definitions being an error. This is a last resort for
+	// legacy static libraries with conflicting definitions that can't be fixed; it hides real
+	// ODR violations. It only applies to the link of this library, not to its dependents.
+	Allow_multiple_definition *bool
+
+	// Don't pass -nostdlib when linking the shared library for Bionic, so that the compiler
+	// driver adds its default startup files and libraries. Only needed by a few special shared
+	// objects that rely on the default startup code. Soong still adds crtbegin_so and
+	// crtend_so unless nocrt is also set, which is usually wanted to avoid linking them twice.
+	No_nostdlib *bool
+
+	// Assert that the shared library links without undefined symbols, so that missing
+	// dependencies fail the build instead of the dlopen at runtime. -Wl,--no-undefined is passed
+	// after the ldflags of the module, so that flags like -Wl,-z,undefs can't turn the check off,
+	// and it can't be combined with allow_undefined_symbols. Symbols must be resolved by
+	// shared_libs, including the system_shared_libs. It has no effect on Darwin and Windows, which
+	// don't allow undefined symbols anyway, and for host sanitizers, whose runtimes are only
+	// linked into executables.
+	Check_no_undefined *bool
+
+	// Also build a copy of the shared library instrumented by llvm-bolt to collect a profile for
+	// BOLT optimization. The instrumented copy is available as the
+	// ":<module>{.bolt_instrumented}" output and is not installed. Only supported for ELF
+	// libraries on arm64 and x86_64; the library is linked with --emit-relocs for BOLT to be able
+	// to rewrite it.
+	Bolt_instrument *bool
+
+	// Add a section with build metadata to the shared library, for provenance. The section is
+	// added before stripping, so the strip properties decide whether it is kept in the installed
+	// library. Only supported for ELF libraries.
+	Build_metadata_section struct {
+		// name of the section, e.g. ".note.android.build_metadata". The section is only added if
+		// this is set.
+		Name *string
+
+		// "key=value" entries, written one per line to the section. Values may reference
+		// $(module), $(platform_version), $(platform_sdk_version) and $(build_id).
+		Entries []string
+	}
+
+	// Add a non-loadable section with the contents of a license or NOTICE identifier file to the
+	// shared library, for compliance scanning. The section is added after stripping, and the
+	// build checks that the installed library has it. Only supported for ELF libraries.
+	License_section struct {
+		// name of the section, e.g. ".note.android.license".
+		Name *string
+
+		// file whose contents are written to the section.
+		File *string `android:"path"`
+	}
+
+	// A checked-in file listing the symbols exported by the shared library, one per line in
+	// sorted order. The build fails if the symbols actually exported differ from the list, so
+	// that changes to the exported symbols show up in code review.
+	Golden_exported_symbols *string `android:"path,arch_variant"`
+
 	// rename host libraries to prevent overlap with system installed libraries
 	Unique_host_soname *bool
 
+	// the DT_SONAME to record in the shared library instead of its file name. Used when a
+	// library is renamed but has to stay loadable by binaries linked against the old name. The
+	// shared library suffix is appended if it is missing.
+	Soname *string
+
+	// the install name to record in the shared library on Darwin instead of
+	// @rpath/<file name>, e.g. "@loader_path/../lib/libfoo.dylib" or an absolute path. It must
+	// start with "/", "@rpath/", "@loader_path/" or "@executable_path/" and only contain letters,
+	// digits and "_@./+-". It is validated for all OSes, but only used on Darwin.
+	Darwin_install_name *string `android:"arch_variant"`
+
 	Aidl struct {
 		// export headers generated from .aidl sources
 		Export_aidl_headers *bool
+
+		// export the headers generated from .aidl sources only under a directory named after the
+		// module, so that they must be included as "<module>/<header>" and can't collide with the
+		// aidl headers of other libraries. The aidl gen directory is only on the include path of
+		// this module. Only used if export_aidl_headers is set.
+		Export_aidl_headers_in_module_dir *bool
 	}
 
 	Proto struct {
@@ -54,6 +174,10 @@ type LibraryProperties struct {
 	Sysprop struct {
 		// Whether platform owns this sysprop library.
 		Platform *bool
+
+		// If set, the exported sysprop headers are also staged under this prefix in an exported
+		// include directory, so that they can be included as <prefix/...>.
+		Export_prefix *string
 	} `blueprint:"mutated"`
 
 	Static_ndk_lib *bool
@@ -64,6 +188,11 @@ type LibraryProperties struct {
 		// symbols that are exported for stubs variant of this library.
 		Symbol_file *string `android:"path"`
 
+		// Relative paths to symbol map fragments that are combined into the symbol map of the
+		// stubs. A version or a symbol must not be defined by more than one fragment. Can't be
+		// used together with symbol_file.
+		Symbol_files []string `android:"path"`
+
 		// List versions to generate stubs libs for. The version name "current" is always
 		// implicitly added.
 		Versions []string
@@ -73,6 +202,13 @@ type LibraryProperties struct {
 		// implementation is made available by some other means, e.g. in a Microdroid
 		// virtual machine.
 		Implementation_installable *bool
+
+		// Whether to not create the stubs variants for the vendor variant of the library, e.g.
+		// because no vendor module links against them. Doesn't affect LLNDK stubs.
+		Skip_on_vendor *bool
+
+		// Whether to not create the stubs variants for the product variant of the library.
+		Skip_on_product *bool
 	}
 
 	// set the name of the output
@@ -82,7 +218,7 @@ type LibraryProperties struct {
 	Suffix *string `android:"arch_variant"`
 
 	// Properties for ABI compatibility checker.
-	Header_abi_checker headerAbiCheckerProperties
+	Header_abi_checker headerAbiCheckerProperties `android:"arch_variant"`
 
 	Target struct {
 		Vendor, Product struct {
@@ -104,6 +240,224 @@ type LibraryProperties struct {
 		}
 	}
 
+	// Check that the shared variant of this library exports the same include directories as
+	// the static variant, so that dependents see the same headers regardless of how they link
+	// against it.
+	Check_variant_export_consistency *bool
+
+	// Don't warn when the static and shared variants of this library reexport the headers of
+	// different libraries through static.export_*_lib_headers and shared.export_*_lib_headers,
+	// for libraries whose variants intentionally export different headers.
+	Allow_variant_reexport_divergence *bool
+
+	// Header that is force-included with -include in every source of the modules that depend on
+	// this library. Must be under one of the export_include_dirs or export_system_include_dirs.
+	Export_required_header *string `android:"path"`
+
+	// The oldest C++ standard that the exported headers compile with, e.g. "c++17". A generated
+	// header is force-included in every source of the modules that depend on this library, which
+	// fails the compilation of their C++ sources with an #error naming this library if they are
+	// compiled with an older standard. C sources are not affected.
+	Export_min_cpp_std *string
+
+	// Generate a header defining version macros, e.g. from the platform version of the build,
+	// and export it to the dependents of this library.
+	Generate_version_header versionHeaderProperties
+
+	// Exported headers that make up a C API of this library. Each of them is compiled on its own
+	// as C, with the flags of the library, to check that no C++ leaks into the API. Must be under
+	// one of the export_include_dirs or export_system_include_dirs.
+	C_api_headers []string `android:"path"`
+
+	// Only build this library for the system partition: no vendor, product or recovery variant is
+	// created, so modules in those partitions can't depend on it.
+	Platform_only *bool
+
+	// Check that each of the export_include_dirs contains at least one header file, to catch
+	// exported directories that don't exist or are misspelled.
+	Check_exported_includes_nonempty *bool
+
+	// Check that the headers in the export_include_dirs only #include headers that are exported
+	// too, by this library or reexported from its dependencies, so that they don't break when a
+	// dependent includes them. The check runs when the library is built.
+	Check_exported_header_closure *bool
+
+	// Package the LLVM bitcode objects of the static library into a <name>.bc.a archive, for
+	// whole-program analyses. Requires `lto: { thin: true }`.
+	Emit_bitcode *bool
+
+	// Link the whole static library (as with -Wl,--whole-archive) into the binaries and shared
+	// libraries of APEXes that list it in static_libs, so that objects that are only reached at
+	// runtime, such as self-registering plugins, are kept. Platform dependents, including test
+	// binaries, link it as a regular static library and only pull in the objects they reference.
+	Alwayslink_in_apex *bool
+
+	// Check that the members of the static library have zeroed timestamps, uids and gids, as
+	// required for reproducible builds.
+	Verify_deterministic *bool
+
+	// Write the sorted list of global symbols defined by the static library to a .a.sym file
+	// next to the archive, so that archives defining the same symbols can be detected.
+	Emit_symbol_index *bool
+
+	// Experimental: drop the objects of the static library that are not reachable from the
+	// symbols listed in roots_symbol_file, e.g. the objects of large whole_static_libs that no
+	// consumer of a curated product uses. An object is kept if it defines a root symbol or a
+	// symbol referenced by a kept object. Only the archive is trimmed, modules that include this
+	// library in their whole_static_libs still get all of its objects.
+	Gc_unreferenced_objects *bool
+
+	// File listing the root symbols of gc_unreferenced_objects, one per line. Lines starting with
+	// # are comments.
+	Roots_symbol_file *string `android:"path"`
+
+	// Maximum number of sources of this library compiled concurrently, from 1 to 8, for libraries
+	// with translation units that use too much memory to be compiled in parallel. The limit is
+	// shared by all the libraries that set the same value.
+	Max_concurrent_compiles *int64
+
+	// Write a compile_commands.json fragment listing the compile command of each source of this
+	// library, excluding those in tidy_disabled_srcs. The fragment is available as the
+	// ":<module>{.compile_commands}" output so it can be concatenated with those of other modules.
+	// The ninja variables referenced by the commands, e.g. ${config.ClangBin}, are resolved so the
+	// fragment can be used as a compilation database as is.
+	Generate_compile_commands *bool
+
+	// Build a zip of the headers exported by this library, laid out relative to the exported
+	// include directories, for consumers outside of Soong. The zip is available as the
+	// ":<module>{.headers_zip}" output.
+	Generate_headers_zip *bool
+
+	// Generate a pkg-config file describing the host variants of this library, for build systems
+	// outside of Soong. The include and library paths in it are relative to the root of the
+	// source tree. The file is installed next to the host shared library under pkgconfig/, and is
+	// available as the ":<module>{.pc}" output.
+	Pkg_config struct {
+		// name of the pkg-config package, and of the generated <name>.pc file. The file is only
+		// generated if this is set.
+		Name *string
+
+		// human readable description of the package.
+		Description *string
+
+		// version of the package.
+		Version *string
+
+		// additional flags for the Cflags field, after the exported include directories.
+		Cflags []string
+
+		// additional flags for the Libs field, after the flags to link this library.
+		Libs []string
+	}
+
+	// A subset of srcs to also write the preprocessed output of, .i for C sources and .ii for
+	// C++ sources, e.g. to debug macro expansion. The preprocessed files are available as the ":<module>{.preprocessed}" output.
+	Preprocess_srcs []string `android:"path,arch_variant"`
+
+	// A subset of srcs to also write the assembly listing (.s) of, e.g. to verify the code
+	// generated for hot functions. The listings are available as the ":<module>{.asm_listing}"
+	// output.
+	Asm_listing_srcs []string `android:"path,arch_variant"`
+
+	// If true, a shared-only library also gets a static variant in builds of tests, i.e. when the
+	// TestStaticVariants product variable is set, so that tests can link it statically for
+	// isolation. Production builds are not affected.
+	Test_static_variant *bool
+
+	// Extra cflags to compile a subset of srcs with, e.g. to lower the optimization level of a
+	// single source that is miscompiled. The flags are appended after all other cflags.
+	Per_file_cflags []PerFileCflagsProperties
+
+	// The regular expression passed to clang-tidy as -header-filter when linting the sources of
+	// this library, e.g. "^path/to/lib/include/" to also lint its own exported headers but not
+	// the headers of third-party dependencies. Defaults to the headers in the module directory.
+	// A -header-filter in tidy_flags takes precedence.
+	Tidy_header_filter *string
+
+	// Check that none of the generated headers exported by this library, e.g. by aidl, proto,
+	// sysprop or a genrule, can be included with the same path as a header in one of its
+	// exported source directories, as dependents would then include either of them depending on
+	// the order of their include directories.
+	Check_generated_header_collisions *bool
+
+	// Check that none of the headers in the exported include directories of this library can be
+	// included with the same path as a standard C, POSIX or C++ header, e.g. "stdlib.h",
+	// "sys/types.h" or "string", as it would shadow the system header for all dependents. Headers
+	// are matched by their path relative to the exported include directory, not by basename.
+	Check_no_system_header_shadowing *bool
+
+	// The paths of standard headers, relative to an exported include directory, that this
+	// library is allowed to shadow despite check_no_system_header_shadowing, e.g. "stdatomic.h".
+	System_header_shadowing_allowlist []string
+
+	// A subset of srcs, including generated sources, whose coverage files are left out of the
+	// coverage zip of this library, e.g. because coverage data of generated code is not
+	// actionable.
+	Coverage_exclude_srcs []string `android:"path,arch_variant"`
+
+	// Package the split DWARF (.dwo) files of the shared library, including the ones of its
+	// whole_static_libs, into a DWARF package file <name>.so.dwp for distribution. Requires split
+	// DWARF to be enabled with -gsplit-dwarf in cflags. The package is available as the
+	// ":<module>{.dwp}" output.
+	Generate_dwp *bool
+
+	// Print a warning when linking the shared library that lists the shared libraries that its
+	// whole_static_libs, directly or through their own whole_static_libs, depend on, since they
+	// become dependencies of this library.
+	Warn_on_whole_static_shared_deps *bool
+
+	// Keep the coverage instrumentation of the shared library when it is built with both LTO and
+	// coverage: the profile and coverage mapping sections are kept from being garbage collected
+	// by the linker, and with clang coverage the unstripped library, which carries the coverage
+	// mapping, is added to the coverage zip of the library.
+	Preserve_coverage_under_lto *bool
+
+	// Write the number of dynamic relocations of each type in the stripped shared library to
+	// <name>.so.reloc_stats, one "<type> <count>" line per relocation type, to track PLT and GOT
+	// growth. The stats are available as the ":<module>{.reloc_stats}" output.
+	Emit_reloc_stats *bool
+
+	// Names of the cc_object modules linked before the other objects of the shared library
+	// instead of the crtbegin object of the toolchain, e.g. for a loader shim with custom startup
+	// code. Set even if nocrt is true.
+	Crt_begin []string `android:"arch_variant"`
+
+	// Names of the cc_object modules linked after the other objects of the shared library
+	// instead of the crtend object of the toolchain. Set even if nocrt is true.
+	Crt_end []string `android:"arch_variant"`
+
+	// Write the sorted list of the undefined dynamic symbols of the shared library, i.e. the
+	// symbols it imports from its dependencies, to <name>.so.imports, one per line, for example
+	// to check that all of them are provided by the libraries of an APEX. The list is available
+	// as the ":<module>{.imports}" output and through ImportedSymbolListInfoProvider.
+	Emit_imports *bool
+
+	// Compression formats, any of "gzip", "zstd" and "lz4", to write compressed copies of the
+	// stripped shared library with, e.g. to compare OTA sizes. The copies are not installed, they
+	// are available as the ":<module>{.compressed}" output for dist.
+	Generate_compressed_copies []string
+
+	// Install the shared library into this subdirectory of the partition instead of the default
+	// lib or lib64, e.g. "lib/hw". It must be a relative path inside the partition. Use
+	// multilib.lib32 and multilib.lib64 to set different subdirectories for each bitness.
+	Library_install_subdir *string `android:"arch_variant"`
+
+	// Write the SHA-256 checksum of the installed shared library to <name>.so.sha256 and install
+	// it next to the library, so that it can be verified with sha256sum -c. The checksum is also
+	// available as the ":<module>{.sha256}" output, and can be copied to the dist directory with
+	// a dist or dists entry with tag: ".sha256".
+	Generate_checksum *bool
+
+	// The partitions whose modules may depend on this library, any of "platform", "vendor",
+	// "product" and "system_ext". Dependencies from modules in other partitions are reported as
+	// errors. If empty, modules of any partition may depend on this library.
+	Allowed_dependents_partitions []string
+
+	// The shared library is a plugin only ever loaded with dlopen, so it must not be in the
+	// DT_NEEDED of any other module. Listing it in shared_libs is reported as an error; depend on
+	// it with runtime_libs or required instead.
+	Dlopen_only *bool
+
 	// Names of modules to be overridden. Listed modules can only be other shared libraries
 	// (in Make or Soong).
 	// This does not completely prevent installation of the overridden libraries, but if both
@@ -122,6 +476,37 @@ type LibraryProperties struct {
 	Vendor_public_library vendorPublicLibraryProperties
 }
 
+// versionHeaderProperties are the properties of the generate_version_header property of a
+// library.
+type versionHeaderProperties struct {
+	// Path of the header relative to the exported include directory it is generated in, e.g.
+	// "foo/foo_version.h". Defaults to "<module name>_version.h".
+	Name *string
+
+	// Macros defined by the header, as "NAME=value" entries. The values can reference the build
+	// variables $(PLATFORM_SDK_VERSION), $(PLATFORM_SDK_CODENAME), $(PLATFORM_VERSION) and
+	// $(PLATFORM_SECURITY_PATCH). String values must be quoted, e.g. "FOO_VERSION=\"1.0\"".
+	Macros []string
+}
+
+// PerFileCflagsProperties is an entry of the per_file_cflags property of a library.
+type PerFileCflagsProperties struct {
+	// The sources to compile with cflags, which must also be listed in srcs.
+	Srcs []string `android:"path"`
+
+	// The flags appended to the cflags of the sources.
+	Cflags []string
+}
+
+// PostLinkValidatorProperties is an entry of the post_link_validators property of a library.
+type PostLinkValidatorProperties struct {
+	// the tool to run, either a source file or the output of a module (":module").
+	Tool *string `android:"path"`
+
+	// The arguments passed to the tool before the path of the linked library.
+	Args []string
+}
+
 // StaticProperties is a properties stanza to affect only attributes of the "static" variants of a
 // library module.
 type StaticProperties struct {
@@ -142,6 +527,9 @@ type SharedProperties struct {
 type StaticOrSharedProperties struct {
 	Srcs []string `android:"path,arch_variant"`
 
+	// list of source files from the common srcs that should not be compiled into this variant
+	Exclude_srcs []string `android:"path,arch_variant"`
+
 	Tidy_disabled_srcs []string `android:"path,arch_variant"`
 
 	Tidy_timeout_srcs []string `android:"path,arch_variant"`
@@ -159,6 +547,11 @@ type StaticOrSharedProperties struct {
 	Export_shared_lib_headers []string `android:"arch_variant"`
 	Export_static_lib_headers []string `android:"arch_variant"`
 
+	// list of static libraries whose objects are all included in this variant, like
+	// whole_static_libs, but whose exported headers are not reexported to the dependents of this
+	// library.
+	Implementation_whole_static_libs []string `android:"arch_variant"`
+
 	Apex_available []string `android:"arch_variant"`
 
 	Installable *bool `android:"arch_variant"`
@@ -188,15 +581,38 @@ type FlagExporterProperties struct {
 	// list of directories relative to the Blueprints file that will
 	// be added to the include path (using -I) for this module and any module that links
 	// against this module.  Directories listed in export_include_dirs do not need to be
-	// listed in local_include_dirs.
+	// listed in local_include_dirs. A ":module" entry exports the include directories of the
+	// headers generated by the genrule module.
 	Export_include_dirs []string `android:"arch_variant,variant_prepend"`
 
+	// list of directories relative to the Blueprints file that are exported like
+	// export_include_dirs, but are deprecated. Every module that depends on this library directly
+	// gets a warning, printed once when it is built, asking it to stop including headers through
+	// these directories.
+	Export_include_dirs_deprecated []string `android:"arch_variant"`
+
 	// list of directories that will be added to the system include path
 	// using -isystem for this module and any module that links against this module.
 	Export_system_include_dirs []string `android:"arch_variant,variant_prepend"`
 
 	// list of plain cc flags to be used for any module that links against this module.
-	Export_cflags []string  `android:"arch_variant"`
+	// Include directories must be exported with export_include_dirs or
+	// export_system_include_dirs instead.
+	Export_cflags []string `android:"arch_variant"`
+
+	// list of -D and -U flags to be used for any module that links against this module. Unlike
+	// export_cflags, any other flag is rejected.
+	Export_defines []string `android:"arch_variant"`
+
+	// list of -D and -U flags that configure the public headers of this library. They are used
+	// both to compile this library and for any module that links against this module, so that
+	// the configuration is always consistent between them.
+	Public_config_flags []string `android:"arch_variant"`
+
+	// name of a macro that is defined, for every module that links against this module
+	// directly, to the API level of the min_sdk_version of that module, e.g. so that the headers
+	// of this library can gate features on the API level of their user.
+	Export_consumer_api_level_macro *string
 
 	Target struct {
 		Vendor, Product struct {
@@ -276,35 +692,95 @@ func LibraryHostSharedFactory() android.Module {
 type flagExporter struct {
 	Properties FlagExporterProperties
 
-	dirs       android.Paths // Include directories to be included with -I
-	systemDirs android.Paths // System include directories to be included with -isystem
-	flags      []string      // Exported raw flags.
-	deps       android.Paths
-	headers    android.Paths
+	dirs           android.Paths // Include directories to be included with -I
+	systemDirs     android.Paths // System include directories to be included with -isystem
+	flags          []string      // Exported raw flags.
+	deps           android.Paths
+	headers        android.Paths
+	deprecatedDirs android.Paths // The subset of dirs that is deprecated
+
+	consumerApiLevelMacros []string // Macros defined to the API level of each direct dependent
 }
 
 // exportedIncludes returns the effective include paths for this module and
 // any module that links against this module. This is obtained from
 // the export_include_dirs property in the appropriate target stanza.
 func (f *flagExporter) exportedIncludes(ctx ModuleContext) android.Paths {
+	dirs, _ := splitGeneratedIncludeDirs(f.exportIncludeDirsForVariant(ctx))
+	return append(android.PathsForModuleSrc(ctx, dirs), f.deprecatedIncludes(ctx)...)
+}
+
+// deprecatedIncludes returns the export_include_dirs_deprecated, which are also part of
+// exportedIncludes.
+func (f *flagExporter) deprecatedIncludes(ctx ModuleContext) android.Paths {
+	return android.PathsForModuleSrc(ctx, f.Properties.Export_include_dirs_deprecated)
+}
+
+// exportIncludeDirsForVariant returns the export_include_dirs of the current variant, taking the
+// vendor and product overrides into account.
+func (f *flagExporter) exportIncludeDirsForVariant(ctx ModuleContextIntf) []string {
 	if ctx.inVendor() && f.Properties.Target.Vendor.Override_export_include_dirs != nil {
-		return android.PathsForModuleSrc(ctx, f.Properties.Target.Vendor.Override_export_include_dirs)
+		return f.Properties.Target.Vendor.Override_export_include_dirs
 	}
 	if ctx.inProduct() && f.Properties.Target.Product.Override_export_include_dirs != nil {
-		return android.PathsForModuleSrc(ctx, f.Properties.Target.Product.Override_export_include_dirs)
+		return f.Properties.Target.Product.Override_export_include_dirs
+	}
+	return f.Properties.Export_include_dirs
+}
+
+// splitGeneratedIncludeDirs splits export_include_dirs into the source directories and the names
+// of the genrule modules whose generated header directories are exported, listed as ":module".
+func splitGeneratedIncludeDirs(exportIncludeDirs []string) (dirs []string, genrules []string) {
+	for _, dir := range exportIncludeDirs {
+		if module := android.SrcIsModule(dir); module != "" {
+			genrules = append(genrules, module)
+		} else {
+			dirs = append(dirs, dir)
+		}
 	}
-	return android.PathsForModuleSrc(ctx, f.Properties.Export_include_dirs)
+	return dirs, genrules
 }
 
 // exportIncludes registers the include directories and system include directories to be exported
 // transitively to modules depending on this module.
 func (f *flagExporter) exportIncludes(ctx ModuleContext) {
 	f.dirs = append(f.dirs, f.exportedIncludes(ctx)...)
+	f.deprecatedDirs = f.deprecatedIncludes(ctx)
 	f.systemDirs = append(f.systemDirs, android.PathsForModuleSrc(ctx, f.Properties.Export_system_include_dirs)...)
 }
 
+// exportExtraFlags registers the export_cflags, export_defines and public_config_flags to be
+// exported transitively to modules depending on this module. Include directories can't be
+// exported this way, and export_defines and public_config_flags are restricted to macro
+// definitions (-D) and undefinitions (-U).
 func (f *flagExporter) exportExtraFlags(ctx ModuleContext) {
-	f.flags = append(f.flags, f.Properties.Export_cflags...)
+	f.reexportFlags(checkExportableFlags(ctx, "export_cflags", f.Properties.Export_cflags, false)...)
+	f.reexportFlags(checkExportableFlags(ctx, "export_defines", f.Properties.Export_defines, true)...)
+	f.reexportFlags(checkExportableFlags(ctx, "public_config_flags", f.Properties.Public_config_flags, true)...)
+	if macro := String(f.Properties.Export_consumer_api_level_macro); macro != "" {
+		if charsNotForMacro.MatchString(macro) {
+			ctx.PropertyErrorf("export_consumer_api_level_macro", "%q is not a valid macro name", macro)
+		} else {
+			f.consumerApiLevelMacros = append(f.consumerApiLevelMacros, macro)
+		}
+	}
+}
+
+// checkExportableFlags returns the flags that can be exported, and reports an error on property
+// for each of the others. If definesOnly is set only -D and -U flags can be exported.
+func checkExportableFlags(ctx ModuleContext, property string, flags []string, definesOnly bool) []string {
+	var ret []string
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, "-I") || strings.HasPrefix(flag, "-isystem") {
+			ctx.PropertyErrorf(property, "%q: use export_include_dirs or "+
+				"export_system_include_dirs to export include directories", flag)
+		} else if definesOnly && !strings.HasPrefix(flag, "-D") && !strings.HasPrefix(flag, "-U") {
+			ctx.PropertyErrorf(property, "%q: only -D and -U flags can be exported", flag)
+		} else {
+			ret = append(ret, flag)
+		}
+	}
+	return ret
 }
 
 // exportIncludesAsSystem registers the include directories and system include directories to be
@@ -361,6 +837,10 @@ func (f *flagExporter) setProvider(ctx android.ModuleContext) {
 		// For exported generated headers, such as exported aidl headers, proto headers, or
 		// sysprop headers.
 		GeneratedHeaders: f.headers,
+		// Comes from Export_include_dirs_deprecated property, also part of IncludeDirs.
+		DeprecatedIncludeDirs: f.deprecatedDirs,
+		// Comes from Export_consumer_api_level_macro property, not re-exported.
+		ConsumerApiLevelMacros: f.consumerApiLevelMacros,
 	})
 }
 
@@ -374,6 +854,8 @@ type libraryDecorator struct {
 
 	// For reusing static library objects for shared library
 	reuseObjects Objects
+	// Hash of the flags reuseObjects were compiled with, only set if checkReusedObjects is true.
+	reuseObjectsFlagsHash string
 
 	// table-of-contents file to optimize out relinking when possible
 	tocFile android.OptionalPath
@@ -401,6 +883,10 @@ type libraryDecorator struct {
 	// Source Abi Diff
 	sAbiDiff android.Paths
 
+	// Location of the JSON summary of the linked ABI dump, if header_abi_checker.emit_json_summary
+	// is set
+	sAbiJsonSummary android.OptionalPath
+
 	// Location of the static library in the sysroot. Empty if the library is
 	// not included in the NDK.
 	ndkSysrootPath android.Path
@@ -415,6 +901,55 @@ type libraryDecorator struct {
 
 	versionScriptPath android.OptionalPath
 
+	// Location of the compile_commands.json fragment, if generate_compile_commands is set
+	compileCommandsFile android.OptionalPath
+	// The compile commands written to compileCommandsFile by the compile_commands_fragments
+	// singleton, which resolves the ninja variables they reference
+	compileCommands []compileCommand
+
+	// Location of the zip of the exported headers, if generate_headers_zip is set
+	headersZip android.OptionalPath
+
+	// Location of the pkg-config file of a host library, if pkg_config.name is set
+	pkgConfigFile android.OptionalPath
+
+	// Timestamps of the checks of the exported headers, from c_api_headers and
+	// check_exported_header_closure
+	exportedHeaderChecks android.Paths
+
+	// Locations of the preprocessed outputs of preprocess_srcs
+	preprocessedFiles android.Paths
+
+	// Locations of the assembly listings of asm_listing_srcs
+	asmListingFiles android.Paths
+
+	// Location of the llvm-bolt instrumented copy of the shared library, if bolt_instrument is set
+	boltInstrumentedOutputFile android.OptionalPath
+
+	// Location of the contents of the build metadata section, if build_metadata_section is set
+	buildMetadataFile android.OptionalPath
+
+	// Location of the DWARF package file of the shared library, if generate_dwp is set
+	dwpFile android.OptionalPath
+
+	// Location of the relocation statistics of the shared library, if emit_reloc_stats is set
+	relocStatsFile android.OptionalPath
+
+	// Location of the list of the symbols imported by the shared library, if emit_imports is set
+	importsFile android.OptionalPath
+
+	// Locations of the compressed copies of the shared library, from generate_compressed_copies
+	compressedFiles android.Paths
+
+	// Location of the checksum of the installed shared library, if generate_checksum is set
+	checksumFile android.OptionalPath
+
+	// Location of the list of the symbols exported by the shared library, if a check needs it
+	exportedSymbolListFile android.OptionalPath
+
+	// Location of the symbol file combined from stubs.symbol_files, if set
+	combinedSymbolFile android.OptionalPath
+
 	postInstallCmds []string
 
 	// If useCoreVariant is true, the vendor variant of a VNDK library is
@@ -591,18 +1126,19 @@ func (library *libraryDecorator) linkerFlags(ctx ModuleContext, flags Flags) Fla
 
 	if library.shared() {
 		libName := library.getLibName(ctx)
+		installName := library.darwinInstallName(ctx, libName+flags.Toolchain.ShlibSuffix())
 		var f []string
 		if ctx.toolchain().Bionic() {
-			f = append(f,
-				"-nostdlib",
-				"-Wl,--gc-sections",
-			)
+			if !Bool(library.Properties.No_nostdlib) {
+				f = append(f, "-nostdlib")
+			}
+			f = append(f, "-Wl,--gc-sections")
 		}
 
 		if ctx.Darwin() {
 			f = append(f,
 				"-dynamiclib",
-				"-install_name @rpath/"+libName+flags.Toolchain.ShlibSuffix(),
+				"-install_name "+installName,
 			)
 			if ctx.Arch().ArchType == android.X86 {
 				f = append(f,
@@ -612,16 +1148,159 @@ func (library *libraryDecorator) linkerFlags(ctx ModuleContext, flags Flags) Fla
 		} else {
 			f = append(f, "-shared")
 			if !ctx.Windows() {
-				f = append(f, "-Wl,-soname,"+libName+flags.Toolchain.ShlibSuffix())
+				f = append(f, "-Wl,-soname,"+library.soname(ctx, libName+flags.Toolchain.ShlibSuffix()))
 			}
 		}
 
 		flags.Global.LdFlags = append(flags.Global.LdFlags, f...)
+
+		if library.boltInstrumentEnabled(ctx) {
+			flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,--emit-relocs")
+		}
+
+		if library.preserveCoverageUnderLto(ctx) {
+			// The profile sections are only referenced through __start_ and __stop_ symbols.
+			flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,-z,nostart-stop-gc")
+		}
+
+		if Bool(library.Properties.Allow_multiple_definition) {
+			if ctx.Darwin() {
+				ctx.PropertyErrorf("allow_multiple_definition", "is not supported for Darwin")
+			} else {
+				flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,--allow-multiple-definition")
+			}
+		}
 	}
 
 	return flags
 }
 
+// wholeStaticSharedDepsWarning returns the timestamp of a warning listing the shared libraries
+// that the whole static libraries of this shared library depend on, if
+// warn_on_whole_static_shared_deps is set and there are any, or nil otherwise.
+func (library *libraryDecorator) wholeStaticSharedDepsWarning(ctx ModuleContext) android.Path {
+	if !Bool(library.Properties.Warn_on_whole_static_shared_deps) || library.buildStubs() {
+		return nil
+	}
+	// Shared libraries this library depends on itself, e.g. the system shared libraries, are
+	// expected.
+	direct := make(map[string]bool)
+	ctx.VisitDirectDeps(func(dep android.Module) {
+		if tag, ok := ctx.OtherModuleDependencyTag(dep).(libraryDependencyTag); ok && tag.shared() {
+			direct[ctx.OtherModuleName(dep)] = true
+		}
+	})
+	var uses []string
+	ctx.WalkDeps(func(child, parent android.Module) bool {
+		tag, ok := ctx.OtherModuleDependencyTag(child).(libraryDependencyTag)
+		if !ok {
+			return false
+		}
+		if parent != ctx.Module() && tag.shared() {
+			if direct[ctx.OtherModuleName(child)] {
+				return false
+			}
+			uses = append(uses, fmt.Sprintf("%s (via %s)",
+				ctx.OtherModuleName(child), ctx.OtherModuleName(parent)))
+			return false
+		}
+		return tag.static() && tag.wholeStatic
+	})
+	if len(uses) == 0 {
+		return nil
+	}
+	return buildWarning(ctx, "whole_static_shared_deps", "whole_static_libs depend on shared "+
+		"libraries, which become dependencies of this library: "+
+		strings.Join(android.FirstUniqueStrings(uses), "; "))
+}
+
+// preserveCoverageUnderLto returns true if the coverage instrumentation of this shared library
+// needs to be preserved through LTO.
+func (library *libraryDecorator) preserveCoverageUnderLto(ctx ModuleContext) bool {
+	if !Bool(library.Properties.Preserve_coverage_under_lto) || !library.shared() || library.buildStubs() {
+		return false
+	}
+	m := ctx.Module().(*Module)
+	return m.lto != nil && m.lto.Properties.LtoEnabled &&
+		m.coverage != nil && m.coverage.Properties.CoverageEnabled
+}
+
+// boltInstrumentEnabled returns true if an llvm-bolt instrumented copy of this shared library
+// should be built.
+func (library *libraryDecorator) boltInstrumentEnabled(ctx ModuleContext) bool {
+	if !Bool(library.Properties.Bolt_instrument) || !library.shared() || library.buildStubs() {
+		return false
+	}
+	if ctx.Darwin() || ctx.Windows() {
+		return false
+	}
+	arch := ctx.Arch().ArchType
+	return arch == android.Arm64 || arch == android.X86_64
+}
+
+// srcsSubset returns the paths of subset, the value of property, which must all be listed in
+// srcs.
+func (library *libraryDecorator) srcsSubset(ctx ModuleContext, property string, subset []string) android.Paths {
+	// The srcs of a shared library that reuses the objects of its static variant have been
+	// moved to OriginalSrcs.
+	srcs := library.baseCompiler.Properties.Srcs
+	if library.baseCompiler.Properties.OriginalSrcs != nil {
+		srcs = library.baseCompiler.Properties.OriginalSrcs
+	}
+	allSrcs := android.PathsForModuleSrc(ctx, srcs)
+	allSrcs = append(allSrcs, android.PathsForModuleSrc(ctx, library.StaticProperties.Static.Srcs)...)
+	allSrcs = append(allSrcs, android.PathsForModuleSrc(ctx, library.SharedProperties.Shared.Srcs)...)
+
+	subsetSrcs := android.PathsForModuleSrc(ctx, subset)
+	for _, src := range subsetSrcs {
+		if !android.InList(src.String(), allSrcs.Strings()) {
+			ctx.PropertyErrorf(property, "%q is not listed in srcs", src.Rel())
+		}
+	}
+	return subsetSrcs
+}
+
+// soname returns the DT_SONAME of the shared library, which is fileName unless overridden by the
+// soname property.
+func (library *libraryDecorator) soname(ctx ModuleContext, fileName string) string {
+	soname := String(library.Properties.Soname)
+	if soname == "" {
+		return fileName
+	}
+	if strings.Contains(soname, "/") {
+		ctx.PropertyErrorf("soname", "%q must not contain a path separator", soname)
+		return fileName
+	}
+	if suffix := ctx.toolchain().ShlibSuffix(); !strings.HasSuffix(soname, suffix) {
+		soname += suffix
+	}
+	return soname
+}
+
+// charsNotForInstallName matches the characters that aren't allowed in darwin_install_name, as it
+// is passed to the linker through the ninja and shell command line unescaped.
+var charsNotForInstallName = regexp.MustCompile("[^a-zA-Z0-9_@./+-]")
+
+// darwinInstallName returns the install name of the shared library on Darwin, which is
+// @rpath/fileName unless overridden by the darwin_install_name property.
+func (library *libraryDecorator) darwinInstallName(ctx ModuleContext, fileName string) string {
+	installName := String(library.Properties.Darwin_install_name)
+	if installName == "" {
+		return "@rpath/" + fileName
+	}
+	if c := charsNotForInstallName.FindString(installName); c != "" {
+		ctx.PropertyErrorf("darwin_install_name", "%q must not contain %q", installName, c)
+		return "@rpath/" + fileName
+	}
+	if !strings.HasPrefix(installName, "/") &&
+		!android.HasAnyPrefix(installName, []string{"@rpath/", "@loader_path/", "@executable_path/"}) {
+		ctx.PropertyErrorf("darwin_install_name",
+			"%q must be an absolute path or start with @rpath/, @loader_path/ or @executable_path/", installName)
+		return "@rpath/" + fileName
+	}
+	return installName
+}
+
 // compilerFlags takes a Flags and augments it to contain compile flags from global values,
 // per-target values, module type values, per-module Blueprints properties, extra flags from
 // `flags`, and generated sources from `deps`.
@@ -643,6 +1322,16 @@ func (library *libraryDecorator) compilerFlags(ctx ModuleContext, flags Flags, d
 		flags.Local.YasmFlags = append(flags.Local.YasmFlags, f)
 	}
 
+	// The errors for invalid flags are reported when they are exported.
+	flags.Local.CFlags = append(flags.Local.CFlags, library.flagExporter.Properties.Public_config_flags...)
+
+	// The tidy flags are only added after the compiler flags, so this replaces the default
+	// header filter, and a header filter in tidy_flags still takes precedence.
+	if filter := library.Properties.Tidy_header_filter; filter != nil {
+		flags.TidyFlags = append(flags.TidyFlags,
+			checkNinjaAndShellEscapeList(ctx, "tidy_header_filter", []string{"-header-filter=" + *filter})...)
+	}
+
 	flags = library.baseCompiler.compilerFlags(ctx, flags, deps)
 	if ctx.IsLlndk() {
 		// LLNDK libraries ignore most of the properties on the cc_library and use the
@@ -671,9 +1360,54 @@ func (library *libraryDecorator) compilerFlags(ctx ModuleContext, flags Flags, d
 
 		flags = addStubLibraryCompilerFlags(flags)
 	}
+	if Bool(library.Properties.Split_sections) && ctx.toolchain().Bionic() {
+		// Shared libraries are linked with --gc-sections on Bionic, as are the binaries and
+		// shared libraries static libraries end up in.
+		for _, section := range []string{"function", "data"} {
+			enable := "-f" + section + "-sections"
+			disable := "-fno-" + section + "-sections"
+			if lastIndexOfFlag(enable, flags.Local.CFlags) <= lastIndexOfFlag(disable, flags.Local.CFlags) {
+				flags.Local.CFlags = append(flags.Local.CFlags, enable)
+			}
+		}
+	}
 	return flags
 }
 
+// coverageObjects returns objs without the coverage files of the sources listed in
+// coverage_exclude_srcs.
+func (library *libraryDecorator) coverageObjects(ctx ModuleContext, objs Objects) Objects {
+	if len(library.Properties.Coverage_exclude_srcs) == 0 || len(objs.coverageFiles) == 0 {
+		return objs
+	}
+	excluded := make(map[string]bool)
+	for _, src := range android.PathsForModuleSrc(ctx, library.Properties.Coverage_exclude_srcs) {
+		// The sources in static.srcs and shared.srcs are compiled into a subdirectory.
+		for _, subdir := range []string{"", android.DeviceStaticLibrary, android.DeviceSharedLibrary} {
+			excluded[android.ObjPathWithExt(ctx, subdir, src, "gcno").String()] = true
+		}
+	}
+	objs = objs.Copy()
+	objs.coverageFiles, _ = android.FilterPathListPredicate(objs.coverageFiles, func(p android.Path) bool {
+		return excluded[p.String()]
+	})
+	return objs
+}
+
+// lastIndexOfFlag returns the index of the last occurrence of flag in flags, or -1 if it is absent.
+func lastIndexOfFlag(flag string, flags []string) int {
+	for i := len(flags) - 1; i >= 0; i-- {
+		if flags[i] == flag {
+			return i
+		}
+	}
+	return -1
+}
+
+// getHeaderAbiCheckerProperties returns the header_abi_checker properties of this library merged
+// with those of the vendor, product or platform target stanza. Arch-specific values (e.g.
+// arch.arm.header_abi_checker.diff_flags) have already been squashed into the base properties by
+// the arch mutator, so the result is specific to the current variant.
 func (library *libraryDecorator) getHeaderAbiCheckerProperties(ctx android.BaseModuleContext) headerAbiCheckerProperties {
 	m := ctx.Module().(*Module)
 	variantProps := &library.Properties.Target.Platform.Header_abi_checker
@@ -687,6 +1421,9 @@ func (library *libraryDecorator) getHeaderAbiCheckerProperties(ctx android.BaseM
 	if err != nil {
 		ctx.ModuleErrorf("Cannot merge headerAbiCheckerProperties: %s", err.Error())
 	}
+	if props.Enabled == nil && ctx.Config().HeaderAbiCheckerEnabledForPath(ctx.ModuleDir()) {
+		props.Enabled = proptools.BoolPtr(true)
+	}
 	return props
 }
 
@@ -701,9 +1438,16 @@ func (library *libraryDecorator) compile(ctx ModuleContext, flags Flags, deps Pa
 		if library.stubsVersion() != "" {
 			vndkVer = library.stubsVersion()
 		}
+		if stubVersion := String(library.Properties.Llndk.Stub_version); stubVersion != "" {
+			if _, err := android.ApiLevelFromUser(ctx, stubVersion); err != nil {
+				ctx.PropertyErrorf("llndk.stub_version", "%s", err.Error())
+				return Objects{}
+			}
+			vndkVer = stubVersion
+		}
 		nativeAbiResult := parseNativeAbiDefinition(ctx,
-			String(library.Properties.Llndk.Symbol_file),
-			android.ApiLevelOrPanic(ctx, vndkVer), "--llndk")
+			android.PathForModuleSrc(ctx, String(library.Properties.Llndk.Symbol_file)),
+			android.ApiLevelOrPanic(ctx, vndkVer), "--llndk", nil)
 		objs := compileStubLibrary(ctx, flags, nativeAbiResult.stubSrc)
 		if !Bool(library.Properties.Llndk.Unversioned) {
 			library.versionScriptPath = android.OptionalPathForPath(
@@ -713,8 +1457,8 @@ func (library *libraryDecorator) compile(ctx ModuleContext, flags Flags, deps Pa
 	}
 	if ctx.IsVendorPublicLibrary() {
 		nativeAbiResult := parseNativeAbiDefinition(ctx,
-			String(library.Properties.Vendor_public_library.Symbol_file),
-			android.FutureApiLevel, "")
+			android.PathForModuleSrc(ctx, String(library.Properties.Vendor_public_library.Symbol_file)),
+			android.FutureApiLevel, "", nil)
 		objs := compileStubLibrary(ctx, flags, nativeAbiResult.stubSrc)
 		if !Bool(library.Properties.Vendor_public_library.Unversioned) {
 			library.versionScriptPath = android.OptionalPathForPath(nativeAbiResult.versionScript)
@@ -722,11 +1466,10 @@ func (library *libraryDecorator) compile(ctx ModuleContext, flags Flags, deps Pa
 		return objs
 	}
 	if library.buildStubs() {
-		symbolFile := String(library.Properties.Stubs.Symbol_file)
-		if symbolFile != "" && !strings.HasSuffix(symbolFile, ".map.txt") {
-			ctx.PropertyErrorf("symbol_file", "%q doesn't have .map.txt suffix", symbolFile)
+		if !library.checkStubsSymbolFiles(ctx) {
 			return Objects{}
 		}
+		symbolFile := library.stubsSymbolFile(ctx)
 		// b/239274367 --apex and --systemapi filters symbols tagged with # apex and #
 		// systemapi, respectively. The former is for symbols defined in platform libraries
 		// and the latter is for symbols defined in APEXes.
@@ -743,11 +1486,15 @@ func (library *libraryDecorator) compile(ctx ModuleContext, flags Flags, deps Pa
 		// b/184712170, unless the lib is an NDK library, exclude all public symbols from
 		// the stub so that it is mandated that all symbols are explicitly marked with
 		// either apex or systemapi.
+		var validations android.Paths
 		if !ctx.Module().(*Module).IsNdk(ctx.Config()) {
 			flag = flag + " --no-ndk"
+			// Untagged symbols would be silently dropped from the stub, report them
+			// by name and line before the stub generator runs.
+			validations = append(validations, checkSymbolFileModeTags(ctx, symbolFile))
 		}
 		nativeAbiResult := parseNativeAbiDefinition(ctx, symbolFile,
-			android.ApiLevelOrPanic(ctx, library.MutatedProperties.StubsVersion), flag)
+			android.ApiLevelOrPanic(ctx, library.MutatedProperties.StubsVersion), flag, validations)
 		objs := compileStubLibrary(ctx, flags, nativeAbiResult.stubSrc)
 		library.versionScriptPath = android.OptionalPathForPath(
 			nativeAbiResult.versionScript)
@@ -788,10 +1535,57 @@ func (library *libraryDecorator) compile(ctx ModuleContext, flags Flags, deps Pa
 			flags.SAbiDump = true
 		}
 	}
+	// Drop the common srcs that static.exclude_srcs or shared.exclude_srcs exclude from this
+	// variant.
+	var excludeSrcs []string
+	if library.static() {
+		excludeSrcs = library.StaticProperties.Static.Exclude_srcs
+	} else if library.shared() {
+		excludeSrcs = library.SharedProperties.Shared.Exclude_srcs
+	}
+	if len(excludeSrcs) > 0 {
+		library.baseCompiler.srcsBeforeGen, _ = android.FilterPathList(library.baseCompiler.srcsBeforeGen,
+			android.PathsForModuleSrc(ctx, excludeSrcs))
+	}
+
+	flags.CompileCommands = Bool(library.Properties.Generate_compile_commands)
+	if len(library.Properties.Preprocess_srcs) > 0 {
+		flags.PreprocessSrcs = library.srcsSubset(ctx, "preprocess_srcs", library.Properties.Preprocess_srcs)
+	}
+	if len(library.Properties.Asm_listing_srcs) > 0 {
+		flags.AsmListingSrcs = library.srcsSubset(ctx, "asm_listing_srcs", library.Properties.Asm_listing_srcs)
+	}
+	for _, entry := range library.Properties.Per_file_cflags {
+		CheckBadCompilerFlags(ctx, "per_file_cflags.cflags", entry.Cflags)
+		for _, src := range library.srcsSubset(ctx, "per_file_cflags.srcs", entry.Srcs) {
+			if flags.PerFileCflags == nil {
+				flags.PerFileCflags = make(map[string][]string)
+			}
+			flags.PerFileCflags[src.String()] = append(flags.PerFileCflags[src.String()], entry.Cflags...)
+		}
+	}
+	if limit := library.Properties.Max_concurrent_compiles; limit != nil {
+		if *limit < 1 || *limit > maxConcurrentCompilesLimit {
+			ctx.PropertyErrorf("max_concurrent_compiles", "must be between 1 and %d, got %d",
+				maxConcurrentCompilesLimit, *limit)
+		} else {
+			flags.MaxConcurrentCompiles = int(*limit)
+		}
+	}
 	objs := library.baseCompiler.compile(ctx, flags, deps)
-	library.reuseObjects = objs
+	// The gcno files are excluded here rather than only at link time, as a shared variant that
+	// reuses these objects can't match coverage_exclude_srcs against the paths of this variant.
+	library.reuseObjects = library.coverageObjects(ctx, objs)
 	buildFlags := flagsToBuilderFlags(flags)
 
+	if checkReusedObjects(ctx) {
+		library.reuseObjectsFlagsHash = compileFlagsHash(buildFlags)
+		if deps.ReuseObjectsFlagsHash != "" && deps.ReuseObjectsFlagsHash != library.reuseObjectsFlagsHash {
+			ctx.ModuleErrorf("reuses the objects of the static variant, but is compiled with different " +
+				"flags; set the differing flags in both the static and shared variants")
+		}
+	}
+
 	if library.static() {
 		srcs := android.PathsForModuleSrc(ctx, library.StaticProperties.Static.Srcs)
 		objs = objs.Append(compileObjs(ctx, buildFlags, android.DeviceStaticLibrary, srcs,
@@ -806,6 +1600,11 @@ func (library *libraryDecorator) compile(ctx ModuleContext, flags Flags, deps Pa
 			library.baseCompiler.pathDeps, library.baseCompiler.cFlagsDeps))
 	}
 
+	if flags.CompileCommands {
+		library.compileCommandsFile = android.OptionalPathForPath(android.PathForModuleOut(ctx, "compile_commands.json"))
+		library.compileCommands = objs.compileCommands
+	}
+
 	return objs
 }
 
@@ -904,8 +1703,6 @@ func (library *libraryDecorator) getLibName(ctx BaseModuleContext) string {
 	return name
 }
 
-var versioningMacroNamesListMutex sync.Mutex
-
 func (library *libraryDecorator) linkerInit(ctx BaseModuleContext) {
 	location := InstallInSystem
 	if library.baseLinker.sanitize.inSanitizerDir() {
@@ -918,14 +1715,9 @@ func (library *libraryDecorator) linkerInit(ctx BaseModuleContext) {
 	library.baseLinker.dynamicProperties.BuildStubs = library.buildStubs()
 
 	if library.buildStubs() {
-		macroNames := versioningMacroNamesList(ctx.Config())
 		myName := versioningMacroName(ctx.ModuleName())
-		versioningMacroNamesListMutex.Lock()
-		defer versioningMacroNamesListMutex.Unlock()
-		if (*macroNames)[myName] == "" {
-			(*macroNames)[myName] = ctx.ModuleName()
-		} else if (*macroNames)[myName] != ctx.ModuleName() {
-			ctx.ModuleErrorf("Macro name %q for versioning conflicts with macro name from module %q ", myName, (*macroNames)[myName])
+		if owner := registerVersioningMacroName(ctx.Config(), myName, ctx.ModuleName()); owner != ctx.ModuleName() {
+			ctx.ModuleErrorf("Macro name %q for versioning conflicts with macro name from module %q ", myName, owner)
 		}
 	}
 }
@@ -971,20 +1763,40 @@ func (library *libraryDecorator) linkerDeps(ctx DepsContext, deps Deps) Deps {
 
 	deps = library.baseLinker.linkerDeps(ctx, deps)
 
+	// The generated header directories listed in export_include_dirs are exported through a
+	// generated_headers dependency.
+	_, genrules := splitGeneratedIncludeDirs(library.flagExporter.exportIncludeDirsForVariant(ctx))
+	deps.GeneratedHeaders = append(deps.GeneratedHeaders, genrules...)
+	deps.ReexportGeneratedHeaders = append(deps.ReexportGeneratedHeaders, genrules...)
+
 	if library.static() {
 		deps.WholeStaticLibs = append(deps.WholeStaticLibs,
 			library.StaticProperties.Static.Whole_static_libs...)
+		deps.WholeStaticLibs = append(deps.WholeStaticLibs,
+			library.StaticProperties.Static.Implementation_whole_static_libs...)
+		deps.ImplementationWholeStaticLibs = append(deps.ImplementationWholeStaticLibs,
+			library.StaticProperties.Static.Implementation_whole_static_libs...)
 		deps.StaticLibs = append(deps.StaticLibs, library.StaticProperties.Static.Static_libs...)
 		deps.SharedLibs = append(deps.SharedLibs, library.StaticProperties.Static.Shared_libs...)
 
 		deps.ReexportSharedLibHeaders = append(deps.ReexportSharedLibHeaders, library.StaticProperties.Static.Export_shared_lib_headers...)
 		deps.ReexportStaticLibHeaders = append(deps.ReexportStaticLibHeaders, library.StaticProperties.Static.Export_static_lib_headers...)
 	} else if library.shared() {
-		if library.baseLinker.Properties.crt() {
+		if library.Properties.Crt_begin != nil {
+			deps.CrtBegin = append(deps.CrtBegin, library.Properties.Crt_begin...)
+		} else if library.baseLinker.Properties.crt() {
 			deps.CrtBegin = append(deps.CrtBegin, ctx.toolchain().CrtBeginSharedLibrary()...)
+		}
+		if library.Properties.Crt_end != nil {
+			deps.CrtEnd = append(deps.CrtEnd, library.Properties.Crt_end...)
+		} else if library.baseLinker.Properties.crt() {
 			deps.CrtEnd = append(deps.CrtEnd, ctx.toolchain().CrtEndSharedLibrary()...)
 		}
 		deps.WholeStaticLibs = append(deps.WholeStaticLibs, library.SharedProperties.Shared.Whole_static_libs...)
+		deps.WholeStaticLibs = append(deps.WholeStaticLibs,
+			library.SharedProperties.Shared.Implementation_whole_static_libs...)
+		deps.ImplementationWholeStaticLibs = append(deps.ImplementationWholeStaticLibs,
+			library.SharedProperties.Shared.Implementation_whole_static_libs...)
 		deps.StaticLibs = append(deps.StaticLibs, library.SharedProperties.Shared.Static_libs...)
 		deps.SharedLibs = append(deps.SharedLibs, library.SharedProperties.Shared.Shared_libs...)
 
@@ -1058,12 +1870,77 @@ func (library *libraryDecorator) linkerSpecifiedDeps(specifiedDeps specifiedDeps
 	return specifiedDeps
 }
 
+// uniquePathsAndDuplicates returns the paths with duplicates removed, keeping the first
+// occurrence of each, along with the paths that were removed.
+func uniquePathsAndDuplicates(paths android.Paths) (android.Paths, android.Paths) {
+	seen := make(map[string]bool, len(paths))
+	var unique, duplicates android.Paths
+	for _, path := range paths {
+		if seen[path.String()] {
+			duplicates = append(duplicates, path)
+			continue
+		}
+		seen[path.String()] = true
+		unique = append(unique, path)
+	}
+	return unique, duplicates
+}
+
+// checkStaticLibraryObjectLimit reports static libraries that archive more objects than the limit
+// set by the product, which is usually the result of an accidentally large whole_static_libs graph.
+// Unless the product makes it an error, it returns the timestamp file of the warning, or nil if the
+// library is within the limit.
+func (library *libraryDecorator) checkStaticLibraryObjectLimit(ctx ModuleContext) android.Path {
+	limit := ctx.Config().StaticLibraryObjectLimit()
+	count := len(library.objects.objFiles)
+	if limit <= 0 || count <= limit {
+		return nil
+	}
+	const format = "archives %d objects, more than the limit of %d; consider depending on some of " +
+		"its whole_static_libs through static_libs instead"
+	if ctx.Config().StaticLibraryObjectLimitIsError() {
+		ctx.ModuleErrorf(format, count, limit)
+		return nil
+	}
+	return buildWarning(ctx, "static_library_object_limit", fmt.Sprintf(format, count, limit))
+}
+
+// dedupWholeStaticLibObjects removes the objects and prebuilt archives that were included more
+// than once, e.g. when the same library is reached through several whole_static_libs paths.
+// Objects are compared by path, so distinct objects with the same base name are all kept. It
+// returns the timestamp file of the warning listing the duplicates, or nil if there are none.
+func (library *libraryDecorator) dedupWholeStaticLibObjects(ctx ModuleContext) android.Path {
+	var duplicateObjs, duplicateArchives android.Paths
+	library.objects.objFiles, duplicateObjs = uniquePathsAndDuplicates(library.objects.objFiles)
+	library.wholeStaticLibsFromPrebuilts, duplicateArchives =
+		uniquePathsAndDuplicates(library.wholeStaticLibsFromPrebuilts)
+	if len(duplicateObjs) == 0 && len(duplicateArchives) == 0 {
+		return nil
+	}
+
+	library.objects.tidyFiles = android.FirstUniquePaths(library.objects.tidyFiles)
+	library.objects.tidyDepFiles = android.FirstUniquePaths(library.objects.tidyDepFiles)
+	library.objects.coverageFiles = android.FirstUniquePaths(library.objects.coverageFiles)
+	library.objects.sAbiDumpFiles = android.FirstUniquePaths(library.objects.sAbiDumpFiles)
+	library.objects.kytheFiles = android.FirstUniquePaths(library.objects.kytheFiles)
+
+	return buildWarning(ctx, "duplicate_whole_static_lib_objects", "whole_static_libs includes "+
+		"the same objects more than once, duplicates were dropped: "+
+		strings.Join(append(duplicateArchives, duplicateObjs...).Strings(), " "))
+}
+
 func (library *libraryDecorator) linkStatic(ctx ModuleContext,
 	flags Flags, deps PathDeps, objs Objects) android.Path {
 
+	if library.Properties.Symbol_ordering_file != nil && !library.MutatedProperties.BuildShared {
+		ctx.PropertyErrorf("symbol_ordering_file", "only applies to shared libraries")
+	}
+
 	library.objects = deps.WholeStaticLibObjs.Copy()
 	library.objects = library.objects.Append(objs)
 	library.wholeStaticLibsFromPrebuilts = android.CopyOfPaths(deps.WholeStaticLibsFromPrebuilts)
+	duplicatesWarning := library.dedupWholeStaticLibObjects(ctx)
+	objectLimitWarning := library.checkStaticLibraryObjectLimit(ctx)
 
 	fileName := ctx.ModuleName() + staticLibraryExtension
 	outputFile := android.PathForModuleOut(ctx, fileName)
@@ -1081,16 +1958,60 @@ func (library *libraryDecorator) linkStatic(ctx ModuleContext,
 		}
 	}
 
-	transformObjToStaticLib(ctx, library.objects.objFiles, deps.WholeStaticLibsFromPrebuilts, builderFlags, outputFile, nil, objs.tidyDepFiles)
+	// The archive of all the objects, which is trimmed into outputFile with gc_unreferenced_objects.
+	archiveFile := outputFile
+	if rootsFile := library.gcRootsSymbolFile(ctx); rootsFile.Valid() {
+		archiveFile = android.PathForModuleOut(ctx, "untrimmed", fileName)
+		transformStaticLibToTrimmed(ctx, archiveFile, rootsFile.Path(), outputFile)
+	}
+
+	validations := android.CopyOfPaths(objs.tidyDepFiles)
+	validations = append(validations, library.exportedHeaderChecks...)
+	if Bool(library.Properties.Verify_deterministic) {
+		validations = append(validations, transformStaticLibToDeterminismCheck(ctx, archiveFile))
+	}
+	if duplicatesWarning != nil {
+		validations = append(validations, duplicatesWarning)
+	}
+	if objectLimitWarning != nil {
+		validations = append(validations, objectLimitWarning)
+	}
+
+	transformObjToStaticLib(ctx, library.objects.objFiles, library.wholeStaticLibsFromPrebuilts, builderFlags, archiveFile, nil, validations)
 
-	library.coverageOutputFile = transformCoverageFilesToZip(ctx, library.objects, ctx.ModuleName())
+	library.coverageOutputFile = transformCoverageFilesToZip(ctx, library.coverageObjects(ctx, library.objects),
+		ctx.ModuleName())
 
 	ctx.CheckbuildFile(outputFile)
 
+	var bitcodeArchive android.OptionalPath
+	if Bool(library.Properties.Emit_bitcode) && library.static() {
+		if !ctx.Module().(*Module).lto.ThinLTO() {
+			ctx.PropertyErrorf("emit_bitcode", "requires `lto: { thin: true }`, otherwise the objects are not bitcode")
+		} else {
+			bitcodeArchiveFile := android.PathForModuleOut(ctx, ctx.ModuleName()+".bc"+staticLibraryExtension)
+			transformObjToStaticLib(ctx, library.objects.objFiles, nil, builderFlags, bitcodeArchiveFile, nil, nil)
+			ctx.CheckbuildFile(bitcodeArchiveFile)
+			bitcodeArchive = android.OptionalPathForPath(bitcodeArchiveFile)
+		}
+	}
+
+	var symbolIndex android.OptionalPath
+	if Bool(library.Properties.Emit_symbol_index) && library.static() {
+		symbolIndexFile := android.PathForModuleOut(ctx, fileName+".sym")
+		transformStaticLibToSymbolIndex(ctx, outputFile, symbolIndexFile)
+		ctx.CheckbuildFile(symbolIndexFile)
+		symbolIndex = android.OptionalPathForPath(symbolIndexFile)
+	}
+
 	if library.static() {
 		ctx.SetProvider(StaticLibraryInfoProvider, StaticLibraryInfo{
 			StaticLibrary:                outputFile,
+			SymbolIndex:                  symbolIndex,
+			BitcodeArchive:               bitcodeArchive,
+			AlwayslinkInApex:             Bool(library.Properties.Alwayslink_in_apex),
 			ReuseObjects:                 library.reuseObjects,
+			ReuseObjectsFlagsHash:        library.reuseObjectsFlagsHash,
 			Objects:                      library.objects,
 			WholeStaticLibsFromPrebuilts: library.wholeStaticLibsFromPrebuilts,
 
@@ -1108,6 +2029,19 @@ func (library *libraryDecorator) linkStatic(ctx ModuleContext,
 	return outputFile
 }
 
+// gcRootsSymbolFile returns the roots_symbol_file to trim the static library with if
+// gc_unreferenced_objects is set, after checking that it is set too.
+func (library *libraryDecorator) gcRootsSymbolFile(ctx ModuleContext) android.OptionalPath {
+	if !Bool(library.Properties.Gc_unreferenced_objects) || !library.static() {
+		return android.OptionalPath{}
+	}
+	if library.Properties.Roots_symbol_file == nil {
+		ctx.PropertyErrorf("gc_unreferenced_objects", "requires roots_symbol_file")
+		return android.OptionalPath{}
+	}
+	return android.OptionalPathForPath(android.PathForModuleSrc(ctx, *library.Properties.Roots_symbol_file))
+}
+
 func ndkSharedLibDeps(ctx ModuleContext) android.Paths {
 	if ctx.Module().(*Module).IsSdkVariant() {
 		// The NDK sysroot timestamp file depends on all the NDK
@@ -1117,6 +2051,118 @@ func ndkSharedLibDeps(ctx ModuleContext) android.Paths {
 	return nil
 }
 
+// hostSanitized returns true if the module is built with a sanitizer whose runtime is only linked
+// into executables, leaving undefined symbols in shared libraries.
+func hostSanitized(ctx ModuleContext) bool {
+	s := ctx.Module().(*Module).sanitize
+	return s != nil && len(s.Properties.Sanitizers) > 0 &&
+		!ctx.toolchain().Bionic() && !ctx.toolchain().Musl()
+}
+
+// postLinkValidators builds a rule for each of the post_link_validators run over the linked
+// shared library and returns their timestamp files.
+func (library *libraryDecorator) postLinkValidators(ctx ModuleContext, sharedLib android.Path) android.Paths {
+	if library.buildStubs() {
+		return nil
+	}
+	var ret android.Paths
+	for i, validator := range library.Properties.Post_link_validators {
+		if validator.Tool == nil {
+			ctx.PropertyErrorf("post_link_validators", "tool must be set")
+			continue
+		}
+		ret = append(ret, transformSharedObjectToPostLinkValidation(ctx, i,
+			android.PathForModuleSrc(ctx, *validator.Tool), validator.Args, sharedLib))
+	}
+	return ret
+}
+
+// symbolVisibilityAudit builds the rule running the symbol_visibility_audit tool over the linked
+// shared library and returns its timestamp file, or nil if no audit is configured.
+func (library *libraryDecorator) symbolVisibilityAudit(ctx ModuleContext, sharedLib android.Path) android.Path {
+	audit := library.Properties.Symbol_visibility_audit
+	if audit.Tool == nil && audit.Denylist == nil {
+		return nil
+	}
+	if audit.Tool == nil || audit.Denylist == nil {
+		ctx.PropertyErrorf("symbol_visibility_audit", "tool and denylist must be set together")
+		return nil
+	}
+	if library.buildStubs() {
+		// Stubs export exactly the symbols of the symbol file.
+		return nil
+	}
+	return transformSharedObjectToSymbolVisibilityAudit(ctx,
+		android.PathForModuleSrc(ctx, *audit.Tool),
+		android.PathForModuleSrc(ctx, *audit.Denylist), sharedLib)
+}
+
+// goldenExportedSymbolsCheck returns the timestamp of the validation comparing the symbols exported
+// by sharedLib with the golden_exported_symbols list, or nil if there is none.
+func (library *libraryDecorator) goldenExportedSymbolsCheck(ctx ModuleContext, sharedLib android.Path) android.Path {
+	golden := library.Properties.Golden_exported_symbols
+	if golden == nil || library.buildStubs() {
+		return nil
+	}
+	if ctx.Darwin() || ctx.Windows() {
+		ctx.PropertyErrorf("golden_exported_symbols", "is only supported for ELF libraries")
+		return nil
+	}
+	return transformSharedObjectToGoldenExportedSymbolsCheck(ctx, android.PathForModuleSrc(ctx, *golden),
+		sharedLib, library.exportedSymbolList(ctx, sharedLib))
+}
+
+// exportedSymbolDenylistCheck returns the timestamp of the check that the shared library exports
+// none of the symbols of the product's ExportedSymbolDenylist, or nil if it isn't checked.
+func (library *libraryDecorator) exportedSymbolDenylistCheck(ctx ModuleContext, sharedLib android.Path) android.Path {
+	denylist := ctx.Config().ExportedSymbolDenylist()
+	if len(denylist) == 0 || !ctx.Device() || library.buildStubs() ||
+		ctx.Config().ExportedSymbolDenylistAllowedForPath(ctx.ModuleDir()) {
+		return nil
+	}
+	return transformExportedSymbolsToDenylistCheck(ctx, sharedLib.Base(),
+		library.exportedSymbolList(ctx, sharedLib), denylist)
+}
+
+// exportedSymbolList returns the list of the symbols exported by the shared library, generating
+// the rule the first time it is called.
+func (library *libraryDecorator) exportedSymbolList(ctx ModuleContext, sharedLib android.Path) android.Path {
+	if !library.exportedSymbolListFile.Valid() {
+		library.exportedSymbolListFile = android.OptionalPathForPath(
+			transformSharedObjectToExportedSymbols(ctx, sharedLib))
+	}
+	return library.exportedSymbolListFile.Path()
+}
+
+// runpathFlags returns the -Wl,-rpath flags for the Runpaths property, translating $ORIGIN to
+// the form expected by the linker of the target.
+func (library *libraryDecorator) runpathFlags(ctx ModuleContext) []string {
+	runpaths := library.Properties.Runpaths
+	if len(runpaths) == 0 {
+		return nil
+	}
+	if ctx.Device() || ctx.Windows() {
+		ctx.PropertyErrorf("runpaths", "is only supported for Linux and Darwin host libraries")
+		return nil
+	}
+
+	origin := `\$$ORIGIN`
+	if ctx.Darwin() {
+		origin = "@loader_path"
+	}
+
+	var ret []string
+	for _, runpath := range runpaths {
+		rest := strings.TrimPrefix(runpath, "$ORIGIN")
+		if rest == runpath || (rest != "" && !strings.HasPrefix(rest, "/")) {
+			ctx.PropertyErrorf("runpaths", "%q must be relative to $ORIGIN", runpath)
+			continue
+		}
+		ret = append(ret, "-Wl,-rpath,"+origin+rest)
+	}
+	return ret
+}
+
 func (library *libraryDecorator) linkShared(ctx ModuleContext,
 	flags Flags, deps PathDeps, objs Objects) android.Path {
 
@@ -1151,22 +2197,82 @@ func (library *libraryDecorator) linkShared(ctx ModuleContext,
 			linkerDeps = append(linkerDeps, forceWeakSymbols.Path())
 		}
 	}
+	symbolOrderingFile := ctx.ExpandOptionalSource(library.Properties.Symbol_ordering_file, "symbol_ordering_file")
+	if symbolOrderingFile.Valid() {
+		m := ctx.Module().(*Module)
+		if ctx.Darwin() || ctx.Windows() {
+			ctx.PropertyErrorf("symbol_ordering_file", "is only supported for ELF targets")
+		} else if m.orderfile != nil && m.orderfile.Properties.OrderfileLoad {
+			ctx.PropertyErrorf("symbol_ordering_file", "can't be used together with orderfile.load_order_file")
+		} else {
+			flags.Local.LdFlags = append(flags.Local.LdFlags,
+				fmt.Sprintf(orderfileUseFormat, symbolOrderingFile.String()))
+			linkerDeps = append(linkerDeps, symbolOrderingFile.Path())
+		}
+	}
 	if library.versionScriptPath.Valid() {
 		linkerScriptFlags := "-Wl,--version-script," + library.versionScriptPath.String()
 		flags.Local.LdFlags = append(flags.Local.LdFlags, linkerScriptFlags)
 		linkerDeps = append(linkerDeps, library.versionScriptPath.Path())
 	}
+	if compress := library.Properties.Compress_debug_sections; compress != nil {
+		switch *compress {
+		case "none", "zlib", "zstd":
+			flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,--compress-debug-sections="+*compress)
+		default:
+			ctx.PropertyErrorf("compress_debug_sections",
+				"invalid value %q, must be one of \"none\", \"zlib\" or \"zstd\"", *compress)
+		}
+	}
+	if linker := library.Properties.Use_linker; linker != nil {
+		switch *linker {
+		case "lld", "gold", "bfd", "mold":
+			if ctx.Darwin() {
+				ctx.PropertyErrorf("use_linker", "is not supported for Darwin")
+				break
+			}
+			if *linker != "lld" {
+				// Clang looks for the linker in its own bin directory before the PATH.
+				linkerPath := android.ExistentPathForSource(ctx, config.ClangPath(ctx, "bin/ld."+*linker).String())
+				if !linkerPath.Valid() {
+					ctx.PropertyErrorf("use_linker", "%q is not available in the toolchain", *linker)
+					break
+				}
+				linkerDeps = append(linkerDeps, linkerPath.Path())
+			}
+			flags.Local.LdFlags = append(flags.Local.LdFlags, "-fuse-ld="+*linker)
+		default:
+			ctx.PropertyErrorf("use_linker",
+				"invalid value %q, must be one of \"lld\", \"gold\", \"bfd\" or \"mold\"", *linker)
+		}
+	}
+	if Bool(library.Properties.Check_no_undefined) && !library.buildStubs() {
+		if Bool(library.baseLinker.Properties.Allow_undefined_symbols) {
+			ctx.PropertyErrorf("check_no_undefined", "cannot be used with allow_undefined_symbols")
+		} else if !ctx.Darwin() && !ctx.Windows() && !hostSanitized(ctx) {
+			// The last of the flags controlling undefined symbols wins.
+			flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,--no-undefined")
+		}
+	}
+	flags.Local.LdFlags = append(flags.Local.LdFlags, library.runpathFlags(ctx)...)
 
 	fileName := library.getLibName(ctx) + flags.Toolchain.ShlibSuffix()
 	outputFile := android.PathForModuleOut(ctx, fileName)
+	if library.buildStubs() {
+		// Keep the stubs in a stubs/<version> subdirectory so that they are easy to tell apart
+		// from the implementation library, which has the same file name.
+		outputFile = android.PathForModuleOut(ctx, "stubs", library.stubsVersion(), fileName)
+	}
 	unstrippedOutputFile := outputFile
 
 	var implicitOutputs android.WritablePaths
+	var importLibrary android.OptionalPath
 	if ctx.Windows() {
 		importLibraryPath := android.PathForModuleOut(ctx, pathtools.ReplaceExtension(fileName, "lib"))
 
 		flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,--out-implib="+importLibraryPath.String())
 		implicitOutputs = append(implicitOutputs, importLibraryPath)
+		importLibrary = android.OptionalPathForPath(importLibraryPath)
 	}
 
 	builderFlags := flagsToBuilderFlags(flags)
@@ -1179,6 +2285,10 @@ func (library *libraryDecorator) linkShared(ctx ModuleContext,
 
 	// Optimize out relinking against shared libraries whose interface hasn't changed by
 	// depending on a table of contents file instead of the library itself.
+	// The TOC is extracted from the final output file. With use_version_lib, that is the output of
+	// the version symbol injection on host; on device the versioned copy is only used for dist.
+	// The injection only rewrites the contents of the soong_build_number symbol, so it never
+	// changes the symbols listed in the TOC either way.
 	tocFile := outputFile.ReplaceExtension(ctx, flags.Toolchain.ShlibSuffix()[1:]+".toc")
 	library.tocFile = android.OptionalPathForPath(tocFile)
 	TransformSharedObjectToToc(ctx, outputFile, tocFile)
@@ -1189,6 +2299,13 @@ func (library *libraryDecorator) linkShared(ctx ModuleContext,
 		// No need to strip stubs libraries
 		needsStrip = false
 	}
+	// The license section is added to the stripped library, so that stripping can't drop it.
+	licenseName, licenseFile := library.licenseSection(ctx)
+	if licenseFile.Valid() {
+		withLicenseOutputFile := outputFile
+		outputFile = android.PathForModuleOut(ctx, "without_license_section", fileName)
+		transformSharedObjectAddSection(ctx, outputFile, withLicenseOutputFile, licenseName, licenseFile.Path())
+	}
 	if needsStrip {
 		if ctx.Darwin() {
 			stripFlags.StripUseGnuStrip = true
@@ -1199,6 +2316,13 @@ func (library *libraryDecorator) linkShared(ctx ModuleContext,
 	}
 	library.unstrippedOutputFile = outputFile
 
+	if metadata := library.buildMetadataSection(ctx); metadata.Valid() {
+		withMetadataOutputFile := outputFile
+		outputFile = android.PathForModuleOut(ctx, "without_build_metadata", fileName)
+		transformSharedObjectAddSection(ctx, outputFile, withMetadataOutputFile,
+			String(library.Properties.Build_metadata_section.Name), metadata.Path())
+	}
+
 	outputFile = maybeInjectBoringSSLHash(ctx, outputFile, library.Properties.Inject_bssl_hash, fileName)
 
 	if Bool(library.baseLinker.Properties.Use_version_lib) {
@@ -1238,18 +2362,109 @@ func (library *libraryDecorator) linkShared(ctx ModuleContext,
 	linkerDeps = append(linkerDeps, deps.EarlySharedLibsDeps...)
 	linkerDeps = append(linkerDeps, deps.SharedLibsDeps...)
 	linkerDeps = append(linkerDeps, deps.LateSharedLibsDeps...)
+
+	validations := android.CopyOfPaths(objs.tidyDepFiles)
+	validations = append(validations, library.exportedHeaderChecks...)
+	if audit := library.symbolVisibilityAudit(ctx, outputFile); audit != nil {
+		validations = append(validations, audit)
+	}
+	validations = append(validations, library.postLinkValidators(ctx, outputFile)...)
+	if check := library.goldenExportedSymbolsCheck(ctx, outputFile); check != nil {
+		validations = append(validations, check)
+	}
+	if check := library.exportedSymbolDenylistCheck(ctx, outputFile); check != nil {
+		validations = append(validations, check)
+	}
+	if licenseFile.Valid() {
+		validations = append(validations, transformElfToSectionCheck(ctx, unstrippedOutputFile, licenseName))
+	}
+	if warning := library.wholeStaticSharedDepsWarning(ctx); warning != nil {
+		validations = append(validations, warning)
+	}
+	if warning := library.variantReexportDivergenceWarning(ctx); warning != nil {
+		validations = append(validations, warning)
+	}
+
+	library.checkCrtObjects(ctx, CrtBeginDepTag, "crt_begin", library.Properties.Crt_begin)
+	library.checkCrtObjects(ctx, CrtEndDepTag, "crt_end", library.Properties.Crt_end)
+
 	transformObjToDynamicBinary(ctx, objs.objFiles, sharedLibs,
 		deps.StaticLibs, deps.LateStaticLibs, deps.WholeStaticLibs,
-		linkerDeps, deps.CrtBegin, deps.CrtEnd, false, builderFlags, outputFile, implicitOutputs, objs.tidyDepFiles)
+		linkerDeps, deps.CrtBegin, deps.CrtEnd, false, builderFlags, outputFile, implicitOutputs, validations)
 
 	objs.coverageFiles = append(objs.coverageFiles, deps.StaticLibObjs.coverageFiles...)
 	objs.coverageFiles = append(objs.coverageFiles, deps.WholeStaticLibObjs.coverageFiles...)
 	objs.sAbiDumpFiles = append(objs.sAbiDumpFiles, deps.StaticLibObjs.sAbiDumpFiles...)
 	objs.sAbiDumpFiles = append(objs.sAbiDumpFiles, deps.WholeStaticLibObjs.sAbiDumpFiles...)
 
-	library.coverageOutputFile = transformCoverageFilesToZip(ctx, objs, library.getLibName(ctx))
+	coverageObjs := library.coverageObjects(ctx, objs)
+	if library.preserveCoverageUnderLto(ctx) && ctx.DeviceConfig().ClangCoverageEnabled() {
+		// The gcno files of gcov coverage are all that is needed, the library is only useful for
+		// the coverage mapping of clang coverage.
+		coverageObjs = coverageObjs.Copy()
+		coverageObjs.coverageFiles = append(coverageObjs.coverageFiles, library.unstrippedOutputFile)
+	}
+	library.coverageOutputFile = transformCoverageFilesToZip(ctx, coverageObjs, library.getLibName(ctx))
 	library.linkSAbiDumpFiles(ctx, objs, fileName, unstrippedOutputFile)
 
+	if Bool(library.Properties.Generate_dwp) && !library.buildStubs() {
+		if !builderFlags.splitDwarf {
+			ctx.PropertyErrorf("generate_dwp", "requires split DWARF, add -gsplit-dwarf to cflags")
+		} else {
+			dwoFiles := append(android.CopyOfPaths(objs.dwoFiles), deps.WholeStaticLibObjs.dwoFiles...)
+			dwpFile := android.PathForModuleOut(ctx, fileName+".dwp")
+			transformDwoFilesToDwp(ctx, android.FirstUniquePaths(dwoFiles), dwpFile)
+			ctx.CheckbuildFile(dwpFile)
+			library.dwpFile = android.OptionalPathForPath(dwpFile)
+		}
+	}
+
+	if Bool(library.Properties.Emit_reloc_stats) && !library.buildStubs() {
+		if ctx.Darwin() || ctx.Windows() {
+			ctx.PropertyErrorf("emit_reloc_stats", "is only supported for ELF targets")
+		} else {
+			relocStatsFile := android.PathForModuleOut(ctx, fileName+".reloc_stats")
+			transformSharedObjectToRelocStats(ctx, unstrippedOutputFile, relocStatsFile)
+			ctx.CheckbuildFile(relocStatsFile)
+			library.relocStatsFile = android.OptionalPathForPath(relocStatsFile)
+		}
+	}
+
+	if Bool(library.Properties.Emit_imports) && !library.buildStubs() {
+		if ctx.Darwin() || ctx.Windows() {
+			ctx.PropertyErrorf("emit_imports", "is only supported for ELF targets")
+		} else {
+			importsFile := android.PathForModuleOut(ctx, fileName+".imports")
+			transformSharedObjectToImportedSymbols(ctx, unstrippedOutputFile, importsFile)
+			ctx.CheckbuildFile(importsFile)
+			library.importsFile = android.OptionalPathForPath(importsFile)
+			ctx.SetProvider(ImportedSymbolListInfoProvider, ImportedSymbolListInfo{
+				SymbolList: importsFile,
+			})
+		}
+	}
+
+	if !library.buildStubs() {
+		for _, format := range android.FirstUniqueStrings(library.Properties.Generate_compressed_copies) {
+			ext, ok := compressExtensions[format]
+			if !ok {
+				ctx.PropertyErrorf("generate_compressed_copies", "%q is not one of gzip, zstd or lz4", format)
+				continue
+			}
+			compressedFile := android.PathForModuleOut(ctx, "compressed", fileName+ext)
+			transformFileToCompressedCopy(ctx, unstrippedOutputFile, compressedFile, format)
+			ctx.CheckbuildFile(compressedFile)
+			library.compressedFiles = append(library.compressedFiles, compressedFile)
+		}
+	}
+
+	if library.boltInstrumentEnabled(ctx) {
+		// The unstripped output has the symbols and relocations llvm-bolt needs.
+		boltInstrumented := android.PathForModuleOut(ctx, "bolt_instrumented", fileName)
+		transformSharedObjectToBoltInstrumented(ctx, library.unstrippedOutputFile, boltInstrumented)
+		library.boltInstrumentedOutputFile = android.OptionalPathForPath(boltInstrumented)
+	}
+
 	var transitiveStaticLibrariesForOrdering *android.DepSet[android.Path]
 	if static := ctx.GetDirectDepsWithTag(staticVariantTag); len(static) > 0 {
 		s := ctx.OtherModuleProvider(static[0], StaticLibraryInfoProvider).(StaticLibraryInfo)
@@ -1258,7 +2473,9 @@ func (library *libraryDecorator) linkShared(ctx ModuleContext,
 
 	ctx.SetProvider(SharedLibraryInfoProvider, SharedLibraryInfo{
 		TableOfContents:                      android.OptionalPathForPath(tocFile),
+		ImportLibrary:                        importLibrary,
 		SharedLibrary:                        unstrippedOutputFile,
+		Objects:                              objs,
 		TransitiveStaticLibrariesForOrdering: transitiveStaticLibrariesForOrdering,
 		Target:                               ctx.Target(),
 	})
@@ -1432,8 +2649,24 @@ func (library *libraryDecorator) optInAbiDiff(ctx android.ModuleContext, referen
 		isLlndkOrNdk, false /* allowExtensions */, "current", errorMessage)
 }
 
+// skipNonPrimaryArchSAbiDump returns true if the ABI dump of this variant is covered by the
+// primary arch variant of the same module, for header_abi_checker.primary_arch_only.
+func (library *libraryDecorator) skipNonPrimaryArchSAbiDump(ctx ModuleContext) bool {
+	if ctx.PrimaryArch() {
+		return false
+	}
+	// Libraries that aren't built for the primary arch, e.g. with compile_multilib: "32" on a
+	// 64-bit device, keep the ABI dumps of their own arches.
+	primaryTarget := ctx.Config().Targets[ctx.Os()][0]
+	return ctx.OtherModuleDependencyVariantExists(primaryTarget.Variations(), ctx.ModuleName())
+}
+
 func (library *libraryDecorator) linkSAbiDumpFiles(ctx ModuleContext, objs Objects, fileName string, soFile android.Path) {
 	if library.sabi.shouldCreateSourceAbiDump() {
+		headerAbiChecker := library.getHeaderAbiCheckerProperties(ctx)
+		if Bool(headerAbiChecker.Primary_arch_only) && library.skipNonPrimaryArchSAbiDump(ctx) {
+			return
+		}
 		exportIncludeDirs := library.flagExporter.exportedIncludes(ctx)
 		var SourceAbiFlags []string
 		for _, dir := range exportIncludeDirs.Strings() {
@@ -1443,32 +2676,60 @@ func (library *libraryDecorator) linkSAbiDumpFiles(ctx ModuleContext, objs Objec
 			SourceAbiFlags = append(SourceAbiFlags, "-I"+reexportedInclude)
 		}
 		exportedHeaderFlags := strings.Join(SourceAbiFlags, " ")
-		headerAbiChecker := library.getHeaderAbiCheckerProperties(ctx)
 		// The logic must be consistent with classifySourceAbiDump.
 		isVndk := ctx.useVndk() && ctx.isVndk()
 		isNdk := ctx.isNdk(ctx.Config())
 		isLlndk := ctx.isImplementationForLLNDKPublic()
 		currVersion := currRefAbiDumpVersion(ctx, isVndk)
 		library.sAbiOutputFile = transformDumpToLinkedDump(ctx, objs.sAbiDumpFiles, soFile, fileName, exportedHeaderFlags,
-			android.OptionalPathForModuleSrc(ctx, library.symbolFileForAbiCheck(ctx)),
+			library.symbolFileForAbiCheck(ctx),
 			headerAbiChecker.Exclude_symbol_versions,
 			headerAbiChecker.Exclude_symbol_tags,
 			currVersion)
 
 		addLsdumpPath(classifySourceAbiDump(ctx) + ":" + library.sAbiOutputFile.String())
+		ctx.SetProvider(SourceAbiDumpInfoProvider, SourceAbiDumpInfo{
+			LinkedDump: library.sAbiOutputFile.Path(),
+		})
+		if Bool(headerAbiChecker.Emit_json_summary) {
+			library.sAbiJsonSummary = android.OptionalPathForPath(
+				transformLinkedDumpToJsonSummary(ctx, library.sAbiOutputFile.Path()))
+		}
+
+		if Bool(headerAbiChecker.Dump_only) {
+			return
+		}
 
 		dumpDir := getRefAbiDumpDir(isNdk, isVndk)
 		binderBitness := ctx.DeviceConfig().BinderBitness()
+		if Bool(headerAbiChecker.Binder_bitness_independent) {
+			// filepath.Join drops the empty element.
+			binderBitness = ""
+		}
 		// If NDK or PLATFORM library, check against previous version ABI.
 		if !isVndk {
 			prevVersionInt := prevRefAbiDumpVersion(ctx, dumpDir)
+			sourceVersion := strconv.Itoa(prevVersionInt + 1)
+			if override := headerAbiChecker.Previous_version; override != nil {
+				if v, err := strconv.Atoi(*override); err == nil && v > 0 {
+					prevVersionInt = v
+				} else {
+					ctx.PropertyErrorf("header_abi_checker.previous_version",
+						"%q is not a valid API level", *override)
+				}
+			}
 			prevVersion := strconv.Itoa(prevVersionInt)
 			prevDumpDir := filepath.Join(dumpDir, prevVersion, binderBitness)
 			prevDumpFile := getRefAbiDumpFile(ctx, prevDumpDir, fileName)
 			if prevDumpFile.Valid() {
 				library.crossVersionAbiDiff(ctx, prevDumpFile.Path(),
 					fileName, isLlndk || isNdk,
-					strconv.Itoa(prevVersionInt+1), prevVersion)
+					sourceVersion, prevVersion)
+			} else if headerAbiChecker.Previous_version != nil {
+				// Don't let a wrong previous_version silently disable the check.
+				library.sAbiDiff = append(library.sAbiDiff, buildWarning(ctx, "missing_previous_abi_dump",
+					fmt.Sprintf("header_abi_checker.previous_version: no reference ABI dump for %s "+
+						"in %s, the cross-version ABI check is skipped", fileName, prevDumpDir)))
 			}
 		}
 		// Check against the current version.
@@ -1479,7 +2740,7 @@ func (library *libraryDecorator) linkSAbiDumpFiles(ctx ModuleContext, objs Objec
 				fileName, isLlndk || isNdk, ctx.IsVndkExt())
 		}
 		// Check against the opt-in reference dumps.
-		for i, optInDumpDir := range headerAbiChecker.Ref_dump_dirs {
+		for i, optInDumpDir := range headerAbiChecker.refDumpDirs(ctx.Config()) {
 			optInDumpDirPath := android.PathForModuleSrc(ctx, optInDumpDir)
 			// Ref_dump_dirs are not versioned.
 			// They do not contain subdir for binder bitness because 64-bit binder has been mandatory.
@@ -1544,6 +2805,9 @@ func (library *libraryDecorator) link(ctx ModuleContext,
 		// if it is set.
 		if override := library.Properties.Llndk.Override_export_include_dirs; override != nil {
 			library.flagExporter.Properties.Export_include_dirs = override
+			if Bool(library.Properties.Llndk.Check_override_export_include_dirs) {
+				library.checkLlndkOverrideExportIncludeDirs(ctx)
+			}
 		}
 
 		if Bool(library.Properties.Llndk.Export_headers_as_system) {
@@ -1566,6 +2830,12 @@ func (library *libraryDecorator) link(ctx ModuleContext,
 	// of this library), together with `objs` (.o files created by compiling this
 	// library).
 	objs = deps.Objs.Copy().Append(objs)
+	library.preprocessedFiles = objs.preprocessedFiles
+	library.asmListingFiles = objs.asmListingFiles
+	library.exportedHeaderChecks = library.checkCApiHeaders(ctx, flags)
+	if check := library.checkExportedHeaderClosure(ctx, deps); check != nil {
+		library.exportedHeaderChecks = append(library.exportedHeaderChecks, check)
+	}
 	var out android.Path
 	if library.static() || library.header() {
 		out = library.linkStatic(ctx, flags, deps, objs)
@@ -1575,7 +2845,11 @@ func (library *libraryDecorator) link(ctx ModuleContext,
 
 	// Export include paths and flags to be propagated up the tree.
 	library.exportIncludes(ctx)
+	library.checkExportedIncludesNonempty(ctx)
 	library.exportExtraFlags(ctx)
+	library.exportRequiredHeader(ctx)
+	library.exportMinCppStd(ctx)
+	library.exportVersionHeader(ctx)
 	library.reexportDirs(deps.ReexportedDirs...)
 	library.reexportSystemDirs(deps.ReexportedSystemDirs...)
 	library.reexportFlags(deps.ReexportedFlags...)
@@ -1585,17 +2859,30 @@ func (library *libraryDecorator) link(ctx ModuleContext,
 	// Optionally export aidl headers.
 	if Bool(library.Properties.Aidl.Export_aidl_headers) {
 		if library.baseCompiler.hasAidl(deps) {
+			var dirs android.Paths
 			if library.baseCompiler.hasSrcExt(".aidl") {
-				dir := android.PathForModuleGen(ctx, "aidl")
-				library.reexportDirs(dir)
+				dirs = append(dirs, android.PathForModuleGen(ctx, "aidl"))
 			}
 			if len(deps.AidlLibraryInfos) > 0 {
-				dir := android.PathForModuleGen(ctx, "aidl_library")
-				library.reexportDirs(dir)
+				dirs = append(dirs, android.PathForModuleGen(ctx, "aidl_library"))
 			}
 
-			library.reexportDeps(library.baseCompiler.aidlOrderOnlyDeps...)
-			library.addExportedGeneratedHeaders(library.baseCompiler.aidlHeaders...)
+			if Bool(library.Properties.Aidl.Export_aidl_headers_in_module_dir) {
+				// Only export the per-module staged directories, so that dependents of several
+				// such libraries can't include the wrong header. The aidl gen directories are
+				// only on the include path of this module.
+				for _, dir := range dirs {
+					_, headers := android.FilterPathListPredicate(library.baseCompiler.aidlHeaders, func(path android.Path) bool {
+						_, isRel := android.MaybeRel(ctx, dir.String(), path.String())
+						return isRel
+					})
+					library.exportGeneratedHeadersWithPrefix(ctx, dir.Base(), dir, headers, ctx.ModuleName())
+				}
+			} else {
+				library.reexportDirs(dirs...)
+				library.reexportDeps(library.baseCompiler.aidlOrderOnlyDeps...)
+				library.addExportedGeneratedHeaders(library.baseCompiler.aidlHeaders...)
+			}
 		}
 	}
 
@@ -1639,17 +2926,391 @@ func (library *libraryDecorator) link(ctx ModuleContext,
 		library.reexportDirs(dir)
 		library.reexportDeps(library.baseCompiler.syspropOrderOnlyDeps...)
 		library.addExportedGeneratedHeaders(headers...)
+
+		if prefix := String(library.Properties.Sysprop.Export_prefix); prefix != "" {
+			if filepath.IsAbs(prefix) || strings.HasPrefix(filepath.Clean(prefix), "..") {
+				ctx.ModuleErrorf("sysprop export prefix %q must be a relative path inside the include directory", prefix)
+			} else {
+				library.exportGeneratedHeadersWithPrefix(ctx, "sysprop", dir, headers, prefix)
+			}
+		}
 	}
 
 	// Add stub-related flags if this library is a stub library.
 	library.exportVersioningMacroIfNeeded(ctx)
 
+	library.checkVariantExportConsistency(ctx)
+
+	if Bool(library.Properties.Generate_headers_zip) && !library.buildStubs() {
+		library.buildHeadersZip(ctx)
+	}
+
+	if Bool(library.Properties.Check_generated_header_collisions) && !library.buildStubs() {
+		library.checkGeneratedHeaderCollisions(ctx)
+	}
+
+	if Bool(library.Properties.Check_no_system_header_shadowing) && !library.buildStubs() {
+		library.checkNoSystemHeaderShadowing(ctx)
+	}
+
+	if ctx.Host() && library.Properties.Pkg_config.Name != nil && (library.static() || library.shared()) {
+		library.buildPkgConfig(ctx, out)
+	}
+
 	// Propagate a Provider containing information about exported flags, deps, and include paths.
 	library.flagExporter.setProvider(ctx)
+	library.setReexportedHeaderLibsProvider(ctx)
+
+	library.setStubSymbolFileProvider(ctx)
+
+	if library.static() || library.shared() {
+		ctx.SetProvider(SanitizerInfoProvider, SanitizerInfo{
+			Sanitizers: library.baseLinker.sanitize.enabledSanitizers(),
+		})
+
+		distFile := library.distFile
+		if distFile == nil {
+			distFile = out
+		}
+		ctx.SetProvider(DistArtifactInfoProvider, DistArtifactInfo{
+			DistFile: distFile,
+		})
+	}
+
+	if library.shared() {
+		library.setExportedSymbolListProvider(ctx)
+		if library.hasStubsVariants() {
+			ctx.SetProvider(StubImplementationInfoProvider, StubImplementationInfo{
+				ImplementationRequired: library.isStubsImplementationRequired(),
+				StubsVersions:          library.allStubsVersions(),
+			})
+		}
+	}
 
 	return out
 }
 
+// buildHeadersZip zips the headers exported by this library, with each header stored at its path
+// relative to the exported include directory that contains it.
+func (library *libraryDecorator) buildHeadersZip(ctx ModuleContext) {
+	dirs := append(android.CopyOfPaths(library.flagExporter.dirs), library.flagExporter.systemDirs...)
+	generatedHeaders := GlobGeneratedHeadersForSnapshot(ctx, library.flagExporter.headers)
+
+	headersZip := android.PathForModuleOut(ctx, "headers.zip")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().
+		BuiltTool("soong_zip").
+		FlagWithOutput("-o ", headersZip)
+
+	// A header below several exported directories (e.g. "include" and "include/foo") is only
+	// stored once, relative to the first directory that exports it.
+	seen := make(map[string]bool)
+	for _, dir := range android.FirstUniquePaths(dirs) {
+		headers := GlobHeadersForSnapshot(ctx, android.Paths{dir})
+		for _, header := range generatedHeaders {
+			if _, isRel := android.MaybeRel(ctx, dir.String(), header.String()); isRel {
+				headers = append(headers, header)
+			}
+		}
+		var dirHeaders android.Paths
+		for _, header := range headers {
+			if !seen[header.String()] {
+				seen[header.String()] = true
+				dirHeaders = append(dirHeaders, header)
+			}
+		}
+		if len(dirHeaders) == 0 {
+			continue
+		}
+		cmd.FlagWithArg("-C ", dir.String())
+		for _, header := range dirHeaders {
+			cmd.FlagWithInput("-f ", header)
+		}
+	}
+
+	rule.Build("headers_zip", "zip exported headers")
+	ctx.CheckbuildFile(headersZip)
+	library.headersZip = android.OptionalPathForPath(headersZip)
+}
+
+// checkGeneratedHeaderCollisions reports an error for each generated header exported by this
+// library that has the same path relative to an exported include directory as a header in one of
+// the exported source directories.
+func (library *libraryDecorator) checkGeneratedHeaderCollisions(ctx ModuleContext) {
+	dirs := append(android.CopyOfPaths(library.flagExporter.dirs), library.flagExporter.systemDirs...)
+	dirs = android.FirstUniquePaths(dirs)
+
+	sourceHeaders := make(map[string]android.Path)
+	for _, dir := range dirs {
+		for _, header := range GlobHeadersForSnapshot(ctx, android.Paths{dir}) {
+			if rel, isRel := android.MaybeRel(ctx, dir.String(), header.String()); isRel {
+				if _, exists := sourceHeaders[rel]; !exists {
+					sourceHeaders[rel] = header
+				}
+			}
+		}
+	}
+
+	for _, header := range GlobGeneratedHeadersForSnapshot(ctx, library.flagExporter.headers) {
+		for _, dir := range dirs {
+			rel, isRel := android.MaybeRel(ctx, dir.String(), header.String())
+			if !isRel {
+				continue
+			}
+			if source, exists := sourceHeaders[rel]; exists {
+				ctx.PropertyErrorf("check_generated_header_collisions",
+					"generated header %q collides with source header %q, both are included as %q",
+					header.Rel(), source.String(), rel)
+			}
+		}
+	}
+}
+
+// systemHeaders are the standard C, POSIX and C++ headers that must not be shadowed by the
+// exported headers of libraries with check_no_system_header_shadowing. They are the paths the
+// headers are included with, so a header only shadows one of them if its path relative to an
+// exported include directory is the same, e.g. "include/sys/types.h" for an exported "include"
+// directory shadows <sys/types.h>, but "include/foo/string.h" doesn't shadow <string.h>.
+var systemHeaders = []string{
+	"assert.h", "complex.h", "ctype.h", "errno.h", "fenv.h", "float.h", "inttypes.h", "iso646.h",
+	"limits.h", "locale.h", "math.h", "setjmp.h", "signal.h", "stdalign.h", "stdarg.h",
+	"stdatomic.h", "stdbool.h", "stddef.h", "stdint.h", "stdio.h", "stdlib.h", "stdnoreturn.h",
+	"string.h", "tgmath.h", "threads.h", "time.h", "uchar.h", "wchar.h", "wctype.h",
+	"dlfcn.h", "fcntl.h", "pthread.h", "unistd.h", "sys/stat.h", "sys/types.h",
+	"algorithm", "any", "array", "atomic", "bitset", "cassert", "cctype", "cerrno", "cfloat",
+	"chrono", "cinttypes", "climits", "clocale", "cmath", "condition_variable", "csetjmp",
+	"csignal", "cstdarg", "cstddef", "cstdint", "cstdio", "cstdlib", "cstring", "ctime", "cwchar",
+	"deque", "exception", "filesystem", "forward_list", "fstream", "functional", "future",
+	"initializer_list", "iomanip", "ios", "iosfwd", "iostream", "istream", "iterator", "limits",
+	"list", "locale", "map", "memory", "mutex", "new", "numeric", "optional", "ostream", "queue",
+	"random", "ratio", "regex", "set", "shared_mutex", "sstream", "stack", "stdexcept",
+	"streambuf", "string", "string_view", "system_error", "thread", "tuple", "type_traits",
+	"typeindex", "typeinfo", "unordered_map", "unordered_set", "utility", "valarray", "variant",
+	"vector",
+}
+
+// checkNoSystemHeaderShadowing reports an error for each header in the exported include
+// directories of this library whose path relative to the directory is one of systemHeaders,
+// unless it is listed in system_header_shadowing_allowlist. Headers in the source tree are looked
+// up by path rather than globbed, as the C++ headers have no extension.
+func (library *libraryDecorator) checkNoSystemHeaderShadowing(ctx ModuleContext) {
+	dirs := append(android.CopyOfPaths(library.flagExporter.dirs), library.flagExporter.systemDirs...)
+	generatedHeaders := GlobGeneratedHeadersForSnapshot(ctx, library.flagExporter.headers)
+	for _, dir := range android.FirstUniquePaths(dirs) {
+		var headers android.Paths
+		if !strings.HasPrefix(dir.String(), ctx.Config().OutDir()) {
+			for _, name := range systemHeaders {
+				if header := android.ExistentPathForSource(ctx, dir.String(), name); header.Valid() {
+					headers = append(headers, header.Path())
+				}
+			}
+		}
+		headers = append(headers, generatedHeaders...)
+		for _, header := range headers {
+			rel, isRel := android.MaybeRel(ctx, dir.String(), header.String())
+			if !isRel || !android.InList(rel, systemHeaders) {
+				continue
+			}
+			if android.InList(rel, library.Properties.System_header_shadowing_allowlist) {
+				continue
+			}
+			ctx.PropertyErrorf("check_no_system_header_shadowing",
+				"exported header %q shadows the system header <%s>", header.String(), rel)
+		}
+	}
+}
+
+// licenseSection returns the name of the license section of a shared library and the file with
+// its contents, if license_section is set.
+func (library *libraryDecorator) licenseSection(ctx ModuleContext) (string, android.OptionalPath) {
+	props := library.Properties.License_section
+	if (props.Name == nil && props.File == nil) || library.buildStubs() {
+		return "", android.OptionalPath{}
+	}
+	if ctx.Darwin() || ctx.Windows() {
+		ctx.PropertyErrorf("license_section", "is only supported for ELF libraries")
+		return "", android.OptionalPath{}
+	}
+	name := String(props.Name)
+	if !strings.HasPrefix(name, ".") || strings.ContainsAny(name, "= ") {
+		ctx.PropertyErrorf("license_section.name", "%q is not a valid section name", name)
+		return "", android.OptionalPath{}
+	}
+	if props.File == nil {
+		ctx.PropertyErrorf("license_section.file", "must be set")
+		return "", android.OptionalPath{}
+	}
+	return name, android.OptionalPathForPath(android.PathForModuleSrc(ctx, *props.File))
+}
+
+// buildMetadataSection writes the contents of the build metadata section of a shared library, if
+// build_metadata_section is set.
+func (library *libraryDecorator) buildMetadataSection(ctx ModuleContext) android.OptionalPath {
+	props := library.Properties.Build_metadata_section
+	if props.Name == nil || library.buildStubs() {
+		return android.OptionalPath{}
+	}
+	if ctx.Darwin() || ctx.Windows() {
+		ctx.PropertyErrorf("build_metadata_section", "is only supported for ELF libraries")
+		return android.OptionalPath{}
+	}
+	name := String(props.Name)
+	if !strings.HasPrefix(name, ".") || strings.ContainsAny(name, "= ") {
+		ctx.PropertyErrorf("build_metadata_section.name", "%q is not a valid section name", name)
+		return android.OptionalPath{}
+	}
+
+	var lines []string
+	for _, entry := range props.Entries {
+		if !strings.Contains(entry, "=") {
+			ctx.PropertyErrorf("build_metadata_section.entries", "%q is not of the form key=value", entry)
+			continue
+		}
+		expanded, err := android.Expand(entry, func(v string) (string, error) {
+			switch v {
+			case "module":
+				return ctx.ModuleName(), nil
+			case "platform_version":
+				return ctx.Config().PlatformVersionName(), nil
+			case "platform_sdk_version":
+				return ctx.Config().PlatformSdkVersion().String(), nil
+			case "build_id":
+				return ctx.Config().BuildId(), nil
+			default:
+				return "", fmt.Errorf("unknown variable $(%s)", v)
+			}
+		})
+		if err != nil {
+			ctx.PropertyErrorf("build_metadata_section.entries", "%q: %s", entry, err)
+			continue
+		}
+		lines = append(lines, expanded)
+	}
+
+	metadataFile := android.PathForModuleOut(ctx, "build_metadata", strings.TrimPrefix(name, ".")+".txt")
+	android.WriteFileRule(ctx, metadataFile, strings.Join(lines, "\n")+"\n")
+	library.buildMetadataFile = android.OptionalPathForPath(metadataFile)
+	return library.buildMetadataFile
+}
+
+// buildPkgConfig writes the pkg-config file of a host library, describing its exported include
+// directories and how to link against it.
+func (library *libraryDecorator) buildPkgConfig(ctx ModuleContext, out android.Path) {
+	props := library.Properties.Pkg_config
+	name := String(props.Name)
+	if name == "" || strings.ContainsRune(name, '/') {
+		ctx.PropertyErrorf("pkg_config.name", "%q is not a valid pkg-config package name", name)
+		return
+	}
+
+	var cflags []string
+	for _, dir := range library.flagExporter.dirs {
+		cflags = append(cflags, "-I"+dir.String())
+	}
+	for _, dir := range library.flagExporter.systemDirs {
+		cflags = append(cflags, "-isystem "+dir.String())
+	}
+	cflags = append(cflags, library.flagExporter.flags...)
+	cflags = append(cflags, props.Cflags...)
+
+	libs := []string{
+		"-L" + filepath.Dir(out.String()),
+		"-l" + strings.TrimPrefix(library.getLibName(ctx), "lib"),
+	}
+	libs = append(libs, props.Libs...)
+
+	content := strings.Join([]string{
+		"Name: " + name,
+		"Description: " + String(props.Description),
+		"Version: " + String(props.Version),
+		"Cflags: " + strings.Join(android.FirstUniqueStrings(cflags), " "),
+		"Libs: " + strings.Join(libs, " "),
+	}, "\n") + "\n"
+
+	pcFile := android.PathForModuleOut(ctx, name+".pc")
+	android.WriteFileRule(ctx, pcFile, content)
+	ctx.CheckbuildFile(pcFile)
+	library.pkgConfigFile = android.OptionalPathForPath(pcFile)
+}
+
+// setReexportedHeaderLibsProvider propagates the names of the header libraries whose headers this
+// library reexports.
+func (library *libraryDecorator) setReexportedHeaderLibsProvider(ctx ModuleContext) {
+	var headerLibs []string
+	ctx.VisitDirectDeps(func(dep android.Module) {
+		if tag, ok := ctx.OtherModuleDependencyTag(dep).(libraryDependencyTag); ok && tag.header() && tag.reexportFlags {
+			headerLibs = append(headerLibs, ctx.OtherModuleName(dep))
+		}
+	})
+	ctx.SetProvider(ReexportedHeaderLibsInfoProvider, ReexportedHeaderLibsInfo{
+		HeaderLibs: android.FirstUniqueStrings(headerLibs),
+	})
+}
+
+// setExportedSymbolListProvider propagates the list of symbols exported by the version script
+// the shared library is linked with, if any.
+func (library *libraryDecorator) setExportedSymbolListProvider(ctx ModuleContext) {
+	versionScript := library.versionScriptPath
+	if !versionScript.Valid() && !library.buildStubs() {
+		versionScript = library.baseLinker.versionScript(ctx)
+	}
+	if !versionScript.Valid() || ctx.Darwin() {
+		return
+	}
+	symbolList := android.PathForModuleOut(ctx, library.getLibName(ctx)+".syms")
+	transformVersionScriptToSymbolList(ctx, versionScript.Path(), symbolList)
+	ctx.SetProvider(ExportedSymbolListInfoProvider, ExportedSymbolListInfo{
+		SymbolList: symbolList,
+	})
+}
+
+// exportGeneratedHeadersWithPrefix copies the selected generated headers, which are relative to
+// dir, under prefix in a separate include directory named after kind and exports it.
+func (library *libraryDecorator) exportGeneratedHeadersWithPrefix(ctx ModuleContext, kind string,
+	dir android.Path, headers android.Paths, prefix string) {
+
+	stagedDir := android.PathForModuleGen(ctx, kind+"_export", "include")
+	var stagedHeaders android.Paths
+	for _, header := range headers {
+		rel, _ := android.MaybeRel(ctx, dir.String(), header.String())
+		stagedHeader := stagedDir.Join(ctx, prefix, rel)
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        android.Cp,
+			Description: "stage " + kind + " header " + rel,
+			Input:       header,
+			Output:      stagedHeader,
+		})
+		stagedHeaders = append(stagedHeaders, stagedHeader)
+	}
+
+	library.reexportDirs(stagedDir)
+	library.reexportDeps(stagedHeaders...)
+	library.addExportedGeneratedHeaders(stagedHeaders...)
+}
+
+// setStubSymbolFileProvider propagates the symbol file the stubs of this library variant are
+// generated from, if any.
+func (library *libraryDecorator) setStubSymbolFileProvider(ctx ModuleContext) {
+	var symbolFile android.Path
+	if ctx.IsLlndk() {
+		if String(library.Properties.Llndk.Symbol_file) != "" {
+			symbolFile = android.PathForModuleSrc(ctx, *library.Properties.Llndk.Symbol_file)
+		}
+	} else if ctx.IsVendorPublicLibrary() {
+		if String(library.Properties.Vendor_public_library.Symbol_file) != "" {
+			symbolFile = android.PathForModuleSrc(ctx, *library.Properties.Vendor_public_library.Symbol_file)
+		}
+	} else if library.hasStubsVariants() && library.hasStubsSymbolFile() {
+		symbolFile = library.stubsSymbolFile(ctx)
+	}
+	if symbolFile == nil {
+		return
+	}
+	ctx.SetProvider(StubSymbolFileInfoProvider, StubSymbolFileInfo{
+		SymbolFile: symbolFile,
+	})
+}
+
 func (library *libraryDecorator) exportVersioningMacroIfNeeded(ctx android.BaseModuleContext) {
 	if library.buildStubs() && library.stubsVersion() != "" && !library.skipAPIDefine {
 		name := versioningMacroName(ctx.Module().(*Module).ImplementationModuleName(ctx))
@@ -1661,6 +3322,289 @@ func (library *libraryDecorator) exportVersioningMacroIfNeeded(ctx android.BaseM
 	}
 }
 
+// checkBuildableVariants reports an error if both the static and the shared variants of a
+// non-header library have been disabled, since the module would otherwise silently produce no
+// output.
+func (library *libraryDecorator) checkBuildableVariants(ctx android.BaseModuleContext) {
+	if !library.MutatedProperties.BuildStatic && !library.MutatedProperties.BuildShared {
+		// Header-only library.
+		return
+	}
+	if library.buildStatic() || library.buildShared() {
+		return
+	}
+	ctx.ModuleErrorf("static and shared variants are both disabled by `static: { enabled: false }` " +
+		"and `shared: { enabled: false }`; remove one of them, or use cc_library_headers for a " +
+		"header-only library")
+}
+
+// checkCrtObjects reports an error for each of the modules named by the crt_begin or crt_end
+// property that isn't a cc_object, as only objects can be linked as crt objects.
+func (library *libraryDecorator) checkCrtObjects(ctx ModuleContext, tag blueprint.DependencyTag,
+	property string, names []string) {
+
+	if len(names) == 0 {
+		return
+	}
+	ctx.VisitDirectDepsWithTag(tag, func(dep android.Module) {
+		name := ctx.OtherModuleName(dep)
+		if !android.InList(name, names) {
+			return
+		}
+		if ccDep, ok := dep.(LinkableInterface); !ok || !ccDep.Object() {
+			ctx.PropertyErrorf(property, "%q is not a cc_object module", name)
+		}
+	})
+}
+
+// testStaticVariant returns true if a static variant should be created for this shared-only
+// library because test_static_variant is set and the build is for tests.
+func (library *libraryDecorator) testStaticVariant(ctx android.BaseModuleContext) bool {
+	if !Bool(library.Properties.Test_static_variant) || !ctx.Config().TestStaticVariants() {
+		return false
+	}
+	return library.MutatedProperties.BuildShared && !library.MutatedProperties.BuildStatic &&
+		BoolDefault(library.StaticProperties.Static.Enabled, true)
+}
+
+// exportRequiredHeader exports a -include flag for export_required_header, after checking that
+// the header is visible to the dependents through the exported include directories.
+func (library *libraryDecorator) exportRequiredHeader(ctx ModuleContext) {
+	if library.Properties.Export_required_header == nil {
+		return
+	}
+	header := android.PathForModuleSrc(ctx, *library.Properties.Export_required_header)
+
+	exportedDirs := append(library.flagExporter.exportedIncludes(ctx),
+		android.PathsForModuleSrc(ctx, library.flagExporter.Properties.Export_system_include_dirs)...)
+	for _, dir := range exportedDirs {
+		if strings.HasPrefix(header.String(), dir.String()+"/") {
+			library.reexportFlags("-include " + header.String())
+			return
+		}
+	}
+	ctx.PropertyErrorf("export_required_header", "%q is not under any of the exported include directories %q",
+		header, exportedDirs)
+}
+
+// cplusplusForCppStd maps the values of export_min_cpp_std to the value of __cplusplus for the
+// standard.
+var cplusplusForCppStd = map[string]string{
+	"c++11": "201103L",
+	"c++14": "201402L",
+	"c++17": "201703L",
+	"c++20": "202002L",
+}
+
+// exportMinCppStd generates and exports a force-included header that checks that the dependents
+// are compiled with at least the C++ standard of export_min_cpp_std.
+func (library *libraryDecorator) exportMinCppStd(ctx ModuleContext) {
+	if library.Properties.Export_min_cpp_std == nil {
+		return
+	}
+	std := *library.Properties.Export_min_cpp_std
+	cplusplus, ok := cplusplusForCppStd[strings.Replace(std, "gnu++", "c++", 1)]
+	if !ok {
+		ctx.PropertyErrorf("export_min_cpp_std", "%q is not one of %s", std,
+			strings.Join(android.SortedKeys(cplusplusForCppStd), ", "))
+		return
+	}
+
+	header := android.PathForModuleGen(ctx, "min_cpp_std", ctx.ModuleName()+"_min_cpp_std.h")
+	android.WriteFileRule(ctx, header, fmt.Sprintf(`#pragma once
+#if defined(__cplusplus) && __cplusplus < %s
+#error "the headers of %s require %s or newer"
+#endif`, cplusplus, ctx.ModuleName(), std))
+
+	library.reexportFlags("-include " + header.String())
+	library.reexportDeps(header)
+	library.addExportedGeneratedHeaders(header)
+}
+
+// exportVersionHeader generates the header of generate_version_header and exports the directory it
+// is generated in.
+func (library *libraryDecorator) exportVersionHeader(ctx ModuleContext) {
+	props := library.Properties.Generate_version_header
+	if len(props.Macros) == 0 {
+		if props.Name != nil {
+			ctx.PropertyErrorf("generate_version_header.name", "requires generate_version_header.macros")
+		}
+		return
+	}
+	name := proptools.StringDefault(props.Name, ctx.ModuleName()+"_version.h")
+	if filepath.IsAbs(name) || strings.HasPrefix(filepath.Clean(name), "..") || filepath.Ext(name) != ".h" {
+		ctx.PropertyErrorf("generate_version_header.name", "%q must be a relative path to a .h file", name)
+		return
+	}
+
+	vars := map[string]string{
+		"PLATFORM_SDK_VERSION":    ctx.Config().PlatformSdkVersion().String(),
+		"PLATFORM_SDK_CODENAME":   ctx.Config().PlatformSdkCodename(),
+		"PLATFORM_VERSION":        ctx.Config().PlatformVersionName(),
+		"PLATFORM_SECURITY_PATCH": ctx.Config().PlatformSecurityPatch(),
+	}
+	lines := []string{"#pragma once", ""}
+	for _, macro := range props.Macros {
+		macroName, value, _ := strings.Cut(macro, "=")
+		if macroName == "" || charsNotForMacro.MatchString(macroName) {
+			ctx.PropertyErrorf("generate_version_header.macros", "%q is not a NAME=value entry", macro)
+			continue
+		}
+		expanded, err := android.Expand(value, func(variable string) (string, error) {
+			if expanded, ok := vars[variable]; ok {
+				return expanded, nil
+			}
+			return "", fmt.Errorf("unknown variable $(%s), expected one of %s", variable,
+				strings.Join(android.SortedKeys(vars), ", "))
+		})
+		if err != nil {
+			ctx.PropertyErrorf("generate_version_header.macros", "%s", err)
+			continue
+		}
+		lines = append(lines, "#define "+macroName+" "+expanded)
+	}
+
+	dir := android.PathForModuleGen(ctx, "version_header")
+	header := dir.Join(ctx, name)
+	android.WriteFileRule(ctx, header, strings.Join(lines, "\n")+"\n")
+
+	library.reexportDirs(dir)
+	library.reexportDeps(header)
+	library.addExportedGeneratedHeaders(header)
+}
+
+// checkCApiHeaders returns the timestamps of the checks that each of the c_api_headers compiles as
+// C, after checking that they are exported.
+func (library *libraryDecorator) checkCApiHeaders(ctx ModuleContext, flags Flags) android.Paths {
+	if len(library.Properties.C_api_headers) == 0 || library.buildStubs() {
+		return nil
+	}
+	exportedDirs := append(library.flagExporter.exportedIncludes(ctx),
+		android.PathsForModuleSrc(ctx, library.flagExporter.Properties.Export_system_include_dirs)...)
+	builderFlags := flagsToBuilderFlags(flags)
+
+	var checks android.Paths
+	for _, header := range android.PathsForModuleSrc(ctx, library.Properties.C_api_headers) {
+		exported := false
+		for _, dir := range exportedDirs {
+			if strings.HasPrefix(header.String(), dir.String()+"/") {
+				exported = true
+				break
+			}
+		}
+		if !exported {
+			ctx.PropertyErrorf("c_api_headers", "%q is not under any of the exported include directories %q",
+				header, exportedDirs)
+			continue
+		}
+		checks = append(checks, transformHeaderToCSyntaxCheck(ctx, header, builderFlags,
+			library.baseCompiler.pathDeps))
+	}
+	return checks
+}
+
+// checkExportedHeaderClosure returns the timestamp of the check that the exported headers only
+// include exported headers, if check_exported_header_closure is set.
+func (library *libraryDecorator) checkExportedHeaderClosure(ctx ModuleContext, deps PathDeps) android.Path {
+	if !Bool(library.Properties.Check_exported_header_closure) || library.buildStubs() {
+		return nil
+	}
+	exportedDirs := append(library.flagExporter.exportedIncludes(ctx),
+		android.PathsForModuleSrc(ctx, library.flagExporter.Properties.Export_system_include_dirs)...)
+	headers := GlobHeadersForSnapshot(ctx, exportedDirs)
+	if len(headers) == 0 {
+		return nil
+	}
+	exportedDirs = append(exportedDirs, deps.ReexportedDirs...)
+	exportedDirs = append(exportedDirs, deps.ReexportedSystemDirs...)
+
+	var includeDirs android.Paths
+	if library.baseCompiler.includeBuildDirectory() {
+		includeDirs = append(includeDirs, android.PathForModuleSrc(ctx))
+	}
+	includeDirs = append(includeDirs, android.PathsForModuleSrc(ctx, library.baseCompiler.Properties.Local_include_dirs)...)
+	includeDirs = append(includeDirs, android.PathsForSource(ctx, library.baseCompiler.Properties.Include_dirs)...)
+
+	return transformHeadersToClosureCheck(ctx, headers, android.FirstUniquePaths(exportedDirs), includeDirs)
+}
+
+// checkExportedIncludesNonempty reports an error if check_exported_includes_nonempty is set and
+// one of the export_include_dirs has no header files, as found by GlobHeadersForSnapshot.
+func (library *libraryDecorator) checkExportedIncludesNonempty(ctx ModuleContext) {
+	if !Bool(library.Properties.Check_exported_includes_nonempty) {
+		return
+	}
+	for _, dir := range library.flagExporter.exportedIncludes(ctx) {
+		if len(GlobHeadersForSnapshot(ctx, android.Paths{dir})) == 0 {
+			ctx.PropertyErrorf("export_include_dirs", "%q does not contain any header files", dir)
+		}
+	}
+}
+
+// checkLlndkOverrideExportIncludeDirs reports an error for each of llndk.override_export_include_dirs
+// that doesn't exist or has no header files, as found by GlobHeadersForSnapshot.
+func (library *libraryDecorator) checkLlndkOverrideExportIncludeDirs(ctx ModuleContext) {
+	for _, dir := range android.PathsForModuleSrc(ctx, library.Properties.Llndk.Override_export_include_dirs) {
+		if len(GlobHeadersForSnapshot(ctx, android.Paths{dir})) == 0 {
+			ctx.PropertyErrorf("llndk.override_export_include_dirs",
+				"%q does not exist or does not contain any header files", dir)
+		}
+	}
+}
+
+// checkVariantExportConsistency reports an error if check_variant_export_consistency is set and
+// the include directories exported by this shared variant differ from those exported by the
+// static variant of the same module.
+func (library *libraryDecorator) checkVariantExportConsistency(ctx ModuleContext) {
+	if !Bool(library.Properties.Check_variant_export_consistency) || !library.shared() || library.buildStubs() {
+		return
+	}
+	static := ctx.GetDirectDepsWithTag(staticVariantTag)
+	if len(static) == 0 {
+		// Only a shared variant is built, so there is nothing to compare against.
+		return
+	}
+	staticInfo := ctx.OtherModuleProvider(static[0], FlagExporterInfoProvider).(FlagExporterInfo)
+
+	check := func(what string, shared, static android.Paths) {
+		if diff, onlyShared, onlyStatic := android.ListSetDifference(shared.Strings(), static.Strings()); diff {
+			ctx.PropertyErrorf("check_variant_export_consistency",
+				"%s exported by the shared and static variants differ: only in shared %q, only in static %q",
+				what, onlyShared, onlyStatic)
+		}
+	}
+	check("include dirs", android.FirstUniquePaths(library.flagExporter.dirs), staticInfo.IncludeDirs)
+	check("system include dirs", android.FirstUniquePaths(library.flagExporter.systemDirs), staticInfo.SystemIncludeDirs)
+}
+
+// variantReexportDivergenceWarning returns the timestamp of a warning listing the libraries whose
+// headers are reexported by only one of the static and shared variants of this library, or nil if
+// both reexport the same ones or allow_variant_reexport_divergence is set.
+func (library *libraryDecorator) variantReexportDivergenceWarning(ctx ModuleContext) android.Path {
+	if Bool(library.Properties.Allow_variant_reexport_divergence) || !library.shared() ||
+		!library.buildStatic() || library.buildStubs() {
+		return nil
+	}
+	var divergences []string
+	check := func(property string, static, shared []string) {
+		if diff, onlyShared, onlyStatic := android.ListSetDifference(shared, static); diff {
+			divergences = append(divergences, fmt.Sprintf("%s only in shared %q, only in static %q",
+				property, onlyShared, onlyStatic))
+		}
+	}
+	check("export_static_lib_headers", library.StaticProperties.Static.Export_static_lib_headers,
+		library.SharedProperties.Shared.Export_static_lib_headers)
+	check("export_shared_lib_headers", library.StaticProperties.Static.Export_shared_lib_headers,
+		library.SharedProperties.Shared.Export_shared_lib_headers)
+	if len(divergences) == 0 {
+		return nil
+	}
+	return buildWarning(ctx, "variant_reexport_divergence", "the static and shared variants "+
+		"reexport the headers of different libraries, so dependents see different headers "+
+		"depending on how they link against it, set allow_variant_reexport_divergence if "+
+		"intended: "+strings.Join(divergences, "; "))
+}
+
 // buildStatic returns true if this library should be built as a static library.
 func (library *libraryDecorator) buildStatic() bool {
 	return library.MutatedProperties.BuildStatic &&
@@ -1703,7 +3647,22 @@ func (library *libraryDecorator) installSymlinkToRuntimeApex(ctx ModuleContext,
 }
 
 func (library *libraryDecorator) install(ctx ModuleContext, file android.Path) {
+	if subdir := String(library.Properties.Library_install_subdir); subdir != "" {
+		if filepath.IsAbs(subdir) || strings.HasPrefix(filepath.Clean(subdir), "..") {
+			ctx.PropertyErrorf("library_install_subdir", "%q must be a relative path inside the partition", subdir)
+			return
+		}
+		library.baseInstaller.dir = filepath.Clean(subdir)
+		library.baseInstaller.dir64 = filepath.Clean(subdir)
+	}
+
 	if library.shared() {
+		if Bool(library.Properties.Generate_checksum) {
+			checksumFile := android.PathForModuleOut(ctx, "checksum", file.Base()+".sha256")
+			transformFileToChecksum(ctx, file, checksumFile)
+			library.checksumFile = android.OptionalPathForPath(checksumFile)
+		}
+
 		if ctx.Device() && ctx.useVndk() {
 			// set subDir for VNDK extensions
 			if ctx.IsVndkExt() {
@@ -1758,6 +3717,16 @@ func (library *libraryDecorator) install(ctx ModuleContext, file android.Path) {
 		}
 
 		library.baseInstaller.install(ctx, file)
+
+		if library.checksumFile.Valid() {
+			checksumFile := library.checksumFile.Path()
+			ctx.InstallFile(library.baseInstaller.installDir(ctx), checksumFile.Base(), checksumFile)
+		}
+
+		if library.pkgConfigFile.Valid() {
+			pcFile := library.pkgConfigFile.Path()
+			ctx.InstallFile(library.baseInstaller.installDir(ctx).Join(ctx, "pkgconfig"), pcFile.Base(), pcFile)
+		}
 	}
 
 	if Bool(library.Properties.Static_ndk_lib) && library.static() &&
@@ -1852,26 +3821,67 @@ func (library *libraryDecorator) buildStubs() bool {
 	return library.MutatedProperties.BuildStubs
 }
 
-func (library *libraryDecorator) symbolFileForAbiCheck(ctx ModuleContext) *string {
+func (library *libraryDecorator) symbolFileForAbiCheck(ctx ModuleContext) android.OptionalPath {
 	if props := library.getHeaderAbiCheckerProperties(ctx); props.Symbol_file != nil {
-		return props.Symbol_file
+		return android.OptionalPathForModuleSrc(ctx, props.Symbol_file)
 	}
 	if ctx.Module().(*Module).IsLlndk() {
-		return library.Properties.Llndk.Symbol_file
+		return android.OptionalPathForModuleSrc(ctx, library.Properties.Llndk.Symbol_file)
 	}
-	if library.hasStubsVariants() && library.Properties.Stubs.Symbol_file != nil {
-		return library.Properties.Stubs.Symbol_file
+	if library.hasStubsVariants() && library.hasStubsSymbolFile() {
+		return android.OptionalPathForPath(library.stubsSymbolFile(ctx))
 	}
-	return nil
+	return android.OptionalPath{}
 }
 
 func (library *libraryDecorator) hasStubsVariants() bool {
-	// Just having stubs.symbol_file is enough to create a stub variant. In that case
-	// the stub for the future API level is created.
-	return library.Properties.Stubs.Symbol_file != nil ||
+	// Just having stubs.symbol_file or stubs.symbol_files is enough to create a stub
+	// variant. In that case the stub for the future API level is created.
+	return library.hasStubsSymbolFile() ||
 		len(library.Properties.Stubs.Versions) > 0
 }
 
+func (library *libraryDecorator) hasStubsSymbolFile() bool {
+	return library.Properties.Stubs.Symbol_file != nil ||
+		len(library.Properties.Stubs.Symbol_files) > 0
+}
+
+// checkStubsSymbolFiles reports an error if the symbol file properties of the stubs are
+// inconsistent, and returns whether they are valid.
+func (library *libraryDecorator) checkStubsSymbolFiles(ctx ModuleContext) bool {
+	symbolFile := String(library.Properties.Stubs.Symbol_file)
+	symbolFiles := library.Properties.Stubs.Symbol_files
+	if symbolFile != "" && len(symbolFiles) > 0 {
+		ctx.PropertyErrorf("symbol_files", "can't be used together with symbol_file")
+		return false
+	}
+	if symbolFile != "" && !strings.HasSuffix(symbolFile, ".map.txt") {
+		ctx.PropertyErrorf("symbol_file", "%q doesn't have .map.txt suffix", symbolFile)
+		return false
+	}
+	for _, f := range symbolFiles {
+		if !strings.HasSuffix(f, ".map.txt") {
+			ctx.PropertyErrorf("symbol_files", "%q doesn't have .map.txt suffix", f)
+			return false
+		}
+	}
+	return true
+}
+
+// stubsSymbolFile returns the symbol file the stubs are generated from. The fragments of
+// stubs.symbol_files are combined into a single symbol file the first time it is needed.
+func (library *libraryDecorator) stubsSymbolFile(ctx ModuleContext) android.Path {
+	symbolFiles := library.Properties.Stubs.Symbol_files
+	if len(symbolFiles) == 0 {
+		return android.PathForModuleSrc(ctx, String(library.Properties.Stubs.Symbol_file))
+	}
+	if !library.combinedSymbolFile.Valid() {
+		library.combinedSymbolFile = android.OptionalPathForPath(
+			concatSymbolFiles(ctx, android.PathsForModuleSrc(ctx, symbolFiles)))
+	}
+	return library.combinedSymbolFile.Path()
+}
+
 func (library *libraryDecorator) isStubsImplementationRequired() bool {
 	return BoolDefault(library.Properties.Stubs.Implementation_installable, true)
 }
@@ -1886,6 +3896,11 @@ func (library *libraryDecorator) stubsVersions(ctx android.BaseMutatorContext) [
 		return []string{android.FutureApiLevel.String()}
 	}
 
+	if m := ctx.Module().(*Module); (m.InVendor() && Bool(library.Properties.Stubs.Skip_on_vendor)) ||
+		(m.InProduct() && Bool(library.Properties.Stubs.Skip_on_product)) {
+		return nil
+	}
+
 	// Future API level is implicitly added if there isn't
 	return addCurrentVersionIfNotPresent(library.Properties.Stubs.Versions)
 }
@@ -1988,11 +4003,19 @@ var versioningMacroNamesListKey = android.NewOnceKey("versioningMacroNamesList")
 // that are not available for the version).
 //
 // This map is used to ensure that there aren't conflicts between these version macro names.
-func versioningMacroNamesList(config android.Config) *map[string]string {
+// It is a sync.Map as it is written concurrently by the linkerInit of every stubs variant,
+// and each key is only written once.
+func versioningMacroNamesList(config android.Config) *sync.Map {
 	return config.Once(versioningMacroNamesListKey, func() interface{} {
-		m := make(map[string]string)
-		return &m
-	}).(*map[string]string)
+		return &sync.Map{}
+	}).(*sync.Map)
+}
+
+// registerVersioningMacroName registers moduleName as the owner of macroName, unless another
+// module already registered it, and returns the owner of macroName.
+func registerVersioningMacroName(config android.Config, macroName, moduleName string) string {
+	owner, _ := versioningMacroNamesList(config).LoadOrStore(macroName, moduleName)
+	return owner.(string)
 }
 
 // alphanumeric and _ characters are preserved.
@@ -2032,6 +4055,29 @@ func NewLibrary(hod android.HostOrDeviceSupported) (*Module, *libraryDecorator)
 	return module, library
 }
 
+// checkReusedObjects returns true if a shared library that reuses the objects of its static variant
+// should verify that both variants are compiled with the same flags. Flags added to only one of the
+// variants after reuseStaticLibrary has connected them would otherwise be silently ignored by the
+// shared variant.
+func checkReusedObjects(ctx android.BaseModuleContext) bool {
+	return ctx.Config().IsEnvTrue("SOONG_CHECK_REUSED_OBJECTS")
+}
+
+// compileFlagsHash returns a hash of the flags that affect the objects compiled with flags.
+func compileFlagsHash(flags builderFlags) string {
+	h := sha256.New()
+	for _, f := range []string{
+		flags.globalCommonFlags, flags.globalAsFlags, flags.globalYasmFlags, flags.globalCFlags,
+		flags.globalConlyFlags, flags.globalCppFlags,
+		flags.localCommonFlags, flags.localAsFlags, flags.localYasmFlags, flags.localCFlags,
+		flags.localConlyFlags, flags.localCppFlags,
+		flags.systemIncludeFlags,
+	} {
+		fmt.Fprintln(h, f)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
 // connects a shared library to a static library in order to reuse its .o files to avoid
 // compiling source files twice.
 func reuseStaticLibrary(mctx android.BottomUpMutatorContext, static, shared *Module) {
@@ -2044,6 +4090,10 @@ func reuseStaticLibrary(mctx android.BottomUpMutatorContext, static, shared *Mod
 			len(sharedCompiler.SharedProperties.Shared.Cflags) == 0 &&
 			len(staticCompiler.StaticProperties.Static.Whole_static_libs) == 0 &&
 			len(sharedCompiler.SharedProperties.Shared.Whole_static_libs) == 0 &&
+			len(staticCompiler.StaticProperties.Static.Implementation_whole_static_libs) == 0 &&
+			len(sharedCompiler.SharedProperties.Shared.Implementation_whole_static_libs) == 0 &&
+			len(staticCompiler.StaticProperties.Static.Exclude_srcs) == 0 &&
+			len(sharedCompiler.SharedProperties.Shared.Exclude_srcs) == 0 &&
 			len(staticCompiler.StaticProperties.Static.Static_libs) == 0 &&
 			len(sharedCompiler.SharedProperties.Shared.Static_libs) == 0 &&
 			len(staticCompiler.StaticProperties.Static.Shared_libs) == 0 &&
@@ -2116,6 +4166,12 @@ func LinkageMutator(mctx android.BottomUpMutatorContext) {
 		isLLNDK := false
 		if m, ok := mctx.Module().(*Module); ok {
 			isLLNDK = m.IsLlndk()
+			if lib, ok := m.linker.(*libraryDecorator); ok {
+				lib.checkBuildableVariants(mctx)
+				if lib.testStaticVariant(mctx) {
+					lib.MutatedProperties.BuildStatic = true
+				}
+			}
 		}
 		buildStatic := library.BuildStaticVariant() && !isLLNDK
 		buildShared := library.BuildSharedVariant()
@@ -2171,6 +4227,63 @@ func normalizeVersions(ctx android.BaseModuleContext, versions []string) {
 	}
 }
 
+// checkFutureVersionIsLast reports an error if the future API level ("current") is listed in
+// versions but isn't the last entry. The "latest" alias created by createVersionVariations points
+// to the last version, which would then not be the newest stubs.
+func checkFutureVersionIsLast(ctx android.BaseModuleContext, versions []string) {
+	future := -1
+	for i, v := range versions {
+		ver, err := android.ApiLevelFromUser(ctx, v)
+		if err != nil {
+			// Reported by normalizeVersions.
+			return
+		}
+		if ver.IsCurrent() {
+			future = i
+		}
+	}
+	if future != -1 && future != len(versions)-1 {
+		ctx.PropertyErrorf("versions", "%q must be the last version: %v", versions[future], versions)
+	}
+}
+
+// checkFutureVersionIsNotDuplicated reports an error if versions lists both the future API level
+// ("current") and the API level it stands for on a finalized platform. Both would build stubs for
+// the same API surface, and it is ambiguous which of them the "latest" alias created by
+// createVersionVariations should point to. versions must already be normalized.
+func checkFutureVersionIsNotDuplicated(ctx android.BaseModuleContext, versions []string) {
+	if len(versions) < 2 || !ctx.Config().PlatformSdkFinal() {
+		return
+	}
+	last, previous := versions[len(versions)-1], versions[len(versions)-2]
+	if last == android.FutureApiLevel.String() && previous == ctx.Config().PlatformSdkVersion().String() {
+		ctx.PropertyErrorf("versions", "%q and %q both refer to API level %s of the finalized platform, list only one of them",
+			previous, last, previous)
+	}
+}
+
+// checkVersionsNotBelowMinSdkVersion reports an error if a stubs version is lower than the
+// min_sdk_version of the library, as the stubs would promise symbols at an API level that the
+// library doesn't support. versions must already be normalized.
+func checkVersionsNotBelowMinSdkVersion(ctx android.BaseModuleContext, module *Module, versions []string) {
+	minSdkVersion := module.MinSdkVersion()
+	if minSdkVersion == "" || minSdkVersion == "apex_inherit" {
+		return
+	}
+	minApiLevel, err := android.ApiLevelFromUser(ctx, minSdkVersion)
+	if err != nil {
+		// Reported when the min_sdk_version is used.
+		return
+	}
+	for _, v := range versions {
+		ver := android.ApiLevelOrPanic(ctx, v)
+		if ver.LessThan(minApiLevel) {
+			ctx.PropertyErrorf("versions", "version %q is lower than min_sdk_version %q", v, minSdkVersion)
+			return
+		}
+	}
+}
+
 func createVersionVariations(mctx android.BottomUpMutatorContext, versions []string) {
 	// "" is for the non-stubs (implementation) variant for system modules, or the LLNDK variant
 	// for LLNDK modules.
@@ -2262,10 +4375,22 @@ func setStubsVersions(mctx android.BottomUpMutatorContext, library libraryInterf
 	if len(versions) <= 0 {
 		return
 	}
+	checkFutureVersionIsLast(mctx, versions)
+	if mctx.Failed() {
+		return
+	}
 	normalizeVersions(mctx, versions)
 	if mctx.Failed() {
 		return
 	}
+	checkFutureVersionIsNotDuplicated(mctx, versions)
+	if mctx.Failed() {
+		return
+	}
+	checkVersionsNotBelowMinSdkVersion(mctx, module, versions)
+	if mctx.Failed() {
+		return
+	}
 	// Set the versions on the pre-mutated module so they can be read by any llndk modules that
 	// depend on the implementation library and haven't been mutated yet.
 	library.setAllStubsVersions(versions)
@@ -2326,3 +4451,79 @@ func maybeInjectBoringSSLHash(ctx android.ModuleContext, outputFile android.Modu
 
 	return outputFile
 }
+
+var allowedDependentsPartitions = []string{"platform", "vendor", "product", "system_ext"}
+
+// checkDlopenOnlyMutator reports an error if the module links against a dlopen_only library.
+func checkDlopenOnlyMutator(ctx android.BottomUpMutatorContext) {
+	if _, ok := ctx.Module().(*Module); !ok {
+		return
+	}
+	ctx.VisitDirectDeps(func(dep android.Module) {
+		if !IsSharedDepTag(ctx.OtherModuleDependencyTag(dep)) {
+			return
+		}
+		ccDep, ok := dep.(*Module)
+		if !ok || ctx.OtherModuleName(dep) == ctx.ModuleName() {
+			return
+		}
+		library, ok := ccDep.linker.(*libraryDecorator)
+		if !ok {
+			return
+		}
+		if Bool(library.Properties.Dlopen_only) {
+			ctx.ModuleErrorf("links against %q, which is dlopen_only; use runtime_libs instead of shared_libs",
+				ctx.OtherModuleName(dep))
+		}
+	})
+}
+
+// dependentPartition returns the partition of m as named in allowed_dependents_partitions.
+func dependentPartition(m *Module) string {
+	switch {
+	case m.InVendor():
+		return "vendor"
+	case m.InProduct():
+		return "product"
+	case m.SystemExtSpecific():
+		return "system_ext"
+	default:
+		return "platform"
+	}
+}
+
+// checkAllowedDependentsPartitionsMutator reports an error if the module depends on a library
+// whose allowed_dependents_partitions doesn't list the partition of the module.
+func checkAllowedDependentsPartitionsMutator(ctx android.BottomUpMutatorContext) {
+	c, ok := ctx.Module().(*Module)
+	if !ok {
+		return
+	}
+	if library, ok := c.linker.(*libraryDecorator); ok {
+		for _, p := range library.Properties.Allowed_dependents_partitions {
+			if !inList(p, allowedDependentsPartitions) {
+				ctx.PropertyErrorf("allowed_dependents_partitions", "%q is not one of %q",
+					p, allowedDependentsPartitions)
+			}
+		}
+	}
+	partition := dependentPartition(c)
+	ctx.VisitDirectDeps(func(dep android.Module) {
+		if _, ok := ctx.OtherModuleDependencyTag(dep).(libraryDependencyTag); !ok {
+			return
+		}
+		ccDep, ok := dep.(*Module)
+		if !ok || ctx.OtherModuleName(dep) == ctx.ModuleName() {
+			return
+		}
+		library, ok := ccDep.linker.(*libraryDecorator)
+		if !ok {
+			return
+		}
+		allowed := library.Properties.Allowed_dependents_partitions
+		if len(allowed) > 0 && !inList(partition, allowed) {
+			ctx.ModuleErrorf("depends on %q, which may only be depended on from %q, but this "+
+				"module is in the %s partition", ctx.OtherModuleName(dep), allowed, partition)
+		}
+	})
+}
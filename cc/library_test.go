@@ -15,10 +15,19 @@
 package cc
 
 import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
 	"testing"
 
 	"android/soong/android"
+	"android/soong/cc/config"
+
+	"github.com/google/blueprint/proptools"
 )
 
 func TestLibraryReuse(t *testing.T) {
@@ -214,6 +223,35 @@ func TestStubsVersions(t *testing.T) {
 	}
 }
 
+func TestStubsVersions_SkipOnVendor(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			vendor_available: true,
+			product_available: true,
+			stubs: {
+				versions: ["29"],
+				skip_on_vendor: true,
+			},
+		}`)
+
+	variants := result.ModuleVariantsForTests("libfoo")
+	for _, tc := range []struct {
+		variant  string
+		expected bool
+	}{
+		{"android_arm64_armv8-a_shared_29", true},
+		{"android_product.29_arm64_armv8-a_shared_29", true},
+		{"android_vendor.29_arm64_armv8-a_shared", true},
+		{"android_vendor.29_arm64_armv8-a_shared_29", false},
+		{"android_vendor.29_arm64_armv8-a_shared_current", false},
+	} {
+		android.AssertBoolEquals(t, "variant "+tc.variant, tc.expected, android.InList(tc.variant, variants))
+	}
+}
+
 func TestStubsVersions_NotSorted(t *testing.T) {
 	t.Parallel()
 	bp := `
@@ -221,7 +259,7 @@ func TestStubsVersions_NotSorted(t *testing.T) {
 			name: "libfoo",
 			srcs: ["foo.c"],
 			stubs: {
-				versions: ["29", "current", "R"],
+				versions: ["29", "R", "28", "current"],
 			},
 		}
 	`
@@ -230,6 +268,79 @@ func TestStubsVersions_NotSorted(t *testing.T) {
 	testCcErrorWithConfig(t, `"libfoo" .*: versions: not sorted`, config)
 }
 
+func TestStubsVersions_FutureNotLast(t *testing.T) {
+	t.Parallel()
+	bp := `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			stubs: {
+				versions: ["29", "current", "R"],
+			},
+		}
+	`
+	config := TestConfig(t.TempDir(), android.Android, nil, bp, nil)
+	config.TestProductVariables.Platform_version_active_codenames = []string{"R"}
+	testCcErrorWithConfig(t, `"libfoo" .*: versions: "current" must be the last version`, config)
+}
+
+func TestStubsVersions_BelowMinSdkVersion(t *testing.T) {
+	t.Parallel()
+	testCcError(t, `"libfoo" .*: versions: version "28" is lower than min_sdk_version "29"`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			min_sdk_version: "29",
+			stubs: {
+				versions: ["28", "29", "current"],
+			},
+		}
+	`)
+
+	testCc(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			min_sdk_version: "29",
+			stubs: {
+				versions: ["29", "30", "current"],
+			},
+		}
+	`)
+}
+
+func TestStubsVersions_FutureVersionDuplicated(t *testing.T) {
+	t.Parallel()
+	bp := `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			stubs: {
+				versions: ["33", "34", "current"],
+			},
+		}
+	`
+
+	platformSdk := func(version int, final bool) android.FixturePreparer {
+		return android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.Platform_sdk_version = proptools.IntPtr(version)
+			variables.Platform_sdk_final = proptools.BoolPtr(final)
+		})
+	}
+
+	android.GroupFixturePreparers(prepareForCcTest, platformSdk(34, true)).
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`"libfoo" .*: versions: "34" and "current" both refer to API level 34 of the finalized platform, list only one of them`)).
+		RunTestWithBp(t, bp)
+
+	// On a platform that isn't finalized yet "current" is a different API level than "34".
+	result := android.GroupFixturePreparers(prepareForCcTest, platformSdk(34, false)).RunTestWithBp(t, bp)
+	variants := result.ModuleVariantsForTests("libfoo")
+	for _, variant := range []string{"android_arm64_armv8-a_shared_34", "android_arm64_armv8-a_shared_current"} {
+		android.AssertStringListContains(t, "stubs variants", variants, variant)
+	}
+}
+
 func TestStubsVersions_ParseError(t *testing.T) {
 	t.Parallel()
 	bp := `
@@ -245,6 +356,141 @@ func TestStubsVersions_ParseError(t *testing.T) {
 	testCcError(t, `"libfoo" .*: versions: "X" could not be parsed as an integer and is not a recognized codename`, bp)
 }
 
+func TestLibraryNoBuildableVariant(t *testing.T) {
+	t.Parallel()
+	testCcError(t, `"libfoo" .*: static and shared variants are both disabled`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			static: {
+				enabled: false,
+			},
+			shared: {
+				enabled: false,
+			},
+		}`)
+}
+
+func TestLibraryTestStaticVariant(t *testing.T) {
+	t.Parallel()
+	bp := `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			test_static_variant: true,
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["bar.c"],
+		}`
+
+	result := prepareForCcTest.RunTestWithBp(t, bp)
+	android.AssertStringListDoesNotContain(t, "libfoo variants without TestStaticVariants",
+		result.ModuleVariantsForTests("libfoo"), "android_arm64_armv8-a_static")
+
+	result = android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.TestStaticVariants = proptools.BoolPtr(true)
+		}),
+	).RunTestWithBp(t, bp)
+	libfooVariants := result.ModuleVariantsForTests("libfoo")
+	android.AssertStringListContains(t, "libfoo variants", libfooVariants, "android_arm64_armv8-a_static")
+	android.AssertStringListContains(t, "libfoo variants", libfooVariants, "android_arm64_armv8-a_shared")
+	android.AssertStringListDoesNotContain(t, "libbar variants",
+		result.ModuleVariantsForTests("libbar"), "android_arm64_armv8-a_static")
+	result.ModuleForTests("libfoo", "android_arm64_armv8-a_static").Output("libfoo.a")
+}
+
+func TestLibraryVariantExportConsistency(t *testing.T) {
+	t.Parallel()
+	bp := `
+		cc_library_static {
+			name: "libbar",
+			srcs: ["bar.c"],
+			export_include_dirs: ["bar_include"],
+		}
+
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_include_dirs: ["include"],
+			check_variant_export_consistency: true,
+			static: {
+				static_libs: ["libbar"],
+				export_static_lib_headers: ["libbar"],
+			},
+		}
+	`
+
+	testCcError(t, `"libfoo" .*: check_variant_export_consistency: include dirs exported by the shared and static variants differ: only in shared \[\], only in static \["bar_include"\]`, bp)
+}
+
+func TestLibraryVariantReexportDivergence(t *testing.T) {
+	t.Parallel()
+	bp := `
+		cc_library_headers {
+			name: "libbar_headers",
+			export_include_dirs: ["bar_include"],
+		}
+
+		cc_library_static {
+			name: "libbaz",
+			srcs: ["baz.c"],
+			header_libs: ["libbar_headers"],
+			export_header_lib_headers: ["libbar_headers"],
+		}
+
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			static: {
+				static_libs: ["libbaz"],
+				export_static_lib_headers: ["libbaz"],
+			},
+		}
+
+		cc_library {
+			name: "libqux",
+			srcs: ["qux.c"],
+			allow_variant_reexport_divergence: true,
+			static: {
+				static_libs: ["libbaz"],
+				export_static_lib_headers: ["libbaz"],
+			},
+		}
+
+		cc_library {
+			name: "libsame",
+			srcs: ["same.c"],
+			static: {
+				static_libs: ["libbaz"],
+				export_static_lib_headers: ["libbaz"],
+			},
+			shared: {
+				static_libs: ["libbaz"],
+				export_static_lib_headers: ["libbaz"],
+			},
+		}`
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, bp)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	warning := libfoo.Output("variant_reexport_divergence.timestamp")
+	android.AssertStringDoesContain(t, "warning", warning.Args["message"],
+		"warning: libfoo: the static and shared variants reexport the headers of different libraries")
+	android.AssertStringDoesContain(t, "warning", warning.Args["message"],
+		`export_static_lib_headers only in shared [], only in static ["libbaz"]`)
+	android.AssertStringListContains(t, "link validations",
+		libfoo.Rule("ld").Validations.Strings(), warning.Output.String())
+
+	for _, name := range []string{"libqux", "libsame"} {
+		module := result.ModuleForTests(name, "android_arm64_armv8-a_shared")
+		android.AssertBoolEquals(t, name+" warns", false,
+			module.MaybeOutput("variant_reexport_divergence.timestamp").Rule != nil)
+	}
+}
+
 func TestLibraryVersionScript(t *testing.T) {
 	t.Parallel()
 	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
@@ -281,6 +527,3160 @@ func TestLibraryDynamicList(t *testing.T) {
 
 }
 
+func TestLibraryHeaderAbiCheckerArchVariantDiffFlags(t *testing.T) {
+	t.Parallel()
+	result := android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithCc,
+		android.FixtureAddTextFile("ref_dumps/arm64/source-based/libfoo.so.lsdump", ""),
+		android.FixtureAddTextFile("ref_dumps/arm_arm64/source-based/libfoo.so.lsdump", ""),
+	).RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			header_abi_checker: {
+				enabled: true,
+				ref_dump_dirs: ["ref_dumps"],
+				diff_flags: ["-common-flag"],
+			},
+			arch: {
+				arm: {
+					header_abi_checker: {
+						diff_flags: ["-arm-only-flag"],
+					},
+				},
+			},
+		}`)
+
+	libfooArm := result.ModuleForTests("libfoo", "android_arm_armv7-a-neon_shared").Output("libfoo.so.opt0.abidiff")
+	android.AssertStringDoesContain(t, "missing common diff flag for arm",
+		libfooArm.Args["extraFlags"], "-common-flag")
+	android.AssertStringDoesContain(t, "missing arm-specific diff flag for arm",
+		libfooArm.Args["extraFlags"], "-arm-only-flag")
+
+	libfooArm64 := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Output("libfoo.so.opt0.abidiff")
+	android.AssertStringDoesContain(t, "missing common diff flag for arm64",
+		libfooArm64.Args["extraFlags"], "-common-flag")
+	android.AssertStringDoesNotContain(t, "unexpected arm-specific diff flag for arm64",
+		libfooArm64.Args["extraFlags"], "-arm-only-flag")
+}
+
+func TestLibraryHeaderAbiCheckerRefDumpDirsPerProduct(t *testing.T) {
+	t.Parallel()
+	bp := `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			header_abi_checker: {
+				enabled: true,
+				ref_dump_dirs: ["ref_dumps/common"],
+				ref_dump_dirs_per_product: [
+					{
+						product: "fooproduct",
+						dirs: ["ref_dumps/fooproduct"],
+					},
+				],
+			},
+		}`
+	prepare := android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithCc,
+		android.FixtureAddTextFile("ref_dumps/common/arm64/source-based/libfoo.so.lsdump", ""),
+		android.FixtureAddTextFile("ref_dumps/fooproduct/arm64/source-based/libfoo.so.lsdump", ""),
+	)
+
+	for _, tc := range []struct {
+		product string
+		refDump string
+	}{
+		{"fooproduct", "ref_dumps/fooproduct/arm64/source-based/libfoo.so.lsdump"},
+		{"barproduct", "ref_dumps/common/arm64/source-based/libfoo.so.lsdump"},
+	} {
+		t.Run(tc.product, func(t *testing.T) {
+			result := android.GroupFixturePreparers(
+				prepare,
+				android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+					variables.DeviceProduct = proptools.StringPtr(tc.product)
+				}),
+			).RunTestWithBp(t, bp)
+
+			abiDiff := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Output("libfoo.so.opt0.abidiff")
+			android.AssertStringEquals(t, "reference dump", tc.refDump, abiDiff.Implicit.String())
+		})
+	}
+}
+
+func TestLibraryHeaderAbiCheckerPreviousVersion(t *testing.T) {
+	t.Parallel()
+	result := android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithCc,
+		android.FixtureAddTextFile("prebuilts/abi-dumps/platform/28/64/arm64/source-based/libfoo.so.lsdump", ""),
+		android.FixtureAddTextFile("prebuilts/abi-dumps/platform/29/64/arm64/source-based/libfoo.so.lsdump", ""),
+	).RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			header_abi_checker: {
+				enabled: true,
+				previous_version: "28",
+			},
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	abiDiff := libfoo.Output("libfoo.so.28.abidiff")
+	android.AssertStringEquals(t, "reference dump",
+		"prebuilts/abi-dumps/platform/28/64/arm64/source-based/libfoo.so.lsdump", abiDiff.Implicit.String())
+	android.AssertStringDoesContain(t, "target version",
+		abiDiff.Args["extraFlags"], "-target-version 30")
+	if libfoo.MaybeOutput("libfoo.so.29.abidiff").Rule != nil {
+		t.Errorf("unexpected diff against the default previous version")
+	}
+	android.AssertBoolEquals(t, "missing previous ABI dump warning", false,
+		libfoo.MaybeOutput("missing_previous_abi_dump.timestamp").Rule != nil)
+
+	// There is no reference dump for the secondary arch, which is reported.
+	libfooSecondary := result.ModuleForTests("libfoo", "android_arm_armv7-a-neon_shared")
+	android.AssertStringDoesContain(t, "missing previous ABI dump warning",
+		libfooSecondary.Output("missing_previous_abi_dump.timestamp").Args["message"],
+		"no reference ABI dump for libfoo.so in prebuilts/abi-dumps/platform/28/64")
+
+	testCcError(t, `"libfoo" .*: header_abi_checker.previous_version: "S" is not a valid API level`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			header_abi_checker: {
+				enabled: true,
+				previous_version: "S",
+			},
+		}`)
+}
+
+func TestLibraryHeaderAbiCheckerBinderBitnessIndependent(t *testing.T) {
+	t.Parallel()
+	result := android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithCc,
+		android.FixtureAddTextFile("prebuilts/abi-dumps/platform/28/arm64/source-based/libfoo.so.lsdump", ""),
+		android.FixtureAddTextFile("prebuilts/abi-dumps/platform/28/64/arm64/source-based/libbar.so.lsdump", ""),
+	).RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			header_abi_checker: {
+				enabled: true,
+				previous_version: "28",
+				binder_bitness_independent: true,
+			},
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["foo.c"],
+			header_abi_checker: {
+				enabled: true,
+				previous_version: "28",
+			},
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	android.AssertStringEquals(t, "reference dump without binder bitness",
+		"prebuilts/abi-dumps/platform/28/arm64/source-based/libfoo.so.lsdump",
+		libfoo.Output("libfoo.so.28.abidiff").Implicit.String())
+
+	libbar := result.ModuleForTests("libbar", "android_arm64_armv8-a_shared")
+	android.AssertStringEquals(t, "reference dump with binder bitness",
+		"prebuilts/abi-dumps/platform/28/64/arm64/source-based/libbar.so.lsdump",
+		libbar.Output("libbar.so.28.abidiff").Implicit.String())
+}
+
+func TestLibraryHeaderAbiCheckerPrimaryArchOnly(t *testing.T) {
+	t.Parallel()
+	result := android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithCc,
+		android.FixtureAddTextFile("prebuilts/abi-dumps/platform/28/64/arm64/source-based/libfoo.so.lsdump", ""),
+		android.FixtureAddTextFile("prebuilts/abi-dumps/platform/28/64/arm/source-based/libfoo.so.lsdump", ""),
+		android.FixtureAddTextFile("prebuilts/abi-dumps/platform/28/64/arm64/source-based/libbar.so.lsdump", ""),
+		android.FixtureAddTextFile("prebuilts/abi-dumps/platform/28/64/arm/source-based/libbar.so.lsdump", ""),
+		android.FixtureAddTextFile("prebuilts/abi-dumps/platform/28/64/arm/source-based/libbaz.so.lsdump", ""),
+	).RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			header_abi_checker: {
+				enabled: true,
+				previous_version: "28",
+				primary_arch_only: true,
+			},
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["foo.c"],
+			header_abi_checker: {
+				enabled: true,
+				previous_version: "28",
+			},
+		}
+
+		cc_library_shared {
+			name: "libbaz",
+			srcs: ["foo.c"],
+			compile_multilib: "32",
+			header_abi_checker: {
+				enabled: true,
+				previous_version: "28",
+				primary_arch_only: true,
+			},
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	libfoo.Output("libfoo.so.lsdump")
+	libfoo.Output("libfoo.so.28.abidiff")
+
+	libfooSecondary := result.ModuleForTests("libfoo", "android_arm_armv7-a-neon_shared")
+	android.AssertBoolEquals(t, "secondary arch dump", false,
+		libfooSecondary.MaybeOutput("libfoo.so.lsdump").Rule != nil)
+	android.AssertBoolEquals(t, "secondary arch diff", false,
+		libfooSecondary.MaybeOutput("libfoo.so.28.abidiff").Rule != nil)
+
+	libbarSecondary := result.ModuleForTests("libbar", "android_arm_armv7-a-neon_shared")
+	libbarSecondary.Output("libbar.so.28.abidiff")
+
+	// libbaz isn't built for the primary arch, so its only arch keeps its ABI checks.
+	libbaz := result.ModuleForTests("libbaz", "android_arm_armv7-a-neon_shared")
+	libbaz.Output("libbaz.so.lsdump")
+	libbaz.Output("libbaz.so.28.abidiff")
+}
+
+func TestLibraryHeaderAbiCheckerDumpOnly(t *testing.T) {
+	t.Parallel()
+	result := android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithCc,
+		android.FixtureAddTextFile("prebuilts/abi-dumps/platform/28/64/arm64/source-based/libfoo.so.lsdump", ""),
+		android.FixtureAddTextFile("prebuilts/abi-dumps/platform/current/64/arm64/source-based/libfoo.so.lsdump", ""),
+	).RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			header_abi_checker: {
+				dump_only: true,
+				previous_version: "28",
+			},
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	lsdump := libfoo.Output("libfoo.so.lsdump")
+	info := result.ModuleProvider(libfoo.Module(), SourceAbiDumpInfoProvider).(SourceAbiDumpInfo)
+	android.AssertPathRelativeToTopEquals(t, "linked dump",
+		android.PathRelativeToTop(lsdump.Output), info.LinkedDump)
+
+	for _, output := range libfoo.AllOutputs() {
+		if strings.HasSuffix(output, ".abidiff") {
+			t.Errorf("unexpected ABI diff %q", output)
+		}
+	}
+}
+
+func TestLibraryHeaderAbiCheckerEmitJsonSummary(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			header_abi_checker: {
+				dump_only: true,
+				emit_json_summary: true,
+			},
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["foo.c"],
+			header_abi_checker: {
+				dump_only: true,
+			},
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	lsdump := libfoo.Output("libfoo.so.lsdump")
+	summary := libfoo.Output("libfoo.so.lsdump.summary.json")
+	android.AssertStringDoesContain(t, "summary command", summary.RuleParams.Command, "lsdump_to_json_summary")
+	android.AssertPathsRelativeToTopEquals(t, "summary input",
+		[]string{android.PathRelativeToTop(lsdump.Output)}, summary.Inputs)
+	outputs, err := libfoo.Module().(*Module).OutputFiles(".abi_summary")
+	android.AssertDeepEquals(t, "abi summary output error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "abi summary outputs",
+		[]string{android.PathRelativeToTop(summary.Output)}, outputs)
+
+	libbar := result.ModuleForTests("libbar", "android_arm64_armv8-a_shared")
+	android.AssertBoolEquals(t, "libbar has a summary", false,
+		libbar.MaybeOutput("libbar.so.lsdump.summary.json").Rule != nil)
+}
+
+func TestLibraryHeaderAbiCheckerIncludePaths(t *testing.T) {
+	t.Parallel()
+	result := android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithCc,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.HeaderAbiCheckerIncludePaths = []string{"abi"}
+		}),
+		android.FixtureAddTextFile("abi/Android.bp", `
+			cc_library_shared {
+				name: "libabi",
+				srcs: ["foo.c"],
+			}
+
+			cc_library_shared {
+				name: "libabi_disabled",
+				srcs: ["foo.c"],
+				header_abi_checker: {
+					enabled: false,
+				},
+			}`),
+		android.FixtureAddTextFile("other/Android.bp", `
+			cc_library_shared {
+				name: "libother",
+				srcs: ["foo.c"],
+			}`),
+	).RunTest(t)
+
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"libabi", true},
+		{"libabi_disabled", false},
+		{"libother", false},
+	} {
+		module := result.ModuleForTests(tc.name, "android_arm64_armv8-a_shared")
+		lsdump := module.MaybeOutput(tc.name + ".so.lsdump")
+		android.AssertBoolEquals(t, tc.name+" has lsdump", tc.enabled, lsdump.Rule != nil)
+	}
+}
+
+func TestLibrarySoname(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libnew",
+			srcs: ["foo.c"],
+			soname: "libold",
+		}
+
+		cc_library_shared {
+			name: "libnew_suffixed",
+			srcs: ["foo.c"],
+			soname: "libold.so",
+		}
+
+		cc_library_shared {
+			name: "libplain",
+			srcs: ["foo.c"],
+		}`)
+
+	for _, tc := range []struct {
+		name   string
+		soname string
+	}{
+		{"libnew", "libold.so"},
+		{"libnew_suffixed", "libold.so"},
+		{"libplain", "libplain.so"},
+	} {
+		module := result.ModuleForTests(tc.name, "android_arm64_armv8-a_shared")
+		ld := module.Rule("ld")
+		android.AssertStringListContains(t, tc.name+" soname flag",
+			strings.Fields(ld.Args["ldFlags"]), "-Wl,-soname,"+tc.soname)
+		android.AssertStringEquals(t, tc.name+" output", tc.name+".so", ld.Output.Base())
+		toc := module.Output(tc.name + ".so.toc")
+		android.AssertStringEquals(t, tc.name+" toc input", tc.name+".so", toc.Input.Base())
+	}
+
+	testCcError(t, `"libnew" .*: soname: "lib/libold.so" must not contain a path separator`, `
+		cc_library_shared {
+			name: "libnew",
+			srcs: ["foo.c"],
+			soname: "lib/libold.so",
+		}`)
+}
+
+func TestLibraryCheckReusedObjects(t *testing.T) {
+	t.Parallel()
+	bp := `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+		}`
+
+	prepare := android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithCc,
+		android.FixtureMergeEnv(map[string]string{"SOONG_CHECK_REUSED_OBJECTS": "true"}),
+	)
+	// Both variants are compiled with the same flags, so the check passes.
+	result := prepare.RunTestWithBp(t, bp)
+	static := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static").Module()
+	staticInfo := result.ModuleProvider(static, StaticLibraryInfoProvider).(StaticLibraryInfo)
+	android.AssertBoolEquals(t, "static variant records its flags hash", true, staticInfo.ReuseObjectsFlagsHash != "")
+
+	// Simulate a mutator that adds a flag to only the shared variant after the objects of the
+	// static variant were chosen for reuse.
+	injectSharedCflag := android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+		ctx.PostDepsMutators(func(ctx android.RegisterMutatorsContext) {
+			ctx.BottomUp("inject_shared_cflag", func(mctx android.BottomUpMutatorContext) {
+				if m, ok := mctx.Module().(*Module); ok && m.Name() == "libfoo" {
+					if library, ok := m.compiler.(*libraryDecorator); ok && library.shared() {
+						library.baseCompiler.Properties.Cflags = append(library.baseCompiler.Properties.Cflags, "-DINJECTED")
+					}
+				}
+			})
+		})
+	})
+	android.GroupFixturePreparers(prepare, injectSharedCflag).
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`module "libfoo" variant "android_arm64_armv8-a_shared": reuses the objects of the static variant, but is compiled with different flags`)).
+		RunTestWithBp(t, bp)
+
+	// The check is off by default.
+	android.GroupFixturePreparers(PrepareForIntegrationTestWithCc, injectSharedCflag).RunTestWithBp(t, bp)
+}
+
+func TestLibraryGenerateHeadersZip(t *testing.T) {
+	t.Parallel()
+	result := android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithCc,
+		android.FixtureAddTextFile("include/foo/foo.h", ""),
+		android.FixtureAddTextFile("include/foo/foo.c", ""),
+	).RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_include_dirs: ["include"],
+			generate_headers_zip: true,
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	headersZip := libfoo.Output("headers.zip")
+	android.AssertStringDoesContain(t, "headers zip command",
+		headersZip.RuleParams.Command, "-C include -f include/foo/foo.h")
+	android.AssertStringDoesNotContain(t, "headers zip command",
+		headersZip.RuleParams.Command, "foo.c")
+
+	outputs, err := libfoo.Module().(*Module).OutputFiles(".headers_zip")
+	android.AssertDeepEquals(t, "headers zip output error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "headers zip output",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/headers.zip"}, outputs)
+}
+
+func TestLibraryPkgConfig(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			host_supported: true,
+			srcs: ["foo.c"],
+			export_include_dirs: ["include"],
+			pkg_config: {
+				name: "foo",
+				description: "The foo library",
+				version: "1.2",
+				libs: ["-lpthread"],
+			},
+		}`)
+
+	hostVariant := result.Config.BuildOSTarget.String()
+	for _, variant := range []string{hostVariant + "_shared", hostVariant + "_static"} {
+		libfoo := result.ModuleForTests("libfoo", variant)
+		content := android.ContentFromFileRuleForTests(t, result.TestContext, libfoo.Output("foo.pc"))
+		android.AssertStringDoesContain(t, variant+" name", content, "Name: foo\n")
+		android.AssertStringDoesContain(t, variant+" version", content, "Version: 1.2\n")
+		android.AssertStringDoesContain(t, variant+" cflags", content, "Cflags: -Iinclude")
+		android.AssertStringDoesContain(t, variant+" libs", content, " -lfoo -lpthread\n")
+
+		outputs, err := libfoo.Module().(*Module).OutputFiles(".pc")
+		android.AssertDeepEquals(t, variant+" pkg-config output error", nil, err)
+		android.AssertIntEquals(t, variant+" pkg-config outputs", 1, len(outputs))
+	}
+
+	// Device variants don't get a pkg-config file.
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	if libfoo.MaybeOutput("foo.pc").Rule != nil {
+		t.Errorf("unexpected pkg-config file for the device variant")
+	}
+}
+
+func TestLibraryBuildMetadataSection(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			build_metadata_section: {
+				name: ".note.build_metadata",
+				entries: [
+					"module=$(module)",
+					"git_hash=abc123",
+				],
+			},
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	metadata := libfoo.Output("build_metadata/note.build_metadata.txt")
+	android.AssertStringEquals(t, "metadata content", "module=libfoo\ngit_hash=abc123\n",
+		android.ContentFromFileRuleForTests(t, result.TestContext, metadata))
+
+	addSection := libfoo.Rule("addSection")
+	android.AssertStringEquals(t, "section", ".note.build_metadata", addSection.Args["section"])
+	android.AssertPathRelativeToTopEquals(t, "section file",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/build_metadata/note.build_metadata.txt",
+		addSection.Implicit)
+
+	// The section is added to the linked library before it is stripped.
+	ld := libfoo.Rule("ld")
+	android.AssertPathRelativeToTopEquals(t, "add section input",
+		android.PathRelativeToTop(ld.Output), addSection.Input)
+	strip := libfoo.Rule("strip")
+	android.AssertPathRelativeToTopEquals(t, "strip input",
+		android.PathRelativeToTop(addSection.Output), strip.Input)
+
+	testCcError(t, `"libfoo" .*: build_metadata_section.entries: "version=\$\(version\)": unknown variable \$\(version\)`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			build_metadata_section: {
+				name: ".note.build_metadata",
+				entries: ["version=$(version)"],
+			},
+		}`)
+}
+
+func TestLibraryLicenseSection(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			license_section: {
+				name: ".note.android.license",
+				file: "NOTICE_ID",
+			},
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	addSection := libfoo.Rule("addSection")
+	android.AssertStringEquals(t, "section", ".note.android.license", addSection.Args["section"])
+	android.AssertPathRelativeToTopEquals(t, "section file", "NOTICE_ID", addSection.Implicit)
+
+	// The section is added to the stripped library, which is the output of the module.
+	strip := libfoo.Rule("strip")
+	android.AssertPathRelativeToTopEquals(t, "add section input",
+		android.PathRelativeToTop(strip.Output), addSection.Input)
+	output := android.PathRelativeToTop(libfoo.Module().(*Module).OutputFile().Path())
+	android.AssertPathRelativeToTopEquals(t, "add section output", output, addSection.Output)
+
+	check := libfoo.Rule("checkSection")
+	android.AssertStringEquals(t, "checked section", ".note.android.license", check.Args["section"])
+	android.AssertPathRelativeToTopEquals(t, "checked library", output, check.Input)
+	android.AssertStringListContains(t, "link validations",
+		libfoo.Rule("ld").Validations.Strings(), check.Output.String())
+
+	testCcError(t, `"libfoo" .*: license_section.file: must be set`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			license_section: {
+				name: ".note.android.license",
+			},
+		}`)
+}
+
+func TestLibraryUseLinker(t *testing.T) {
+	t.Parallel()
+	prepareForUseLinker := android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithCc,
+		android.FixtureMergeEnv(map[string]string{"LLVM_PREBUILTS_VERSION": "clang-test"}),
+		android.FixtureAddTextFile("prebuilts/clang/host/linux-x86/clang-test/bin/ld.gold", ""),
+		android.FixtureAddTextFile("prebuilts/clang/host/linux-x86/clang-test/bin/ld.bfd", ""),
+		android.FixtureAddTextFile("prebuilts/clang/host/linux-x86/clang-test/bin/ld.mold", ""),
+	)
+	for _, linker := range []string{"lld", "gold", "bfd", "mold"} {
+		result := prepareForUseLinker.RunTestWithBp(t, `
+			cc_library_shared {
+				name: "libfoo",
+				srcs: ["foo.c"],
+				use_linker: "`+linker+`",
+			}`)
+
+		ld := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Rule("ld")
+		// Clang uses the last -fuse-ld flag, which must override the default from the global flags.
+		var fuseLd string
+		for _, flag := range strings.Fields(ld.Args["ldFlags"]) {
+			if strings.HasPrefix(flag, "-fuse-ld=") {
+				fuseLd = flag
+			}
+		}
+		android.AssertStringEquals(t, "last -fuse-ld flag", "-fuse-ld="+linker, fuseLd)
+		if linker != "lld" {
+			android.AssertStringListContains(t, "ld implicits", ld.Implicits.Strings(),
+				"prebuilts/clang/host/linux-x86/clang-test/bin/ld."+linker)
+		}
+	}
+
+	testCcError(t, `"libfoo" .*: use_linker: "gold" is not available in the toolchain`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			use_linker: "gold",
+		}`)
+
+	testCcError(t, `"libfoo" .*: use_linker: invalid value "ld64"`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			use_linker: "ld64",
+		}`)
+}
+
+func TestLibraryGenerateDwp(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_static {
+			name: "libwhole",
+			srcs: ["whole.c"],
+			cflags: ["-gsplit-dwarf"],
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c", "bar.cpp", "asm.S"],
+			cflags: ["-gsplit-dwarf"],
+			whole_static_libs: ["libwhole"],
+			generate_dwp: true,
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	dwp := libfoo.Output("libfoo.so.dwp")
+	android.AssertPathsRelativeToTopEquals(t, "dwo inputs", []string{
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/obj/foo.dwo",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/obj/bar.dwo",
+		"out/soong/.intermediates/libwhole/android_arm64_armv8-a_static/obj/whole.dwo",
+	}, dwp.Inputs)
+
+	// The .dwo files are declared as outputs of the compilations.
+	compile := libfoo.Output("obj/foo.o")
+	android.AssertPathsRelativeToTopEquals(t, "compile implicit outputs",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/obj/foo.dwo"},
+		compile.ImplicitOutputs.Paths())
+
+	outputs, err := libfoo.Module().(*Module).OutputFiles(".dwp")
+	android.AssertDeepEquals(t, "dwp output error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "dwp output",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.dwp"}, outputs)
+
+	testCcError(t, `"libfoo" .*: generate_dwp: requires split DWARF`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			generate_dwp: true,
+		}`)
+}
+
+func TestLibraryAllowMultipleDefinition(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "liblegacy",
+			srcs: ["foo.c"],
+			allow_multiple_definition: true,
+		}
+
+		cc_library_shared {
+			name: "libclient",
+			srcs: ["foo.c"],
+			shared_libs: ["liblegacy"],
+		}`)
+
+	for _, tc := range []struct {
+		name    string
+		allowed bool
+	}{
+		{"liblegacy", true},
+		{"libclient", false},
+	} {
+		ld := result.ModuleForTests(tc.name, "android_arm64_armv8-a_shared").Rule("ld")
+		ldFlags := strings.Fields(ld.Args["ldFlags"])
+		android.AssertBoolEquals(t, tc.name+" allows multiple definitions", tc.allowed,
+			android.InList("-Wl,--allow-multiple-definition", ldFlags))
+	}
+}
+
+func TestLibraryCheckNoUndefined(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			ldflags: ["-Wl,-z,undefs"],
+			check_no_undefined: true,
+			stubs: {
+				versions: ["29"],
+			},
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["bar.c"],
+			ldflags: ["-Wl,-z,undefs"],
+		}`)
+
+	// The linker rejects unresolved symbols if --no-undefined comes after the -z undefs of the
+	// module's ldflags.
+	rejectsUndefined := func(ldFlags string) bool {
+		fields := strings.Fields(ldFlags)
+		return lastIndexOfFlag("-Wl,--no-undefined", fields) > lastIndexOfFlag("-Wl,-z,undefs", fields)
+	}
+
+	ld := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Rule("ld")
+	android.AssertBoolEquals(t, "libfoo rejects undefined symbols", true, rejectsUndefined(ld.Args["ldFlags"]))
+
+	barLd := result.ModuleForTests("libbar", "android_arm64_armv8-a_shared").Rule("ld")
+	android.AssertBoolEquals(t, "libbar rejects undefined symbols", false, rejectsUndefined(barLd.Args["ldFlags"]))
+
+	stubsLd := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared_29").Rule("ld")
+	android.AssertBoolEquals(t, "stubs reject undefined symbols", false, rejectsUndefined(stubsLd.Args["ldFlags"]))
+
+	testCcError(t, `"libfoo" .*: check_no_undefined: cannot be used with allow_undefined_symbols`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			check_no_undefined: true,
+			allow_undefined_symbols: true,
+		}`)
+}
+
+func TestLibraryNoNostdlib(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libstartup",
+			srcs: ["foo.c"],
+			no_nostdlib: true,
+			nocrt: true,
+		}
+
+		cc_library_shared {
+			name: "libdefault",
+			srcs: ["foo.c"],
+		}`)
+
+	for _, tc := range []struct {
+		name     string
+		nostdlib bool
+	}{
+		{"libstartup", false},
+		{"libdefault", true},
+	} {
+		ld := result.ModuleForTests(tc.name, "android_arm64_armv8-a_shared").Rule("ld")
+		ldFlags := strings.Fields(ld.Args["ldFlags"])
+		android.AssertBoolEquals(t, tc.name+" links with -nostdlib", tc.nostdlib,
+			android.InList("-nostdlib", ldFlags))
+		android.AssertBoolEquals(t, tc.name+" links with --gc-sections", true,
+			android.InList("-Wl,--gc-sections", ldFlags))
+	}
+}
+
+func TestLibraryObjectsProviders(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c", "bar.cpp"],
+		}`)
+
+	shared := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Module()
+	sharedInfo := result.ModuleProvider(shared, SharedLibraryInfoProvider).(SharedLibraryInfo)
+	android.AssertPathsRelativeToTopEquals(t, "shared objects", []string{
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/obj/foo.o",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/obj/bar.o",
+	}, sharedInfo.Objects.objFiles)
+
+	static := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static").Module()
+	staticInfo := result.ModuleProvider(static, StaticLibraryInfoProvider).(StaticLibraryInfo)
+	android.AssertPathsRelativeToTopEquals(t, "static objects", []string{
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/obj/foo.o",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/obj/bar.o",
+	}, staticInfo.Objects.objFiles)
+}
+
+func TestLibraryBoltInstrument(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			bolt_instrument: true,
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	bolt := libfoo.Rule("boltInstrument")
+	android.AssertPathRelativeToTopEquals(t, "bolt input",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/unstripped/libfoo.so", bolt.Input)
+	android.AssertPathRelativeToTopEquals(t, "bolt output",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/bolt_instrumented/libfoo.so", bolt.Output)
+	android.AssertStringListContains(t, "emit relocs",
+		strings.Fields(libfoo.Rule("ld").Args["ldFlags"]), "-Wl,--emit-relocs")
+
+	outputs, err := libfoo.Module().(*Module).OutputFiles(".bolt_instrumented")
+	android.AssertDeepEquals(t, "bolt output error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "bolt instrumented output",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/bolt_instrumented/libfoo.so"}, outputs)
+	android.AssertPathRelativeToTopEquals(t, "normal output",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so", libfoo.Module().(*Module).OutputFile().Path())
+}
+
+func TestLibraryEmitRelocStats(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			emit_reloc_stats: true,
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	stats := libfoo.Rule("relocStats")
+	android.AssertPathRelativeToTopEquals(t, "reloc stats input",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so", stats.Input)
+	android.AssertPathRelativeToTopEquals(t, "reloc stats output",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.reloc_stats", stats.Output)
+	android.AssertStringDoesContain(t, "reloc stats counts relocations by type",
+		stats.RuleParams.Command, "count[$$3]++")
+	android.AssertStringDoesContain(t, "reloc stats only replaced when changed",
+		stats.RuleParams.Command, "cmp -s ${out}.tmp ${out}")
+
+	outputs, err := libfoo.Module().(*Module).OutputFiles(".reloc_stats")
+	android.AssertDeepEquals(t, "reloc stats output error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "reloc stats outputs",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.reloc_stats"}, outputs)
+
+	android.AssertBoolEquals(t, "reloc stats for the static variant", false,
+		result.ModuleForTests("libfoo", "android_arm64_armv8-a_static").MaybeRule("relocStats").Rule != nil)
+}
+
+func TestLibraryCrtBeginEnd(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_object {
+			name: "loader_crtbegin",
+			srcs: ["crtbegin.c"],
+			stl: "none",
+			system_shared_libs: [],
+		}
+
+		cc_object {
+			name: "loader_crtend",
+			srcs: ["crtend.c"],
+			stl: "none",
+			system_shared_libs: [],
+		}
+
+		cc_library_shared {
+			name: "libloader",
+			srcs: ["loader.c"],
+			crt_begin: ["loader_crtbegin"],
+			crt_end: ["loader_crtend"],
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+		}`)
+
+	ld := result.ModuleForTests("libloader", "android_arm64_armv8-a_shared").Rule("ld")
+	android.AssertStringEquals(t, "libloader crtBegin",
+		"out/soong/.intermediates/loader_crtbegin/android_arm64_armv8-a/loader_crtbegin.o", ld.Args["crtBegin"])
+	android.AssertStringEquals(t, "libloader crtEnd",
+		"out/soong/.intermediates/loader_crtend/android_arm64_armv8-a/loader_crtend.o", ld.Args["crtEnd"])
+
+	ld = result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Rule("ld")
+	android.AssertStringDoesContain(t, "libfoo crtBegin", ld.Args["crtBegin"], "crtbegin_so.o")
+	android.AssertStringDoesContain(t, "libfoo crtEnd", ld.Args["crtEnd"], "crtend_so.o")
+
+	testCcError(t, `"libloader" .*: crt_begin: "loader_crtbegin" is not a cc_object module`, `
+		cc_binary {
+			name: "loader_crtbegin",
+			srcs: ["crtbegin.c"],
+		}
+
+		cc_library_shared {
+			name: "libloader",
+			srcs: ["loader.c"],
+			crt_begin: ["loader_crtbegin"],
+		}`)
+}
+
+func TestLibraryEmitImports(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["bar.c"],
+		}
+
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			shared_libs: ["libbar"],
+			emit_imports: true,
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	imports := libfoo.Rule("importedSymbols")
+	android.AssertPathRelativeToTopEquals(t, "imports input",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so", imports.Input)
+	android.AssertPathRelativeToTopEquals(t, "imports output",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.imports", imports.Output)
+	android.AssertStringDoesContain(t, "imports lists undefined symbols",
+		imports.RuleParams.Command, "-D --undefined-only")
+	// The undefined symbols are resolved against libbar when libfoo is linked.
+	android.AssertStringListContains(t, "linked shared libs",
+		android.PathsRelativeToTop(libfoo.Rule("ld").OrderOnly),
+		"out/soong/.intermediates/libbar/android_arm64_armv8-a_shared/libbar.so")
+
+	info := result.ModuleProvider(libfoo.Module(), ImportedSymbolListInfoProvider).(ImportedSymbolListInfo)
+	android.AssertPathRelativeToTopEquals(t, "provided imports",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.imports", info.SymbolList)
+	outputs, err := libfoo.Module().(*Module).OutputFiles(".imports")
+	android.AssertDeepEquals(t, "imports output error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "imports outputs",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.imports"}, outputs)
+
+	android.AssertBoolEquals(t, "imports for the static variant", false,
+		result.ModuleForTests("libfoo", "android_arm64_armv8-a_static").MaybeRule("importedSymbols").Rule != nil)
+}
+
+func TestLibraryGenerateCompressedCopies(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			generate_compressed_copies: ["gzip", "zstd"],
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	module := libfoo.Module().(*Module)
+	gzip := libfoo.Rule("gzipCompress")
+	android.AssertPathRelativeToTopEquals(t, "gzip input is the stripped library",
+		android.PathRelativeToTop(module.outputFile.Path()), gzip.Input)
+	zstd := libfoo.Rule("zstdCompress")
+	android.AssertPathRelativeToTopEquals(t, "zstd input is the stripped library",
+		android.PathRelativeToTop(module.outputFile.Path()), zstd.Input)
+	android.AssertBoolEquals(t, "lz4 copy", false, libfoo.MaybeRule("lz4Compress").Rule != nil)
+
+	outputs, err := module.OutputFiles(".compressed")
+	android.AssertDeepEquals(t, "compressed outputs error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "compressed outputs", []string{
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/compressed/libfoo.so.gz",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/compressed/libfoo.so.zst",
+	}, outputs)
+
+	testCcError(t, `"libfoo" .*: generate_compressed_copies: "xz" is not one of gzip, zstd or lz4`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			generate_compressed_copies: ["xz"],
+		}`)
+}
+
+func TestLibraryGenerateChecksum(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			generate_checksum: true,
+			dist: {
+				targets: ["sdk"],
+				tag: ".sha256",
+			},
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	module := libfoo.Module().(*Module)
+	checksum := libfoo.Rule("checksum")
+	android.AssertPathRelativeToTopEquals(t, "checksum input is the installed library",
+		android.PathRelativeToTop(module.outputFile.Path()), checksum.Input)
+	android.AssertPathRelativeToTopEquals(t, "checksum output",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/checksum/libfoo.so.sha256", checksum.Output)
+
+	outputs, err := module.OutputFiles(".sha256")
+	android.AssertDeepEquals(t, "checksum output error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "checksum outputs",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/checksum/libfoo.so.sha256"}, outputs)
+
+	android.AssertStringListContains(t, "installed checksum",
+		android.PathsRelativeToTop(module.FilesToInstall().Paths()),
+		"out/soong/target/product/test_device/system/lib64/libfoo.so.sha256")
+
+	entries := android.AndroidMkEntriesForTest(t, result.TestContext, module)[0]
+	android.AssertStringDoesContain(t, "dist of the checksum",
+		android.StringRelativeToTop(result.Config, strings.Join(entries.GetDistForGoals(module), "")),
+		"$(call dist-for-goals,sdk,out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/checksum/libfoo.so.sha256:libfoo.so.sha256)")
+
+	android.AssertBoolEquals(t, "checksum for the static variant", false,
+		result.ModuleForTests("libfoo", "android_arm64_armv8-a_static").MaybeRule("checksum").Rule != nil)
+}
+
+func TestLibraryAllowedDependentsPartitions(t *testing.T) {
+	t.Parallel()
+	bp := `
+		cc_library_shared {
+			name: "libvendoronly",
+			srcs: ["foo.c"],
+			vendor_available: true,
+			allowed_dependents_partitions: ["vendor"],
+		}
+
+		cc_library_shared {
+			name: "libvendor",
+			srcs: ["foo.c"],
+			vendor: true,
+			shared_libs: ["libvendoronly"],
+		}
+	`
+	testCc(t, bp)
+
+	testCcError(t, `"libplatform" .*: depends on "libvendoronly", which may only be depended on from \["vendor"\], but this module is in the platform partition`,
+		bp+`
+		cc_library_shared {
+			name: "libplatform",
+			srcs: ["foo.c"],
+			shared_libs: ["libvendoronly"],
+		}
+	`)
+
+	testCcError(t, `"libfoo" .*: allowed_dependents_partitions: "odm" is not one of`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			allowed_dependents_partitions: ["odm"],
+		}
+	`)
+}
+
+func TestLibraryDlopenOnly(t *testing.T) {
+	t.Parallel()
+	bp := `
+		cc_library_shared {
+			name: "libplugin",
+			srcs: ["foo.c"],
+			dlopen_only: true,
+		}
+
+		cc_library_shared {
+			name: "libloader",
+			srcs: ["foo.c"],
+			runtime_libs: ["libplugin"],
+		}
+	`
+	testCc(t, bp)
+
+	testCcError(t, `"libfoo" .*: links against "libplugin", which is dlopen_only; use runtime_libs instead of shared_libs`,
+		bp+`
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			shared_libs: ["libplugin"],
+		}
+	`)
+}
+
+func TestLibraryInstallSubdir(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			multilib: {
+				lib32: {
+					library_install_subdir: "lib/hw",
+				},
+				lib64: {
+					library_install_subdir: "lib64/hw",
+				},
+			},
+		}`)
+
+	for variant, expected := range map[string]string{
+		"android_arm64_armv8-a_shared":    "out/soong/target/product/test_device/system/lib64/hw/libfoo.so",
+		"android_arm_armv7-a-neon_shared": "out/soong/target/product/test_device/system/lib/hw/libfoo.so",
+	} {
+		libfoo := result.ModuleForTests("libfoo", variant).Module().(*Module)
+		android.AssertPathRelativeToTopEquals(t, "install path of "+variant, expected,
+			libfoo.installer.(*libraryDecorator).path)
+	}
+
+	testCcError(t, `"libbar" .*: library_install_subdir: "../vendor/lib" must be a relative path inside the partition`, `
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["bar.c"],
+			library_install_subdir: "../vendor/lib",
+		}`)
+}
+
+func TestLibraryTocScript(t *testing.T) {
+	t.Parallel()
+	bp := `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+		}`
+
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, bp)
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	android.AssertStringDoesNotContain(t, "default toc rule",
+		libfoo.Output("libfoo.so.toc").Rule.String(), "customToc")
+
+	result = android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithCc,
+		android.FixtureAddTextFile("vendor/tools/toc.sh", ""),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.TocScripts = map[string]string{"android": "vendor/tools/toc.sh"}
+		}),
+	).RunTestWithBp(t, bp)
+	libfoo = result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	toc := libfoo.Output("libfoo.so.toc")
+	android.AssertStringDoesContain(t, "custom toc rule", toc.Rule.String(), "customToc")
+	android.AssertStringEquals(t, "custom toc command", "vendor/tools/toc.sh", toc.Args["tocCmd"])
+	android.AssertPathRelativeToTopEquals(t, "custom toc dependency", "vendor/tools/toc.sh", toc.Implicit)
+	android.AssertStringDoesContain(t, "custom toc format", toc.Args["format"], "--elf")
+}
+
+func TestLibraryGoldenExportedSymbols(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			golden_exported_symbols: "libfoo.exported_symbols.txt",
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["foo.c"],
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	symbols := libfoo.Output("libfoo.so.exported_symbols")
+	android.AssertPathRelativeToTopEquals(t, "exported symbols input",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/unstripped/libfoo.so", symbols.Input)
+
+	check := libfoo.Output("libfoo.so.golden_exported_symbols.timestamp")
+	cmd := android.StringRelativeToTop(result.Config, check.RuleParams.Command)
+	android.AssertStringDoesContain(t, "golden comparison", cmd,
+		"diff -u libfoo.exported_symbols.txt out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.exported_symbols")
+	android.AssertStringDoesContain(t, "update instructions", cmd,
+		"cp out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.exported_symbols libfoo.exported_symbols.txt")
+
+	ld := libfoo.Rule("ld")
+	android.AssertPathsRelativeToTopEquals(t, "ld validations",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.golden_exported_symbols.timestamp"},
+		ld.Validations)
+
+	libbar := result.ModuleForTests("libbar", "android_arm64_armv8-a_shared")
+	android.AssertBoolEquals(t, "check without golden list", false,
+		libbar.MaybeOutput("libbar.so.golden_exported_symbols.timestamp").Rule != nil)
+}
+
+func TestLibraryExportedSymbolDenylist(t *testing.T) {
+	t.Parallel()
+	result := android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithCc,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.ExportedSymbolDenylist = []string{"system", "popen"}
+			variables.ExportedSymbolDenylistAllowedPaths = []string{"allowed"}
+		}),
+		android.FixtureAddTextFile("allowed/Android.bp", `
+			cc_library_shared {
+				name: "liballowed",
+				srcs: ["foo.c"],
+			}`),
+	).RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			host_supported: true,
+			srcs: ["foo.c"],
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	check := libfoo.Output("libfoo.so.exported_symbol_denylist.timestamp")
+	cmd := android.StringRelativeToTop(result.Config, check.RuleParams.Command)
+	android.AssertStringDoesContain(t, "denylist check", cmd,
+		"if grep -Fx -e system -e popen out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.exported_symbols; then")
+	android.AssertStringDoesContain(t, "denylist error", cmd, "exit 1")
+	// Versioned names such as system@@LIBC must match the unversioned denylist entries.
+	android.AssertStringDoesContain(t, "symbol versions dropped",
+		libfoo.Output("libfoo.so.exported_symbols").RuleParams.Command, "sed 's/@.*//'")
+	android.AssertPathsRelativeToTopEquals(t, "ld validations",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.exported_symbol_denylist.timestamp"},
+		libfoo.Rule("ld").Validations)
+
+	liballowed := result.ModuleForTests("liballowed", "android_arm64_armv8-a_shared")
+	android.AssertBoolEquals(t, "check under an allowed path", false,
+		liballowed.MaybeOutput("liballowed.so.exported_symbol_denylist.timestamp").Rule != nil)
+
+	host := result.ModuleForTests("libfoo", result.Config.BuildOSTarget.String()+"_shared")
+	android.AssertBoolEquals(t, "check for host", false,
+		host.MaybeOutput("libfoo.so.exported_symbol_denylist.timestamp").Rule != nil)
+}
+
+func TestLibraryImplementationWholeStaticLibs(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_static {
+			name: "libwhole",
+			srcs: ["whole.c"],
+			export_include_dirs: ["whole_include"],
+		}
+
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_include_dirs: ["include"],
+			shared: {
+				implementation_whole_static_libs: ["libwhole"],
+			},
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	info := result.ModuleProvider(libfoo.Module(), FlagExporterInfoProvider).(FlagExporterInfo)
+	android.AssertPathsRelativeToTopEquals(t, "exported include dirs",
+		[]string{"include"}, info.IncludeDirs)
+
+	android.AssertStringDoesContain(t, "libfoo is compiled against the headers of libwhole",
+		libfoo.Rule("cc").Args["cFlags"], "-Iwhole_include")
+
+	libwhole := result.ModuleForTests("libwhole", "android_arm64_armv8-a_static").Output("libwhole.a")
+	android.AssertStringDoesContain(t, "libwhole is linked as a whole archive",
+		libfoo.Rule("ld").Args["libFlags"], libwhole.Output.String())
+
+	libfooStatic := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+	android.AssertStringDoesNotContain(t, "static variant does not include libwhole",
+		libfooStatic.Rule("cc").Args["cFlags"], "-Iwhole_include")
+}
+
+func TestLibraryTocWithUseVersionLib(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			host_supported: true,
+			use_version_lib: true,
+		}`)
+
+	// On host the versioned library is the output, so the TOC must be extracted from the
+	// (stripped) output of the version symbol injection.
+	host := result.ModuleForTests("libfoo", result.Config.BuildOSTarget.String()+"_shared")
+	hostToc := host.Output("libfoo.so.toc")
+	inject := host.Rule("injectVersionSymbol")
+	strip := host.Output(hostToc.Input.Base())
+	android.AssertPathRelativeToTopEquals(t, "host toc input", android.PathRelativeToTop(strip.Output), hostToc.Input)
+	android.AssertPathRelativeToTopEquals(t, "host stripped versioned library",
+		android.PathRelativeToTop(inject.Output), strip.Input)
+
+	// On device the versioned library is only used for dist, and the TOC is extracted from the
+	// library that is installed and linked against.
+	device := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	deviceToc := device.Output("libfoo.so.toc")
+	android.AssertPathRelativeToTopEquals(t, "device toc input",
+		android.PathRelativeToTop(device.Module().(*Module).OutputFile().Path()), deviceToc.Input)
+}
+
+func TestLibraryDistArtifactInfoProvider(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			use_version_lib: true,
+		}
+
+		cc_library {
+			name: "libbar",
+			srcs: ["bar.c"],
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Module()
+	info := result.ModuleProvider(libfoo, DistArtifactInfoProvider).(DistArtifactInfo)
+	android.AssertPathRelativeToTopEquals(t, "libfoo dist file",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/versioned-stripped/libfoo.so", info.DistFile)
+
+	libbar := result.ModuleForTests("libbar", "android_arm64_armv8-a_shared").Module()
+	info = result.ModuleProvider(libbar, DistArtifactInfoProvider).(DistArtifactInfo)
+	android.AssertPathRelativeToTopEquals(t, "libbar dist file",
+		android.PathRelativeToTop(libbar.(*Module).OutputFile().Path()), info.DistFile)
+}
+
+func TestLibraryExportAidlHeadersInModuleDir(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["IFoo.aidl"],
+			aidl: {
+				export_aidl_headers: true,
+				export_aidl_headers_in_module_dir: true,
+			},
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["IFoo.aidl"],
+			aidl: {
+				export_aidl_headers: true,
+				export_aidl_headers_in_module_dir: true,
+			},
+		}
+
+		cc_library_shared {
+			name: "libbaz",
+			srcs: ["baz.cpp"],
+			shared_libs: ["libfoo", "libbar"],
+		}`)
+
+	// Both libraries generate IFoo.h, and only export it as <module>/IFoo.h. The aidl gen
+	// directory is only on their own include path.
+	for _, name := range []string{"libfoo", "libbar"} {
+		variant := result.ModuleForTests(name, "android_arm64_armv8-a_shared")
+		info := result.ModuleProvider(variant.Module(), FlagExporterInfoProvider).(FlagExporterInfo)
+		genDir := "out/soong/.intermediates/" + name + "/android_arm64_armv8-a_shared/gen/"
+		android.AssertPathsRelativeToTopEquals(t, name+" exported include dirs",
+			[]string{genDir + "aidl_export/include"}, info.IncludeDirs)
+		android.AssertPathsRelativeToTopEquals(t, name+" exported aidl headers", []string{
+			genDir + "aidl_export/include/" + name + "/IFoo.h",
+			genDir + "aidl_export/include/" + name + "/BnFoo.h",
+			genDir + "aidl_export/include/" + name + "/BpFoo.h",
+		}, info.GeneratedHeaders)
+		cFlags := strings.Fields(android.StringRelativeToTop(result.Config, variant.Rule("cc").Args["cFlags"]))
+		android.AssertStringListContains(t, name+" own include path", cFlags, "-I"+genDir+"aidl")
+	}
+
+	// A dependent of both libraries only gets the per-module directories.
+	cFlags := strings.Fields(android.StringRelativeToTop(result.Config,
+		result.ModuleForTests("libbaz", "android_arm64_armv8-a_shared").Rule("cc").Args["cFlags"]))
+	for _, name := range []string{"libfoo", "libbar"} {
+		genDir := "out/soong/.intermediates/" + name + "/android_arm64_armv8-a_shared/gen/"
+		android.AssertStringListContains(t, "libbaz includes "+name+" module dir", cFlags,
+			"-I"+genDir+"aidl_export/include")
+		android.AssertStringListDoesNotContain(t, "libbaz includes "+name+" aidl gen dir", cFlags,
+			"-I"+genDir+"aidl")
+	}
+}
+
+func TestLibraryPreprocessSrcs(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c", "bar.cpp"],
+			preprocess_srcs: ["foo.c", "bar.cpp"],
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+	// C sources are preprocessed to .i, and C++ sources to .ii.
+	preprocess := libfoo.Output("obj/foo.i")
+	android.AssertStringEquals(t, "preprocessed C source", "foo.c", preprocess.Input.String())
+	android.AssertStringDoesContain(t, "preprocess command", preprocess.RuleParams.Command, "-E")
+	preprocess = libfoo.Output("obj/bar.ii")
+	android.AssertStringEquals(t, "preprocessed C++ source", "bar.cpp", preprocess.Input.String())
+	android.AssertBoolEquals(t, "C++ source preprocessed to .i", false, libfoo.MaybeOutput("obj/bar.i").Rule != nil)
+	// The object files are still compiled.
+	libfoo.Output("obj/foo.o")
+	libfoo.Output("obj/bar.o")
+
+	for _, variant := range []string{"android_arm64_armv8-a_static", "android_arm64_armv8-a_shared"} {
+		outputs, err := result.ModuleForTests("libfoo", variant).Module().(*Module).OutputFiles(".preprocessed")
+		android.AssertDeepEquals(t, variant+" preprocessed output error", nil, err)
+		android.AssertPathsRelativeToTopEquals(t, variant+" preprocessed outputs",
+			[]string{
+				"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/obj/foo.i",
+				"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/obj/bar.ii",
+			}, outputs)
+	}
+
+	testCcError(t, `"libfoo" .*: preprocess_srcs: "baz.c" is not listed in srcs`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			preprocess_srcs: ["baz.c"],
+		}`)
+}
+
+func TestLibraryAsmListingSrcs(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c", "bar.cpp"],
+			asm_listing_srcs: ["bar.cpp"],
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+	listing := libfoo.Output("obj/bar.s")
+	android.AssertStringEquals(t, "listed source", "bar.cpp", listing.Input.String())
+	android.AssertStringDoesContain(t, "asm listing command", listing.RuleParams.Command, " -S ")
+	android.AssertBoolEquals(t, "foo.c listed", false, libfoo.MaybeOutput("obj/foo.s").Rule != nil)
+	// The object file is still compiled.
+	libfoo.Output("obj/bar.o")
+
+	for _, variant := range []string{"android_arm64_armv8-a_static", "android_arm64_armv8-a_shared"} {
+		outputs, err := result.ModuleForTests("libfoo", variant).Module().(*Module).OutputFiles(".asm_listing")
+		android.AssertDeepEquals(t, variant+" asm listing output error", nil, err)
+		android.AssertPathsRelativeToTopEquals(t, variant+" asm listing outputs",
+			[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/obj/bar.s"}, outputs)
+	}
+
+	testCcError(t, `"libfoo" .*: asm_listing_srcs: "baz.c" is not listed in srcs`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			asm_listing_srcs: ["baz.c"],
+		}`)
+}
+
+func TestLibraryPerFileCflags(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c", "bar.cpp"],
+			per_file_cflags: [
+				{
+					srcs: ["bar.cpp"],
+					cflags: ["-O0"],
+				},
+			],
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+	android.AssertStringDoesContain(t, "bar.cpp cflags", libfoo.Output("obj/bar.o").Args["cFlags"], " -O0")
+	android.AssertStringDoesNotContain(t, "foo.c cflags", libfoo.Output("obj/foo.o").Args["cFlags"], "-O0")
+
+	testCcError(t, `"libfoo" .*: per_file_cflags.srcs: "baz.c" is not listed in srcs`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			per_file_cflags: [
+				{
+					srcs: ["baz.c"],
+					cflags: ["-O0"],
+				},
+			],
+		}`)
+}
+
+func TestStaticLibraryObjectLimit(t *testing.T) {
+	t.Parallel()
+	bp := `
+		cc_library_static {
+			name: "libwhole",
+			srcs: ["a.c", "b.c"],
+		}
+
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			whole_static_libs: ["libwhole"],
+		}`
+
+	limit := func(limit int, isError bool) android.FixturePreparer {
+		return android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.StaticLibraryObjectLimit = proptools.IntPtr(limit)
+			variables.StaticLibraryObjectLimitIsError = proptools.BoolPtr(isError)
+		})
+	}
+
+	android.GroupFixturePreparers(PrepareForIntegrationTestWithCc, limit(2, true)).
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`module "libfoo" variant "android_arm64_armv8-a_static": archives 3 objects, more than the limit of 2`)).
+		RunTestWithBp(t, bp)
+
+	result := android.GroupFixturePreparers(PrepareForIntegrationTestWithCc, limit(2, false)).RunTestWithBp(t, bp)
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+	warning := libfoo.Output("static_library_object_limit.timestamp")
+	android.AssertStringDoesContain(t, "warning", warning.Args["message"],
+		"warning: libfoo: archives 3 objects, more than the limit of 2")
+	android.AssertPathsRelativeToTopEquals(t, "ar validations",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/static_library_object_limit.timestamp"},
+		libfoo.Rule("ar").Validations)
+
+	result = android.GroupFixturePreparers(PrepareForIntegrationTestWithCc, limit(3, false)).RunTestWithBp(t, bp)
+	android.AssertBoolEquals(t, "warning within the limit", false,
+		result.ModuleForTests("libfoo", "android_arm64_armv8-a_static").
+			MaybeOutput("static_library_object_limit.timestamp").Rule != nil)
+}
+
+func TestLibraryExportIncludeDirsFromGenrule(t *testing.T) {
+	t.Parallel()
+	ctx := testCc(t, `
+		genrule {
+			name: "genrule_foo",
+			cmd: "generate-foo",
+			out: ["generated_headers/foo/generated_header.h"],
+			export_include_dirs: ["generated_headers"],
+		}
+
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_include_dirs: ["foo/standard", ":genrule_foo"],
+		}
+
+		cc_library_shared {
+			name: "libclient",
+			srcs: ["client.c"],
+			shared_libs: ["libfoo"],
+		}`)
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Module()
+	info := ctx.ModuleProvider(libfoo, FlagExporterInfoProvider).(FlagExporterInfo)
+	android.AssertPathsRelativeToTopEquals(t, "exported include dirs", []string{
+		"foo/standard",
+		"out/soong/.intermediates/genrule_foo/gen/generated_headers",
+	}, info.IncludeDirs)
+	android.AssertPathsRelativeToTopEquals(t, "exported generated headers",
+		[]string{"out/soong/.intermediates/genrule_foo/gen/generated_headers/foo/generated_header.h"},
+		info.GeneratedHeaders)
+
+	cFlags := ctx.ModuleForTests("libclient", "android_arm64_armv8-a_shared").Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "consumer include dirs", android.StringRelativeToTop(ctx.Config(), cFlags),
+		"-Iout/soong/.intermediates/genrule_foo/gen/generated_headers")
+}
+
+func TestLibraryCheckGeneratedHeaderCollisions(t *testing.T) {
+	t.Parallel()
+	bp := `
+		genrule {
+			name: "genrule_foo",
+			cmd: "generate-foo",
+			out: ["generated_headers/foo/config.h"],
+			export_include_dirs: ["generated_headers"],
+		}
+
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_include_dirs: ["include", ":genrule_foo"],
+			check_generated_header_collisions: true,
+		}`
+
+	android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddTextFile("include/foo/config.h", ""),
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`"libfoo" .*: check_generated_header_collisions: generated header "generated_headers/foo/config.h" `+
+			`collides with source header "include/foo/config.h", both are included as "foo/config.h"`)).
+		RunTestWithBp(t, bp)
+
+	android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddTextFile("include/foo/foo.h", ""),
+	).RunTestWithBp(t, bp)
+}
+
+func TestLibraryCheckNoSystemHeaderShadowing(t *testing.T) {
+	t.Parallel()
+	fs := android.FixtureMergeMockFs(android.MockFS{
+		"include/stdlib.h":     nil,
+		"include/sys/types.h":  nil,
+		"include/foo/string.h": nil,
+		"include/vector":       nil,
+	})
+
+	android.GroupFixturePreparers(prepareForCcTest, fs).
+		ExtendWithErrorHandler(android.FixtureCustomErrorHandler(func(t *testing.T, result *android.TestResult) {
+			for _, pattern := range []string{
+				`"libfoo" .*: check_no_system_header_shadowing: exported header "include/stdlib.h" shadows the system header <stdlib.h>`,
+				`"libfoo" .*: check_no_system_header_shadowing: exported header "include/sys/types.h" shadows the system header <sys/types.h>`,
+				`"libfoo" .*: check_no_system_header_shadowing: exported header "include/vector" shadows the system header <vector>`,
+			} {
+				android.FailIfNoMatchingErrors(t, pattern, result.Errs)
+			}
+			for _, err := range result.Errs {
+				android.AssertStringDoesNotContain(t, "error for a non-shadowing header", err.Error(), "foo/string.h")
+			}
+		})).
+		RunTestWithBp(t, `
+			cc_library {
+				name: "libfoo",
+				srcs: ["foo.c"],
+				export_include_dirs: ["include"],
+				check_no_system_header_shadowing: true,
+			}`)
+
+	android.GroupFixturePreparers(prepareForCcTest, fs).RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_include_dirs: ["include"],
+			check_no_system_header_shadowing: true,
+			system_header_shadowing_allowlist: ["stdlib.h", "sys/types.h", "vector"],
+		}`)
+}
+
+func TestLibraryCApiHeaders(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.cpp"],
+			export_include_dirs: ["include"],
+			c_api_headers: ["include/foo/api.h"],
+		}`)
+
+	for _, variant := range []string{"android_arm64_armv8-a_shared", "android_arm64_armv8-a_static"} {
+		libfoo := result.ModuleForTests("libfoo", variant)
+		check := libfoo.Output("c_api_headers/include/foo/api.h.c_check.timestamp")
+		android.AssertStringEquals(t, variant+" checked header", "include/foo/api.h", check.Input.String())
+		android.AssertStringDoesContain(t, variant+" compiled as C", check.RuleParams.Command, "-x c -fsyntax-only")
+		android.AssertStringDoesContain(t, variant+" writes depfile", check.RuleParams.Command, "-MD -MF ${out}.d")
+		android.AssertStringEquals(t, variant+" depfile", "${out}.d", check.RuleParams.Depfile)
+		android.AssertStringDoesContain(t, variant+" include flags", check.Args["cFlags"], "-Iinclude")
+
+		var link android.TestingBuildParams
+		if strings.HasSuffix(variant, "_shared") {
+			link = libfoo.Rule("ld")
+		} else {
+			link = libfoo.Rule("ar")
+		}
+		android.AssertPathsRelativeToTopEquals(t, variant+" link validations",
+			[]string{"out/soong/.intermediates/libfoo/" + variant + "/c_api_headers/include/foo/api.h.c_check.timestamp"},
+			link.Validations)
+	}
+
+	testCcError(t, `"libfoo" .*: c_api_headers: "private/foo.h" is not under any of the exported include directories`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.cpp"],
+			export_include_dirs: ["include"],
+			c_api_headers: ["private/foo.h"],
+		}`)
+}
+
+func TestLibraryCheckExportedHeaderClosure(t *testing.T) {
+	t.Parallel()
+	result := android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithCc,
+		android.FixtureAddTextFile("include/foo.h", `#include "private.h"`),
+		android.FixtureAddTextFile("src/private.h", ""),
+	).RunTestWithBp(t, `
+		cc_library_headers {
+			name: "libdep_headers",
+			export_include_dirs: ["dep"],
+		}
+
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.cpp"],
+			local_include_dirs: ["src"],
+			export_include_dirs: ["include"],
+			header_libs: ["libdep_headers"],
+			export_header_lib_headers: ["libdep_headers"],
+			check_exported_header_closure: true,
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	check := libfoo.Output("exported_header_closure.timestamp")
+	android.AssertPathsRelativeToTopEquals(t, "checked headers", []string{"include/foo.h"}, check.Inputs)
+	cmd := check.RuleParams.Command
+	android.AssertStringDoesContain(t, "exported dir", cmd, "--exported-dir include ")
+	android.AssertStringDoesContain(t, "reexported dir", cmd, "--exported-dir dep ")
+	android.AssertStringDoesContain(t, "private include dir", cmd, "--include-dir src ")
+	android.AssertPathsRelativeToTopEquals(t, "link validations",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/exported_header_closure.timestamp"},
+		libfoo.Rule("ld").Validations)
+}
+
+func TestLibraryExportIncludeDirsDeprecated(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_include_dirs: ["include"],
+			export_include_dirs_deprecated: ["old_include"],
+		}
+
+		cc_library {
+			name: "libbar",
+			srcs: ["bar.c"],
+			export_include_dirs: ["include"],
+		}
+
+		cc_library_shared {
+			name: "libclient",
+			srcs: ["client.c"],
+			shared_libs: ["libfoo"],
+		}
+
+		cc_library_shared {
+			name: "libother",
+			srcs: ["other.c"],
+			shared_libs: ["libbar"],
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Module()
+	info := result.ModuleProvider(libfoo, FlagExporterInfoProvider).(FlagExporterInfo)
+	android.AssertPathsRelativeToTopEquals(t, "exported include dirs",
+		[]string{"include", "old_include"}, info.IncludeDirs)
+	android.AssertPathsRelativeToTopEquals(t, "deprecated include dirs",
+		[]string{"old_include"}, info.DeprecatedIncludeDirs)
+
+	libclient := result.ModuleForTests("libclient", "android_arm64_armv8-a_shared")
+	warning := libclient.Output("deprecated_include_dirs.timestamp")
+	android.AssertStringDoesContain(t, "warning", warning.Args["message"],
+		"warning: libclient: depends on libraries exporting deprecated include directories")
+	android.AssertStringDoesContain(t, "warning", warning.Args["message"], "libfoo (old_include)")
+	android.AssertStringDoesContain(t, "client cflags", libclient.Rule("cc").Args["cFlags"], "-Iold_include")
+	android.AssertStringDoesContain(t, "compile order-only deps",
+		strings.Join(libclient.Rule("cc").OrderOnly.Strings(), " "), "deprecated_include_dirs.timestamp")
+
+	libother := result.ModuleForTests("libother", "android_arm64_armv8-a_shared")
+	if libother.MaybeOutput("deprecated_include_dirs.timestamp").Rule != nil {
+		t.Errorf("unexpected deprecated include dirs warning for libother")
+	}
+}
+
+func TestLibrarySymbolOrderingFile(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			symbol_ordering_file: "libfoo.order",
+		}`)
+
+	ld := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Rule("ld")
+	android.AssertStringDoesContain(t, "ldflags", ld.Args["ldFlags"],
+		"-Wl,--symbol-ordering-file=libfoo.order")
+	android.AssertStringListContains(t, "symbol ordering file input",
+		ld.Implicits.Strings(), "libfoo.order")
+
+	testCcError(t, `"libfoo" .*: symbol_ordering_file: only applies to shared libraries`, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			symbol_ordering_file: "libfoo.order",
+		}`)
+}
+
+func TestLibraryWarnOnWholeStaticSharedDeps(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			whole_static_libs: ["libwhole"],
+			shared_libs: ["libdirect"],
+			warn_on_whole_static_shared_deps: true,
+		}
+
+		cc_library_static {
+			name: "libwhole",
+			srcs: ["whole.c"],
+			shared_libs: ["libpulled"],
+		}
+
+		cc_library_shared {
+			name: "libpulled",
+			srcs: ["pulled.c"],
+		}
+
+		cc_library_shared {
+			name: "libdirect",
+			srcs: ["direct.c"],
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	warning := libfoo.Output("whole_static_shared_deps.timestamp")
+	android.AssertStringDoesContain(t, "warning", warning.Args["message"],
+		"warning: libfoo: whole_static_libs depend on shared libraries")
+	android.AssertStringDoesContain(t, "warning", warning.Args["message"], "libpulled (via libwhole)")
+	android.AssertStringDoesNotContain(t, "warning", warning.Args["message"], "libdirect")
+	android.AssertStringListContains(t, "link validations",
+		libfoo.Rule("ld").Validations.Strings(), warning.Output.String())
+}
+
+func TestReexportedHeaderLibsProvider(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_headers {
+			name: "libheaders",
+			vendor_available: true,
+		}
+
+		cc_library_headers {
+			name: "libplatform_headers",
+			vendor_available: true,
+		}
+
+		cc_library_headers {
+			name: "libprivate_headers",
+			vendor_available: true,
+		}
+
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			vendor_available: true,
+			header_libs: ["libheaders", "libplatform_headers", "libprivate_headers"],
+			export_header_lib_headers: ["libheaders", "libplatform_headers"],
+			target: {
+				vendor: {
+					exclude_header_libs: ["libplatform_headers"],
+				},
+			},
+		}`)
+
+	for _, tc := range []struct {
+		variant  string
+		expected []string
+	}{
+		{"android_arm64_armv8-a_shared", []string{"libheaders", "libplatform_headers"}},
+		{"android_vendor.29_arm64_armv8-a_shared", []string{"libheaders"}},
+	} {
+		module := result.ModuleForTests("libfoo", tc.variant).Module()
+		info := result.ModuleProvider(module, ReexportedHeaderLibsInfoProvider).(ReexportedHeaderLibsInfo)
+		android.AssertDeepEquals(t, tc.variant+" reexported header libs", tc.expected, info.HeaderLibs)
+	}
+}
+
+func TestLibraryExportedSymbolListProvider(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			version_script: "foo.map.txt",
+		}
+
+		cc_library {
+			name: "libbar",
+			srcs: ["bar.c"],
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	info := result.ModuleProvider(libfoo.Module(), ExportedSymbolListInfoProvider).(ExportedSymbolListInfo)
+	android.AssertPathRelativeToTopEquals(t, "symbol list",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.syms", info.SymbolList)
+
+	rule := libfoo.Rule("versionScriptSymbols")
+	android.AssertStringDoesContain(t, "symbol list command",
+		android.StringRelativeToTop(result.Config, rule.RuleParams.Command),
+		"version_script_symbols foo.map.txt --output out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.syms")
+
+	libbar := result.ModuleForTests("libbar", "android_arm64_armv8-a_shared").Module()
+	info = result.ModuleProvider(libbar, ExportedSymbolListInfoProvider).(ExportedSymbolListInfo)
+	if info.SymbolList != nil {
+		t.Errorf("expected no symbol list for libbar, got %q", info.SymbolList)
+	}
+}
+
+func TestLibraryDynamicListWithVersionScript(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			version_script: "foo.map.txt",
+			dynamic_list: "foo.dynamic.txt",
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Rule("ld")
+
+	android.AssertStringListContains(t, "missing dependency on version_script",
+		libfoo.Implicits.Strings(), "foo.map.txt")
+	android.AssertStringListContains(t, "missing dependency on dynamic_list",
+		libfoo.Implicits.Strings(), "foo.dynamic.txt")
+	android.AssertStringDoesContain(t, "missing flag for version_script",
+		libfoo.Args["ldFlags"], "-Wl,--version-script,foo.map.txt")
+	android.AssertStringDoesContain(t, "missing flag for dynamic_list",
+		libfoo.Args["ldFlags"], "-Wl,--dynamic-list,foo.dynamic.txt")
+}
+
+func TestLibraryGenerateCompileCommands(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c", "bar.cpp", "baz.c"],
+			tidy_disabled_srcs: ["baz.c"],
+			cflags: ["-DFOO_FLAG"],
+			generate_compile_commands: true,
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+	content := android.ContentFromFileRuleForTests(t, result.TestContext,
+		result.SingletonForTests("compile_commands_fragments").Output(
+			"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/compile_commands.json"))
+
+	android.AssertIntEquals(t, "number of compile_commands.json entries", 2,
+		strings.Count(content, `"file":`))
+	android.AssertStringDoesContain(t, "missing entry for foo.c", content, `"file":"foo.c"`)
+	android.AssertStringDoesContain(t, "missing entry for bar.cpp", content, `"file":"bar.cpp"`)
+	android.AssertStringDoesNotContain(t, "unexpected entry for tidy_disabled_srcs", content, "baz.c")
+	android.AssertStringDoesContain(t, "missing cflags", content, "-DFOO_FLAG")
+
+	var entries []compDbEntry
+	if err := json.Unmarshal([]byte(content), &entries); err != nil {
+		t.Fatalf("invalid compile_commands.json fragment: %s", err)
+	}
+	clangBin := "prebuilts/clang/host/linux-x86/" + config.ClangDefaultVersion + "/bin/"
+	for _, entry := range entries {
+		android.AssertStringDoesNotContain(t, entry.File+" unresolved ninja variable", entry.Command, "${")
+		compiler := clangBin + "clang "
+		if entry.File == "bar.cpp" {
+			compiler = clangBin + "clang++ "
+		}
+		android.AssertBoolEquals(t, entry.File+" compiler: "+entry.Command, true,
+			strings.HasPrefix(entry.Command, compiler))
+	}
+
+	outputs, err := libfoo.Module().(*Module).OutputFiles(".compile_commands")
+	if err != nil {
+		t.Fatalf("unexpected error from OutputFiles: %s", err)
+	}
+	android.AssertPathsRelativeToTopEquals(t, "OutputFiles(.compile_commands)",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/compile_commands.json"},
+		outputs)
+}
+
+func TestLibraryCompressDebugSections(t *testing.T) {
+	t.Parallel()
+	for _, compress := range []string{"none", "zlib", "zstd"} {
+		t.Run(compress, func(t *testing.T) {
+			result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+				cc_library_shared {
+					name: "libfoo",
+					srcs: ["foo.c"],
+					compress_debug_sections: "`+compress+`",
+				}`)
+
+			ldFlags := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Rule("ld").Args["ldFlags"]
+			android.AssertStringDoesContain(t, "missing compress-debug-sections flag",
+				ldFlags, "-Wl,--compress-debug-sections="+compress)
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		testCcError(t, `"libfoo" .*: compress_debug_sections: invalid value "lz4"`, `
+			cc_library_shared {
+				name: "libfoo",
+				srcs: ["foo.c"],
+				compress_debug_sections: "lz4",
+			}`)
+	})
+}
+
+func TestLibraryRunpaths(t *testing.T) {
+	t.Parallel()
+	t.Run("host", func(t *testing.T) {
+		result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+			cc_library_shared {
+				name: "libfoo",
+				srcs: ["foo.c"],
+				host_supported: true,
+				device_supported: false,
+				runpaths: ["$ORIGIN", "$ORIGIN/../plugins"],
+			}`)
+
+		host := result.ModuleForTests("libfoo", result.Config.BuildOSTarget.String()+"_shared")
+		ldFlags := strings.Fields(host.Rule("ld").Args["ldFlags"])
+		android.AssertStringListContains(t, "missing $ORIGIN runpath",
+			ldFlags, `-Wl,-rpath,\$$ORIGIN`)
+		android.AssertStringListContains(t, "missing $ORIGIN/../plugins runpath",
+			ldFlags, `-Wl,-rpath,\$$ORIGIN/../plugins`)
+	})
+
+	t.Run("not relative to origin", func(t *testing.T) {
+		testCcError(t, `"libfoo" .*: runpaths: "/usr/lib" must be relative to \$ORIGIN`, `
+			cc_library_shared {
+				name: "libfoo",
+				srcs: ["foo.c"],
+				host_supported: true,
+				device_supported: false,
+				runpaths: ["/usr/lib"],
+			}`)
+	})
+
+	t.Run("device", func(t *testing.T) {
+		testCcError(t, `"libfoo" .*: runpaths: is only supported for Linux and Darwin host libraries`, `
+			cc_library_shared {
+				name: "libfoo",
+				srcs: ["foo.c"],
+				runpaths: ["$ORIGIN"],
+			}`)
+	})
+}
+
+func TestLibraryDarwinInstallName(t *testing.T) {
+	t.Parallel()
+	t.Run("darwin", func(t *testing.T) {
+		if runtime.GOOS != "darwin" {
+			t.Skip("Darwin host variants are only created when building on Darwin")
+		}
+		result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+			cc_library_shared {
+				name: "libfoo",
+				srcs: ["foo.c"],
+				host_supported: true,
+				device_supported: false,
+				darwin_install_name: "@loader_path/../lib/libfoo.dylib",
+			}`)
+
+		host := result.ModuleForTests("libfoo", result.Config.BuildOSTarget.String()+"_shared")
+		ldFlags := host.Rule("ld").Args["ldFlags"]
+		android.AssertStringDoesContain(t, "install name", ldFlags,
+			"-install_name @loader_path/../lib/libfoo.dylib")
+		android.AssertStringDoesNotContain(t, "default install name", ldFlags, "-install_name @rpath/")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		testCcError(t, `"libfoo" .*: darwin_install_name: "lib/libfoo.dylib" must be an absolute path or start with @rpath/`, `
+			cc_library_shared {
+				name: "libfoo",
+				srcs: ["foo.c"],
+				darwin_install_name: "lib/libfoo.dylib",
+			}`)
+
+		testCcError(t, `"libfoo" .*: darwin_install_name: "/lib/\$\(touch x\)\.dylib" must not contain "\$"`, `
+			cc_library_shared {
+				name: "libfoo",
+				srcs: ["foo.c"],
+				darwin_install_name: "/lib/$(touch x).dylib",
+			}`)
+	})
+}
+
+func TestLibrarySplitSections(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			cflags: ["-fno-function-sections"],
+			split_sections: true,
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	android.AssertStringListContains(t, "missing gc-sections",
+		strings.Fields(libfoo.Rule("ld").Args["ldFlags"]), "-Wl,--gc-sections")
+
+	cFlags := strings.Fields(libfoo.Rule("cc").Args["cFlags"])
+	android.AssertStringListContains(t, "missing -fdata-sections", cFlags, "-fdata-sections")
+	if lastIndexOfFlag("-ffunction-sections", cFlags) < lastIndexOfFlag("-fno-function-sections", cFlags) {
+		t.Errorf("expected -ffunction-sections after -fno-function-sections, got %q", cFlags)
+	}
+}
+
+func TestLibrarySymbolVisibilityAudit(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			symbol_visibility_audit: {
+				tool: "audit.sh",
+				denylist: "denylist.txt",
+			},
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	audit := libfoo.Rule("symbolVisibilityAudit")
+	android.AssertStringEquals(t, "audit command",
+		"audit.sh --denylist denylist.txt out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/unstripped/libfoo.so && "+
+			"touch out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.symbol_visibility_audit.timestamp",
+		android.StringRelativeToTop(result.Config, audit.RuleParams.Command))
+
+	ld := libfoo.Rule("ld")
+	android.AssertPathsRelativeToTopEquals(t, "ld validations",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.symbol_visibility_audit.timestamp"},
+		ld.Validations)
+
+	testCcError(t, `"libfoo" .*: symbol_visibility_audit: tool and denylist must be set together`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			symbol_visibility_audit: {
+				tool: "audit.sh",
+			},
+		}`)
+}
+
+func TestLibraryPostLinkValidators(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			post_link_validators: [
+				{
+					tool: "no_textrel.sh",
+					args: ["--quiet"],
+				},
+				{
+					tool: "check_align.sh",
+				},
+			],
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	outDir := "out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/"
+	validator := libfoo.Rule("postLinkValidator0")
+	android.AssertStringEquals(t, "validator command",
+		"no_textrel.sh --quiet "+outDir+"unstripped/libfoo.so && "+
+			"touch "+outDir+"post_link_validators/0.timestamp",
+		android.StringRelativeToTop(result.Config, validator.RuleParams.Command))
+
+	ld := libfoo.Rule("ld")
+	android.AssertPathsRelativeToTopEquals(t, "ld validations",
+		[]string{
+			outDir + "post_link_validators/0.timestamp",
+			outDir + "post_link_validators/1.timestamp",
+		},
+		ld.Validations)
+
+	testCcError(t, `"libfoo" .*: post_link_validators: tool must be set`, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			post_link_validators: [{args: ["--quiet"]}],
+		}`)
+}
+
+func TestLibraryStripUseGnuStrip(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libgnu",
+			srcs: ["foo.c"],
+			host_supported: true,
+			device_supported: false,
+			strip: {
+				all: true,
+				use_gnu_strip: true,
+			},
+		}
+
+		cc_library_shared {
+			name: "libllvm",
+			srcs: ["foo.c"],
+			host_supported: true,
+			device_supported: false,
+			strip: {
+				all: true,
+			},
+		}`)
+
+	gnuStrip := result.ModuleForTests("libgnu", "linux_glibc_x86_64_shared").Rule("gnuStrip")
+	android.AssertStringDoesContain(t, "libgnu strip args", gnuStrip.Args["args"], "--use-gnu-strip")
+	android.AssertStringListContains(t, "libgnu strip command deps", gnuStrip.RuleParams.CommandDeps,
+		"${config.LinuxGccRoot}/${config.LinuxGccTriple}/bin/strip")
+
+	llvmArgs := result.ModuleForTests("libllvm", "linux_glibc_x86_64_shared").Rule("strip").Args["args"]
+	android.AssertStringDoesNotContain(t, "libllvm strip args", llvmArgs, "--use-gnu-strip")
+
+	testCcError(t, `"libgnu" .*: strip.use_gnu_strip: is only supported for Linux and Darwin host modules`, `
+		cc_library_shared {
+			name: "libgnu",
+			srcs: ["foo.c"],
+			strip: {
+				use_gnu_strip: true,
+			},
+		}`)
+}
+
+func TestLibraryStripKeepDynamicOnly(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			strip: {
+				keep_dynamic_only: true,
+			},
+		}`)
+
+	args := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Rule("strip").Args["args"]
+	android.AssertStringDoesContain(t, "strip args", args, "--keep-dynamic-symbols-only")
+	android.AssertStringDoesNotContain(t, "strip args", args, "--keep-mini-debug-info")
+	android.AssertStringDoesNotContain(t, "strip args", args, "--add-gnu-debuglink")
+}
+
+func TestLibraryCoverageExcludeSrcs(t *testing.T) {
+	t.Parallel()
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.GcovCoverage = proptools.BoolPtr(true)
+			variables.Native_coverage = proptools.BoolPtr(true)
+			variables.NativeCoveragePaths = []string{"*"}
+		}),
+	).RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c", "generated.c"],
+			coverage_exclude_srcs: ["generated.c"],
+		}`)
+
+	for _, variant := range []string{"android_arm64_armv8-a_shared_cov", "android_arm64_armv8-a_static_cov"} {
+		zip := result.ModuleForTests("libfoo", variant).Rule("zip")
+		var inputs []string
+		for _, input := range zip.Inputs {
+			inputs = append(inputs, input.Base())
+		}
+		android.AssertStringListContains(t, variant+" coverage zip", inputs, "foo.gcno")
+		android.AssertStringListDoesNotContain(t, variant+" coverage zip", inputs, "generated.gcno")
+	}
+}
+
+func TestLibraryPreserveCoverageUnderLto(t *testing.T) {
+	t.Parallel()
+	bp := `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			lto: {
+				thin: true,
+			},
+			preserve_coverage_under_lto: true,
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["bar.c"],
+			lto: {
+				thin: true,
+			},
+		}`
+	coverage := func(clang bool) android.FixturePreparer {
+		return android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.GcovCoverage = proptools.BoolPtr(!clang)
+			variables.ClangCoverage = proptools.BoolPtr(clang)
+			variables.Native_coverage = proptools.BoolPtr(true)
+			variables.NativeCoveragePaths = []string{"*"}
+		})
+	}
+
+	// With clang coverage, the unstripped library carrying the coverage mapping is zipped.
+	result := android.GroupFixturePreparers(prepareForCcTest, coverage(true)).RunTestWithBp(t, bp)
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared_cov")
+	android.AssertStringDoesContain(t, "libfoo ldflags", libfoo.Rule("ld").Args["ldFlags"],
+		"-Wl,-z,nostart-stop-gc")
+	android.AssertPathsRelativeToTopEquals(t, "libfoo clang coverage zip", []string{
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared_cov/unstripped/libfoo.so",
+	}, libfoo.Rule("zip").Inputs)
+
+	// With gcov, the gcno files are enough.
+	result = android.GroupFixturePreparers(prepareForCcTest, coverage(false)).RunTestWithBp(t, bp)
+	libfoo = result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared_cov")
+	android.AssertStringDoesContain(t, "libfoo ldflags", libfoo.Rule("ld").Args["ldFlags"],
+		"-Wl,-z,nostart-stop-gc")
+	android.AssertPathsRelativeToTopEquals(t, "libfoo gcov coverage zip", []string{
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared_cov/obj/foo.gcno",
+	}, libfoo.Rule("zip").Inputs)
+
+	libbar := result.ModuleForTests("libbar", "android_arm64_armv8-a_shared_cov")
+	android.AssertStringDoesNotContain(t, "libbar ldflags", libbar.Rule("ld").Args["ldFlags"],
+		"-Wl,-z,nostart-stop-gc")
+	android.AssertPathsRelativeToTopEquals(t, "libbar coverage zip", []string{
+		"out/soong/.intermediates/libbar/android_arm64_armv8-a_shared_cov/obj/bar.gcno",
+	}, libbar.Rule("zip").Inputs)
+}
+
+func TestLibrarySanitizerInfoProvider(t *testing.T) {
+	t.Parallel()
+	result := prepareForCcTest.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			sanitize: {
+				hwaddress: true,
+			},
+		}`)
+
+	hwasan := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared_hwasan").Module()
+	android.AssertDeepEquals(t, "sanitizers of the hwasan variant", []string{"hwaddress"},
+		result.ModuleProvider(hwasan, SanitizerInfoProvider).(SanitizerInfo).Sanitizers)
+
+	// HWASan is only supported on arm64, the arm variant isn't sanitized.
+	arm := result.ModuleForTests("libfoo", "android_arm_armv7-a-neon_shared").Module()
+	android.AssertDeepEquals(t, "sanitizers of the arm variant", []string(nil),
+		result.ModuleProvider(arm, SanitizerInfoProvider).(SanitizerInfo).Sanitizers)
+}
+
+func TestLibraryTidyHeaderFilter(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			tidy_header_filter: "^foo/(include|src)/",
+		}`)
+
+	tidyFlags := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Rule("clangTidy").Args["tidyFlags"]
+	android.AssertStringDoesContain(t, "tidy flags", tidyFlags, `'-header-filter=^foo/(include|src)/'`)
+	android.AssertIntEquals(t, "number of header filters", 1, strings.Count(tidyFlags, "-header-filter="))
+}
+
+func TestLibraryStubSymbolFileProvider(t *testing.T) {
+	t.Parallel()
+	result := prepareForCcTest.RunTestWithBp(t, `
+		cc_library {
+			name: "libstubs",
+			srcs: ["foo.c"],
+			stubs: {
+				symbol_file: "libstubs.map.txt",
+				versions: ["29"],
+			},
+		}
+
+		cc_library {
+			name: "libllndk",
+			llndk: {
+				symbol_file: "libllndk.map.txt",
+			},
+		}`)
+
+	stubs := result.ModuleForTests("libstubs", "android_arm64_armv8-a_shared_29").Module()
+	info := result.ModuleProvider(stubs, StubSymbolFileInfoProvider).(StubSymbolFileInfo)
+	android.AssertPathRelativeToTopEquals(t, "stubs symbol file", "libstubs.map.txt", info.SymbolFile)
+
+	llndk := result.ModuleForTests("libllndk", "android_vendor.29_arm64_armv8-a_shared").Module()
+	info = result.ModuleProvider(llndk, StubSymbolFileInfoProvider).(StubSymbolFileInfo)
+	android.AssertPathRelativeToTopEquals(t, "llndk symbol file", "libllndk.map.txt", info.SymbolFile)
+}
+
+func TestLlndkStubVersion(t *testing.T) {
+	t.Parallel()
+	result := prepareForCcTest.RunTestWithBp(t, `
+		cc_library {
+			name: "libllndk",
+			llndk: {
+				symbol_file: "libllndk.map.txt",
+				stub_version: "29",
+			},
+		}`)
+
+	params := result.ModuleForTests("libllndk", "android_vendor.29_arm64_armv8-a_shared").Rule("genStubSrc")
+	android.AssertStringEquals(t, "llndk stubs api level", "29", params.Args["apiLevel"])
+
+	testCcError(t, `"libllndk" .*: llndk.stub_version: `, `
+		cc_library {
+			name: "libllndk",
+			llndk: {
+				symbol_file: "libllndk.map.txt",
+				stub_version: "foo",
+			},
+		}`)
+}
+
+func TestLibraryStubImplementationInfoProvider(t *testing.T) {
+	t.Parallel()
+	result := prepareForCcTest.RunTestWithBp(t, `
+		cc_library {
+			name: "libnotinstallable",
+			srcs: ["foo.c"],
+			stubs: {
+				symbol_file: "libnotinstallable.map.txt",
+				versions: ["29"],
+				implementation_installable: false,
+			},
+		}
+
+		cc_library {
+			name: "libinstallable",
+			srcs: ["foo.c"],
+			stubs: {
+				symbol_file: "libinstallable.map.txt",
+				versions: ["29"],
+			},
+		}
+
+		cc_library {
+			name: "libnostubs",
+			srcs: ["foo.c"],
+		}`)
+
+	for _, tc := range []struct {
+		name     string
+		required bool
+	}{
+		{"libnotinstallable", false},
+		{"libinstallable", true},
+	} {
+		for _, variant := range []string{"android_arm64_armv8-a_shared", "android_arm64_armv8-a_shared_29"} {
+			m := result.ModuleForTests(tc.name, variant).Module()
+			info := result.ModuleProvider(m, StubImplementationInfoProvider).(StubImplementationInfo)
+			android.AssertBoolEquals(t, tc.name+" "+variant+" implementation required",
+				tc.required, info.ImplementationRequired)
+			android.AssertDeepEquals(t, tc.name+" "+variant+" stubs versions",
+				[]string{"29", "current"}, info.StubsVersions)
+		}
+	}
+
+	noStubs := result.ModuleForTests("libnostubs", "android_arm64_armv8-a_shared").Module()
+	android.AssertBoolEquals(t, "provider without stubs", false,
+		result.ModuleHasProvider(noStubs, StubImplementationInfoProvider))
+}
+
+func TestWholeStaticLibsDiamondDedup(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_static {
+			name: "libbase",
+			srcs: ["base.c"],
+		}
+
+		cc_library_static {
+			name: "libleft",
+			srcs: ["common/foo.c"],
+			whole_static_libs: ["libbase"],
+		}
+
+		cc_library_static {
+			name: "libright",
+			srcs: ["other/foo.c"],
+			whole_static_libs: ["libbase"],
+		}
+
+		cc_library_static {
+			name: "libtop",
+			whole_static_libs: ["libleft", "libright"],
+		}
+	`)
+
+	libtop := result.ModuleForTests("libtop", "android_arm64_armv8-a_static").Rule("ar")
+
+	var baseObjs, fooObjs int
+	for _, obj := range libtop.Inputs.Strings() {
+		switch filepath.Base(obj) {
+		case "base.o":
+			baseObjs++
+		case "foo.o":
+			fooObjs++
+		}
+	}
+	android.AssertIntEquals(t, "copies of base.o", 1, baseObjs)
+	android.AssertIntEquals(t, "distinct foo.o objects", 2, fooObjs)
+
+	warning := result.ModuleForTests("libtop", "android_arm64_armv8-a_static").Output("duplicate_whole_static_lib_objects.timestamp")
+	android.AssertStringDoesContain(t, "duplicates warning",
+		android.StringRelativeToTop(result.Config, warning.Args["message"]),
+		"whole_static_libs includes the same objects more than once, duplicates were dropped: "+
+			"out/soong/.intermediates/libbase/android_arm64_armv8-a_static/obj/base.o")
+	android.AssertPathsRelativeToTopEquals(t, "ar validations",
+		[]string{"out/soong/.intermediates/libtop/android_arm64_armv8-a_static/duplicate_whole_static_lib_objects.timestamp"},
+		libtop.Validations)
+}
+
+func TestLibraryStubsOutputSubdir(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			stubs: {
+				symbol_file: "libfoo.map.txt",
+				versions: ["29"],
+			},
+		}`)
+
+	stubs := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared_29")
+	stubsLib := stubs.Rule("ld").Output
+	android.AssertPathRelativeToTopEquals(t, "stubs output",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared_29/stubs/29/libfoo.so", stubsLib)
+
+	info := result.ModuleProvider(stubs.Module(), SharedLibraryInfoProvider).(SharedLibraryInfo)
+	android.AssertPathRelativeToTopEquals(t, "SharedLibraryInfo.SharedLibrary",
+		android.PathRelativeToTop(stubsLib), info.SharedLibrary)
+
+	impl := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Rule("ld").Output
+	android.AssertPathRelativeToTopEquals(t, "implementation output",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so", impl)
+}
+
+func TestLibraryStubsCheckSymbolFileModeTags(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			stubs: {
+				symbol_file: "libfoo.map.txt",
+				versions: ["29"],
+			},
+		}`)
+
+	stubs := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared_29")
+	check := stubs.Rule("checkSymbolFileModeTags")
+	android.AssertPathRelativeToTopEquals(t, "checked symbol file",
+		"libfoo.map.txt", check.Input)
+
+	genStub := stubs.Rule("genStubSrc")
+	android.AssertStringDoesContain(t, "stub generator flags", genStub.Args["flags"], "--no-ndk")
+	android.AssertPathsRelativeToTopEquals(t, "stub generator validations",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared_29/gen/symbol_file_mode_tags.stamp"},
+		genStub.Validations)
+}
+
+func TestLibraryStubsSymbolFiles(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			stubs: {
+				symbol_files: [
+					"libfoo.map.txt",
+					"libfoo_extra.map.txt",
+				],
+				versions: ["29"],
+			},
+		}`)
+
+	stubs := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared_29")
+	concat := stubs.Output("gen/combined.map.txt")
+	android.AssertPathsRelativeToTopEquals(t, "combined symbol files",
+		[]string{"libfoo.map.txt", "libfoo_extra.map.txt"}, concat.Inputs)
+
+	genStub := stubs.Rule("genStubSrc")
+	android.AssertPathRelativeToTopEquals(t, "stub generator input",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared_29/gen/combined.map.txt",
+		genStub.Input)
+
+	testCcError(t, `"libfoo" .*: symbol_files: can't be used together with symbol_file`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			stubs: {
+				symbol_file: "libfoo.map.txt",
+				symbol_files: ["libfoo_extra.map.txt"],
+				versions: ["29"],
+			},
+		}`)
+}
+
+func TestResolvedStubVersionsProvider(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			stubs: {
+				symbol_file: "libfoo.map.txt",
+				versions: ["29", "30"],
+			},
+		}
+
+		cc_library {
+			name: "libpinned",
+			srcs: ["bar.c"],
+			min_sdk_version: "29",
+			shared_libs: ["libfoo#29"],
+		}
+
+		cc_library {
+			name: "libplatform",
+			srcs: ["baz.c"],
+			shared_libs: ["libfoo"],
+		}`)
+
+	pinned := result.ModuleForTests("libpinned", "android_arm64_armv8-a_shared").Module()
+	info := result.ModuleProvider(pinned, ResolvedStubVersionsProvider).(ResolvedStubVersionsInfo)
+	android.AssertDeepEquals(t, "resolved stub versions of libpinned",
+		map[string]string{"libfoo": "29"}, info.Versions)
+
+	platform := result.ModuleForTests("libplatform", "android_arm64_armv8-a_shared").Module()
+	info = result.ModuleProvider(platform, ResolvedStubVersionsProvider).(ResolvedStubVersionsInfo)
+	android.AssertIntEquals(t, "resolved stub versions of libplatform", 0, len(info.Versions))
+}
+
+func TestLibraryVariantExcludeSrcs(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c", "test_helper.c"],
+			shared: {
+				exclude_srcs: ["test_helper.c"],
+			},
+		}`)
+
+	shared := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	static := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+
+	android.AssertPathsRelativeToTopEquals(t, "shared objects",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/obj/foo.o"},
+		shared.Rule("ld").Inputs)
+	android.AssertPathsRelativeToTopEquals(t, "static objects",
+		[]string{
+			"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/obj/foo.o",
+			"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/obj/test_helper.o",
+		},
+		static.Rule("ar").Inputs)
+}
+
+func TestLibraryWindowsImportLibrary(t *testing.T) {
+	t.Parallel()
+	result := android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithCc,
+		android.FixtureModifyConfig(func(config android.Config) {
+			config.Targets[android.Windows] = []android.Target{
+				{android.Windows, android.Arch{ArchType: android.X86_64}, android.NativeBridgeDisabled, "", "", true},
+			}
+		}),
+	).RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			host_supported: true,
+			device_supported: false,
+			target: {
+				windows: {
+					enabled: true,
+				},
+			},
+		}`)
+
+	windows := result.ModuleForTests("libfoo", "windows_x86_64_shared").Module()
+	info := result.ModuleProvider(windows, SharedLibraryInfoProvider).(SharedLibraryInfo)
+	android.AssertPathRelativeToTopEquals(t, "import library",
+		"out/soong/.intermediates/libfoo/windows_x86_64_shared/libfoo.lib", info.ImportLibrary.Path())
+
+	linux := result.ModuleForTests("libfoo", result.Config.BuildOSTarget.String()+"_shared").Module()
+	info = result.ModuleProvider(linux, SharedLibraryInfoProvider).(SharedLibraryInfo)
+	android.AssertBoolEquals(t, "import library on linux", false, info.ImportLibrary.Valid())
+}
+
+func TestLibraryCheckExportedIncludesNonempty(t *testing.T) {
+	t.Parallel()
+	bp := `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_include_dirs: ["%s"],
+			check_exported_includes_nonempty: true,
+		}`
+	prepare := android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithCc,
+		android.FixtureAddTextFile("include/foo.h", ""),
+		android.FixtureAddTextFile("empty_include/README", ""),
+	)
+
+	t.Run("populated", func(t *testing.T) {
+		prepare.RunTestWithBp(t, fmt.Sprintf(bp, "include"))
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		prepare.ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`export_include_dirs: "empty_include" does not contain any header files`)).
+			RunTestWithBp(t, fmt.Sprintf(bp, "empty_include"))
+	})
+}
+
+func TestLlndkCheckOverrideExportIncludeDirs(t *testing.T) {
+	t.Parallel()
+	bp := `
+		cc_library {
+			name: "libllndk",
+			srcs: ["foo.c"],
+			export_include_dirs: ["include"],
+			llndk: {
+				symbol_file: "libllndk.map.txt",
+				override_export_include_dirs: ["%s"],
+				check_override_export_include_dirs: true,
+			},
+		}`
+	prepare := android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithCc,
+		android.FixtureAddTextFile("include/foo.h", ""),
+		android.FixtureAddTextFile("llndk_include/foo.h", ""),
+	)
+
+	t.Run("existing", func(t *testing.T) {
+		prepare.RunTestWithBp(t, fmt.Sprintf(bp, "llndk_include"))
+	})
+
+	t.Run("mistyped", func(t *testing.T) {
+		// Only the LLNDK variants use the override, the platform variants are fine.
+		pattern := regexp.MustCompile(`module "libllndk" variant "android_(vendor|product)\.29_[^"]*": ` +
+			`llndk.override_export_include_dirs: "llnkd_include" does not exist or does not contain any header files`)
+		prepare.ExtendWithErrorHandler(android.FixtureCustomErrorHandler(func(t *testing.T, result *android.TestResult) {
+			if len(result.Errs) == 0 {
+				t.Fatalf("expected errors for the LLNDK variants")
+			}
+			for _, err := range result.Errs {
+				if !pattern.MatchString(err.Error()) {
+					t.Errorf("unexpected error: %s", err)
+				}
+			}
+		})).RunTestWithBp(t, fmt.Sprintf(bp, "llnkd_include"))
+	})
+}
+
+func TestLibraryEmitSymbolIndex(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			emit_symbol_index: true,
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+	symbolIndex := libfoo.Rule("symbolIndex")
+	android.AssertPathRelativeToTopEquals(t, "symbol index input",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/libfoo.a", symbolIndex.Input)
+	android.AssertPathRelativeToTopEquals(t, "symbol index output",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/libfoo.a.sym", symbolIndex.Output)
+	android.AssertStringDoesContain(t, "symbol index should only list defined symbols",
+		symbolIndex.RuleParams.Command, "--defined-only")
+
+	info := result.ModuleProvider(libfoo.Module(), StaticLibraryInfoProvider).(StaticLibraryInfo)
+	android.AssertPathRelativeToTopEquals(t, "StaticLibraryInfo.SymbolIndex",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/libfoo.a.sym", info.SymbolIndex.Path())
+}
+
+func TestLibraryGcUnreferencedObjects(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c", "unused.c"],
+			gc_unreferenced_objects: true,
+			roots_symbol_file: "roots.txt",
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["bar.c"],
+			static_libs: ["libfoo"],
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+	archive := libfoo.Rule("ar")
+	android.AssertPathRelativeToTopEquals(t, "untrimmed archive",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/untrimmed/libfoo.a", archive.Output)
+	trim := libfoo.Rule("trimArchive")
+	android.AssertPathRelativeToTopEquals(t, "trim input", android.PathRelativeToTop(archive.Output), trim.Input)
+	android.AssertPathRelativeToTopEquals(t, "trim output",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/libfoo.a", trim.Output)
+	android.AssertStringEquals(t, "roots", "roots.txt", trim.Args["roots"])
+	android.AssertPathsRelativeToTopEquals(t, "trim implicits", []string{"roots.txt"}, trim.Implicits)
+
+	info := result.ModuleProvider(libfoo.Module(), StaticLibraryInfoProvider).(StaticLibraryInfo)
+	android.AssertPathRelativeToTopEquals(t, "StaticLibraryInfo.StaticLibrary",
+		android.PathRelativeToTop(trim.Output), info.StaticLibrary)
+	android.AssertStringListContains(t, "libbar links the trimmed archive",
+		android.PathsRelativeToTop(result.ModuleForTests("libbar", "android_arm64_armv8-a_shared").Rule("ld").Implicits),
+		android.PathRelativeToTop(trim.Output))
+
+	testCcError(t, `"libfoo" .*: gc_unreferenced_objects: requires roots_symbol_file`, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			gc_unreferenced_objects: true,
+		}`)
+}
+
+func TestLibraryExportRequiredHeader(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_include_dirs: ["include"],
+			export_required_header: "include/foo_init.h",
+		}
+
+		cc_library {
+			name: "libbar",
+			srcs: ["bar.c"],
+			shared_libs: ["libfoo"],
+		}`)
+
+	cFlags := result.ModuleForTests("libbar", "android_arm64_armv8-a_shared").Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "missing -include of export_required_header",
+		cFlags, "-include include/foo_init.h")
+
+	testCcError(t, `"libfoo" .*: export_required_header: "foo_init.h" is not under any of the exported include directories`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_include_dirs: ["include"],
+			export_required_header: "foo_init.h",
+		}`)
+}
+
+func TestLibraryExportMinCppStd(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.cpp"],
+			export_min_cpp_std: "c++17",
+		}
+
+		cc_library {
+			name: "libbar",
+			srcs: ["bar.cpp"],
+			shared_libs: ["libfoo"],
+		}`)
+
+	header := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Output("gen/min_cpp_std/libfoo_min_cpp_std.h")
+	android.AssertStringDoesContain(t, "min_cpp_std header",
+		android.ContentFromFileRuleForTests(t, result.TestContext, header), "__cplusplus < 201703L")
+
+	compile := result.ModuleForTests("libbar", "android_arm64_armv8-a_shared").Rule("cc")
+	android.AssertStringDoesContain(t, "consumer cflags", compile.Args["cFlags"],
+		"-include "+android.PathRelativeToTop(header.Output))
+	android.AssertStringListContains(t, "consumer order-only deps",
+		android.PathsRelativeToTop(compile.OrderOnly), android.PathRelativeToTop(header.Output))
+
+	testCcError(t, `"libfoo" .*: export_min_cpp_std: "c\+\+98" is not one of c\+\+11, c\+\+14, c\+\+17, c\+\+20`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.cpp"],
+			export_min_cpp_std: "c++98",
+		}`)
+}
+
+func TestLibraryGenerateVersionHeader(t *testing.T) {
+	t.Parallel()
+	result := android.GroupFixturePreparers(
+		PrepareForIntegrationTestWithCc,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.Platform_sdk_version = proptools.IntPtr(34)
+			variables.Platform_version_name = proptools.StringPtr("14")
+		}),
+	).RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			generate_version_header: {
+				name: "foo/version.h",
+				macros: [
+					"FOO_SDK_VERSION=$(PLATFORM_SDK_VERSION)",
+					"FOO_PLATFORM_VERSION=\"$(PLATFORM_VERSION)\"",
+				],
+			},
+		}
+
+		cc_library {
+			name: "libbar",
+			srcs: ["bar.c"],
+			shared_libs: ["libfoo"],
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	header := libfoo.Output("gen/version_header/foo/version.h")
+	content := android.ContentFromFileRuleForTests(t, result.TestContext, header)
+	android.AssertStringDoesContain(t, "version header", content, "#define FOO_SDK_VERSION 34\n")
+	android.AssertStringDoesContain(t, "version header", content, `#define FOO_PLATFORM_VERSION "14"`)
+
+	compile := result.ModuleForTests("libbar", "android_arm64_armv8-a_shared").Rule("cc")
+	android.AssertStringDoesContain(t, "consumer include dirs", compile.Args["cFlags"],
+		"-Iout/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/gen/version_header ")
+	android.AssertStringListContains(t, "consumer order-only deps",
+		android.PathsRelativeToTop(compile.OrderOnly), android.PathRelativeToTop(header.Output))
+
+	testCcError(t, `"libfoo" .*: generate_version_header.macros: unknown variable \$\(BUILD_NUMBER\)`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			generate_version_header: {
+				macros: ["FOO_BUILD=$(BUILD_NUMBER)"],
+			},
+		}`)
+}
+
+func TestLibraryNonSystemIncludeLibs(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_system_include_dirs: ["foo_include"],
+		}
+
+		cc_library {
+			name: "libbar",
+			srcs: ["bar.c"],
+			shared_libs: ["libfoo"],
+			non_system_include_libs: ["libfoo"],
+		}
+
+		cc_library {
+			name: "libbaz",
+			srcs: ["baz.c"],
+			shared_libs: ["libfoo"],
+		}`)
+
+	cFlags := result.ModuleForTests("libbar", "android_arm64_armv8-a_shared").Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "libbar cflags", cFlags, "-Ifoo_include ")
+	android.AssertStringDoesNotContain(t, "libbar cflags", cFlags, "-isystem foo_include")
+
+	cFlags = result.ModuleForTests("libbaz", "android_arm64_armv8-a_shared").Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "libbaz cflags", cFlags, "-isystem foo_include")
+
+	testCcError(t, `"libbar" .*: non_system_include_libs: Library not in header_libs, static_libs or shared_libs: 'libfoo'`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+		}
+
+		cc_library {
+			name: "libbar",
+			srcs: ["bar.c"],
+			non_system_include_libs: ["libfoo"],
+		}`)
+}
+
+func TestLibraryMaxConcurrentCompiles(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c", "bar.cpp"],
+			max_concurrent_compiles: 2,
+		}
+
+		cc_library_static {
+			name: "libbar",
+			srcs: ["bar.cpp"],
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+	for _, obj := range []string{"obj/foo.o", "obj/bar.o"} {
+		android.AssertStringDoesContain(t, "rule for "+obj,
+			libfoo.Output(obj).Rule.String(), "ccLimited2")
+	}
+
+	libbar := result.ModuleForTests("libbar", "android_arm64_armv8-a_static")
+	android.AssertStringDoesNotContain(t, "rule without max_concurrent_compiles",
+		libbar.Output("obj/bar.o").Rule.String(), "ccLimited")
+
+	testCcError(t, `"libfoo" .*: max_concurrent_compiles: must be between 1 and 8, got 0`, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			max_concurrent_compiles: 0,
+		}`)
+}
+
+func TestLibraryVerifyDeterministic(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			verify_deterministic: true,
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+	check := libfoo.Rule("checkDeterministicArchive")
+	android.AssertStringDoesContain(t, "check command", check.RuleParams.Command,
+		"check_deterministic_archive")
+	android.AssertPathsRelativeToTopEquals(t, "check input",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/libfoo.a"}, check.Inputs)
+
+	ar := libfoo.Rule("ar")
+	android.AssertPathsRelativeToTopEquals(t, "archive validations",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/libfoo.a.deterministic.timestamp"},
+		ar.Validations)
+}
+
+func TestLibraryExportCflags(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_cflags: ["-DFOO_EXPORTED=1", "-Wno-foo"],
+			export_defines: ["-UFOO_UNDEFINED"],
+		}
+
+		cc_library {
+			name: "libbar",
+			srcs: ["bar.c"],
+			shared_libs: ["libfoo"],
+		}`)
+
+	cFlags := result.ModuleForTests("libbar", "android_arm64_armv8-a_shared").Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "missing exported define", cFlags, "-DFOO_EXPORTED=1")
+	android.AssertStringDoesContain(t, "missing exported undefine", cFlags, "-UFOO_UNDEFINED")
+	android.AssertStringDoesContain(t, "missing exported warning flag", cFlags, "-Wno-foo")
+
+	testCcError(t, `"libfoo" .*: export_cflags: "-Iinclude": use export_include_dirs`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_cflags: ["-Iinclude"],
+		}`)
+
+	testCcError(t, `"libfoo" .*: export_defines: "-Wall": only -D and -U flags can be exported`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_defines: ["-Wall"],
+		}`)
+}
+
+func TestLibraryPublicConfigFlags(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			public_config_flags: ["-DFOO_CONFIG=1", "-UFOO_LEGACY"],
+		}
+
+		cc_library {
+			name: "libbar",
+			srcs: ["bar.c"],
+			shared_libs: ["libfoo"],
+		}`)
+
+	for _, module := range []string{"libfoo", "libbar"} {
+		cFlags := result.ModuleForTests(module, "android_arm64_armv8-a_shared").Rule("cc").Args["cFlags"]
+		android.AssertStringDoesContain(t, module+" is missing the config define", cFlags, "-DFOO_CONFIG=1")
+		android.AssertStringDoesContain(t, module+" is missing the config undefine", cFlags, "-UFOO_LEGACY")
+	}
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Module()
+	info := result.ModuleProvider(libfoo, FlagExporterInfoProvider).(FlagExporterInfo)
+	android.AssertDeepEquals(t, "exported flags", []string{"-DFOO_CONFIG=1", "-UFOO_LEGACY"}, info.Flags)
+
+	testCcError(t, `"libfoo" .*: public_config_flags: "-O2": only -D and -U flags can be exported`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			public_config_flags: ["-O2"],
+		}`)
+}
+
+func TestLibraryExportConsumerApiLevelMacro(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			min_sdk_version: "29",
+			export_consumer_api_level_macro: "FOO_CLIENT_API_LEVEL",
+		}
+
+		cc_library {
+			name: "libbar29",
+			srcs: ["bar.c"],
+			min_sdk_version: "29",
+			shared_libs: ["libfoo"],
+		}
+
+		cc_library {
+			name: "libbar30",
+			srcs: ["bar.c"],
+			min_sdk_version: "30",
+			shared_libs: ["libfoo"],
+		}`)
+
+	for module, expected := range map[string]string{
+		"libbar29": "-DFOO_CLIENT_API_LEVEL=29",
+		"libbar30": "-DFOO_CLIENT_API_LEVEL=30",
+	} {
+		cFlags := result.ModuleForTests(module, "android_arm64_armv8-a_shared").Rule("cc").Args["cFlags"]
+		android.AssertStringDoesContain(t, module+" consumer api level define", cFlags, expected)
+	}
+
+	cFlags := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Rule("cc").Args["cFlags"]
+	android.AssertStringDoesNotContain(t, "libfoo defines its own consumer api level", cFlags,
+		"-DFOO_CLIENT_API_LEVEL=")
+
+	testCcError(t, `"libfoo" .*: export_consumer_api_level_macro: "FOO-API" is not a valid macro name`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_consumer_api_level_macro: "FOO-API",
+		}`)
+}
+
+func TestLibraryPlatformOnly(t *testing.T) {
+	t.Parallel()
+	ctx := testCc(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			platform_only: true,
+		}`)
+
+	for _, variant := range ctx.ModuleVariantsForTests("libfoo") {
+		if strings.Contains(variant, "vendor") || strings.Contains(variant, "product") ||
+			strings.Contains(variant, "recovery") {
+			t.Errorf("unexpected variant %q of a platform_only library", variant)
+		}
+	}
+
+	testCcError(t, `dependency "libfoo" of "libvendor" missing variant`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			platform_only: true,
+		}
+
+		cc_library {
+			name: "libvendor",
+			srcs: ["bar.c"],
+			vendor: true,
+			shared_libs: ["libfoo"],
+		}`)
+
+	testCcError(t, `"libfoo" .*: platform_only: doesn't make sense at the same time as .vendor_available.`, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			vendor_available: true,
+			platform_only: true,
+		}`)
+}
+
+func TestLibraryEmitBitcode(t *testing.T) {
+	t.Parallel()
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			lto: {
+				thin: true,
+			},
+			emit_bitcode: true,
+		}`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+	bitcode := libfoo.Output("libfoo.bc.a")
+	android.AssertPathsRelativeToTopEquals(t, "bitcode archive inputs",
+		[]string{"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/obj/foo.o"}, bitcode.Inputs)
+	android.AssertStringDoesContain(t, "objects should be compiled to bitcode",
+		libfoo.Output("obj/foo.o").Args["cFlags"], "-flto=thin")
+
+	info := result.ModuleProvider(libfoo.Module(), StaticLibraryInfoProvider).(StaticLibraryInfo)
+	android.AssertPathRelativeToTopEquals(t, "StaticLibraryInfo.BitcodeArchive",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_static/libfoo.bc.a", info.BitcodeArchive.Path())
+
+	testCcError(t, `"libfoo" .*: emit_bitcode: requires .lto: \{ thin: true \}.`, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			emit_bitcode: true,
+		}`)
+}
+
 func TestWholeStaticLibPrebuilts(t *testing.T) {
 	t.Parallel()
 	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
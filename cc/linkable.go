@@ -364,6 +364,12 @@ type SharedLibraryInfo struct {
 
 	TableOfContents android.OptionalPath
 
+	// The import library (.lib) to link against the DLL, only set on Windows.
+	ImportLibrary android.OptionalPath
+
+	// The objects linked into SharedLibrary, excluding those of static library dependencies.
+	Objects Objects
+
 	// should be obtained from static analogue
 	TransitiveStaticLibrariesForOrdering *android.DepSet[android.Path]
 }
@@ -393,12 +399,118 @@ type SharedLibraryStubsInfo struct {
 
 var SharedLibraryStubsProvider = blueprint.NewProvider(SharedLibraryStubsInfo{})
 
+// StubSymbolFileInfo is a provider to propagate the symbol file (.map.txt) that the stubs of a
+// library are generated from, so that consumers can run their own API checks against it.
+type StubSymbolFileInfo struct {
+	// The stubs.symbol_file, llndk.symbol_file or vendor_public_library.symbol_file of the
+	// library, depending on the variant.
+	SymbolFile android.Path
+}
+
+var StubSymbolFileInfoProvider = blueprint.NewProvider(StubSymbolFileInfo{})
+
+// StubImplementationInfo is a provider set on the shared variants of a library with stubs, so
+// that packaging logic such as APEX can decide whether the implementation library has to be
+// installed without having to know about the library's properties.
+type StubImplementationInfo struct {
+	// Whether the implementation library has to be installed when a module links against its
+	// stubs, i.e. stubs.implementation_installable is not false.
+	ImplementationRequired bool
+
+	// The versions of the stubs of the library.
+	StubsVersions []string
+}
+
+var StubImplementationInfoProvider = blueprint.NewProvider(StubImplementationInfo{})
+
+// ReexportedHeaderLibsInfo is a provider listing the header libraries whose headers a module
+// reexports to its dependents, after the partition-specific exclusions have been applied.
+type ReexportedHeaderLibsInfo struct {
+	// The names of the reexported header libraries.
+	HeaderLibs []string
+}
+
+var ReexportedHeaderLibsInfoProvider = blueprint.NewProvider(ReexportedHeaderLibsInfo{})
+
+// ResolvedStubVersionsInfo is a provider recording, for each shared library dependency of a module
+// that was linked against a stub variant, the version of the stub that was selected. It allows
+// auditing that modules in an APEX only use APIs available at their min_sdk_version.
+type ResolvedStubVersionsInfo struct {
+	// Map from the name of a shared library dependency to the version of its stubs that the
+	// module links against. Dependencies linked against the implementation are not listed.
+	Versions map[string]string
+}
+
+var ResolvedStubVersionsProvider = blueprint.NewProvider(ResolvedStubVersionsInfo{})
+
+// ExportedSymbolListInfo is a provider to propagate the list of symbols that a shared library
+// exports through its version script, so that LTO-aware consumers can internalize the others.
+type ExportedSymbolListInfo struct {
+	// A file listing the symbols of the global sections of the version script, one per line.
+	SymbolList android.Path
+}
+
+var ExportedSymbolListInfoProvider = blueprint.NewProvider(ExportedSymbolListInfo{})
+
+// ImportedSymbolListInfo is a provider to propagate the list of the undefined dynamic symbols of
+// a shared library with emit_imports, so that packaging tools can check that they are provided.
+type ImportedSymbolListInfo struct {
+	// A file listing the symbols imported by the shared library, one per line.
+	SymbolList android.Path
+}
+
+var ImportedSymbolListInfoProvider = blueprint.NewProvider(ImportedSymbolListInfo{})
+
+// SanitizerInfo is a provider set on the static and shared variants of a library, so that
+// packaging logic such as APEX can tell sanitized and unsanitized builds of the library apart.
+type SanitizerInfo struct {
+	// The names of the sanitizers enabled for the variant, e.g. "hwaddress", or empty if the
+	// variant isn't sanitized.
+	Sanitizers []string
+}
+
+var SanitizerInfoProvider = blueprint.NewProvider(SanitizerInfo{})
+
+// DistArtifactInfo is a provider set on the static and shared variants of a library to tell which
+// file is copied to the dist directory for the library.
+type DistArtifactInfo struct {
+	// The file copied to the dist directory, e.g. the versioned copy of the library with
+	// use_version_lib, or the output of the library otherwise.
+	DistFile android.Path
+}
+
+var DistArtifactInfoProvider = blueprint.NewProvider(DistArtifactInfo{})
+
+// SourceAbiDumpInfo is a provider to propagate the ABI dump (.lsdump) of a shared library, set
+// when the header ABI checker creates one.
+type SourceAbiDumpInfo struct {
+	// The ABI dump linked from the dumps of the sources of the library.
+	LinkedDump android.Path
+}
+
+var SourceAbiDumpInfoProvider = blueprint.NewProvider(SourceAbiDumpInfo{})
+
 // StaticLibraryInfo is a provider to propagate information about a static C++ library.
 type StaticLibraryInfo struct {
 	StaticLibrary android.Path
 	Objects       Objects
 	ReuseObjects  Objects
 
+	// A hash of the flags ReuseObjects were compiled with, only set if the
+	// SOONG_CHECK_REUSED_OBJECTS environment variable is true.
+	ReuseObjectsFlagsHash string
+
+	// The sorted list of global symbols defined by StaticLibrary, only set if
+	// emit_symbol_index is set.
+	SymbolIndex android.OptionalPath
+
+	// An archive of the LLVM bitcode objects of StaticLibrary, only set if emit_bitcode is set.
+	BitcodeArchive android.OptionalPath
+
+	// Whether APEX variants of binaries and shared libraries should link StaticLibrary with
+	// -Wl,--whole-archive even when it is a regular static_libs dependency.
+	AlwayslinkInApex bool
+
 	// A static library may contain prebuilt static libraries included with whole_static_libs
 	// that won't appear in Objects.  They are transitively available in
 	// WholeStaticLibsFromPrebuilts.
@@ -427,6 +539,13 @@ type FlagExporterInfo struct {
 	Flags             []string      // Exported raw flags.
 	Deps              android.Paths
 	GeneratedHeaders  android.Paths
+
+	// The subset of IncludeDirs that is deprecated, modules depending on the library get a warning.
+	DeprecatedIncludeDirs android.Paths
+
+	// Names of macros that each module depending on the library directly defines to the API level
+	// of its own min_sdk_version.
+	ConsumerApiLevelMacros []string
 }
 
 var FlagExporterInfoProvider = blueprint.NewProvider(FlagExporterInfo{})
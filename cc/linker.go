@@ -87,6 +87,11 @@ type BaseLinkerProperties struct {
 	// present in generated_headers.
 	Export_generated_headers []string `android:"arch_variant"`
 
+	// list of header, static or shared libraries whose exported system include directories are
+	// passed to this module with -I instead of -isystem, so that the warnings in their headers
+	// are not suppressed. Entries must be present in header_libs, static_libs or shared_libs.
+	Non_system_include_libs []string `android:"arch_variant"`
+
 	// don't link in crt_begin and crt_end.  This flag should only be necessary for
 	// compiling crt or libc.
 	Nocrt *bool `android:"arch_variant"`
@@ -230,7 +235,10 @@ type BaseLinkerProperties struct {
 	// local file name to pass to the linker as --version-script
 	Version_script *string `android:"path,arch_variant"`
 
-	// local file name to pass to the linker as --dynamic-list
+	// local file name to pass to the linker as --dynamic-list. This can be used instead of a
+	// full version_script to control the dynamic symbols of a library that links in static
+	// dependencies. If both are set, both are passed to the linker; symbols made local by the
+	// version script stay local even if they are listed in the dynamic list.
 	Dynamic_list *string `android:"path,arch_variant"`
 
 	// local files to pass to the linker as --script
@@ -290,6 +298,7 @@ func (linker *baseLinker) linkerDeps(ctx DepsContext, deps Deps) Deps {
 	deps.ReexportStaticLibHeaders = append(deps.ReexportStaticLibHeaders, linker.Properties.Export_static_lib_headers...)
 	deps.ReexportSharedLibHeaders = append(deps.ReexportSharedLibHeaders, linker.Properties.Export_shared_lib_headers...)
 	deps.ReexportGeneratedHeaders = append(deps.ReexportGeneratedHeaders, linker.Properties.Export_generated_headers...)
+	deps.NonSystemIncludeLibs = append(deps.NonSystemIncludeLibs, linker.Properties.Non_system_include_libs...)
 
 	deps.SharedLibs = removeListFromList(deps.SharedLibs, linker.Properties.Exclude_shared_libs)
 	deps.StaticLibs = removeListFromList(deps.StaticLibs, linker.Properties.Exclude_static_libs)
@@ -322,6 +331,7 @@ func (linker *baseLinker) linkerDeps(ctx DepsContext, deps Deps) Deps {
 		deps.StaticLibs = removeListFromList(deps.StaticLibs, linker.Properties.Target.Vendor.Exclude_static_libs)
 		deps.HeaderLibs = append(deps.HeaderLibs, linker.Properties.Target.Vendor.Header_libs...)
 		deps.HeaderLibs = removeListFromList(deps.HeaderLibs, linker.Properties.Target.Vendor.Exclude_header_libs)
+		deps.ReexportHeaderLibHeaders = removeListFromList(deps.ReexportHeaderLibHeaders, linker.Properties.Target.Vendor.Exclude_header_libs)
 		deps.ReexportStaticLibHeaders = removeListFromList(deps.ReexportStaticLibHeaders, linker.Properties.Target.Vendor.Exclude_static_libs)
 		deps.WholeStaticLibs = removeListFromList(deps.WholeStaticLibs, linker.Properties.Target.Vendor.Exclude_static_libs)
 		deps.RuntimeLibs = removeListFromList(deps.RuntimeLibs, linker.Properties.Target.Vendor.Exclude_runtime_libs)
@@ -334,6 +344,7 @@ func (linker *baseLinker) linkerDeps(ctx DepsContext, deps Deps) Deps {
 		deps.StaticLibs = append(deps.StaticLibs, linker.Properties.Target.Product.Static_libs...)
 		deps.StaticLibs = removeListFromList(deps.StaticLibs, linker.Properties.Target.Product.Exclude_static_libs)
 		deps.HeaderLibs = removeListFromList(deps.HeaderLibs, linker.Properties.Target.Product.Exclude_header_libs)
+		deps.ReexportHeaderLibHeaders = removeListFromList(deps.ReexportHeaderLibHeaders, linker.Properties.Target.Product.Exclude_header_libs)
 		deps.ReexportStaticLibHeaders = removeListFromList(deps.ReexportStaticLibHeaders, linker.Properties.Target.Product.Exclude_static_libs)
 		deps.WholeStaticLibs = removeListFromList(deps.WholeStaticLibs, linker.Properties.Target.Product.Exclude_static_libs)
 		deps.RuntimeLibs = removeListFromList(deps.RuntimeLibs, linker.Properties.Target.Product.Exclude_runtime_libs)
@@ -534,19 +545,7 @@ func (linker *baseLinker) linkerFlags(ctx ModuleContext, flags Flags) Flags {
 	// Version_script is not needed when linking stubs lib where the version
 	// script is created from the symbol map file.
 	if !linker.dynamicProperties.BuildStubs {
-		versionScript := ctx.ExpandOptionalSource(
-			linker.Properties.Version_script, "version_script")
-
-		if ctx.inVendor() && linker.Properties.Target.Vendor.Version_script != nil {
-			versionScript = ctx.ExpandOptionalSource(
-				linker.Properties.Target.Vendor.Version_script,
-				"target.vendor.version_script")
-		} else if ctx.inProduct() && linker.Properties.Target.Product.Version_script != nil {
-			versionScript = ctx.ExpandOptionalSource(
-				linker.Properties.Target.Product.Version_script,
-				"target.product.version_script")
-		}
-
+		versionScript := linker.versionScript(ctx)
 		if versionScript.Valid() {
 			if ctx.Darwin() {
 				ctx.PropertyErrorf("version_script", "Not supported on Darwin")
@@ -590,6 +589,21 @@ func (linker *baseLinker) linkerFlags(ctx ModuleContext, flags Flags) Flags {
 	return flags
 }
 
+// versionScript returns the version_script of the module, taking the vendor and product
+// overrides into account.
+func (linker *baseLinker) versionScript(ctx ModuleContext) android.OptionalPath {
+	if ctx.inVendor() && linker.Properties.Target.Vendor.Version_script != nil {
+		return ctx.ExpandOptionalSource(
+			linker.Properties.Target.Vendor.Version_script,
+			"target.vendor.version_script")
+	} else if ctx.inProduct() && linker.Properties.Target.Product.Version_script != nil {
+		return ctx.ExpandOptionalSource(
+			linker.Properties.Target.Product.Version_script,
+			"target.product.version_script")
+	}
+	return ctx.ExpandOptionalSource(linker.Properties.Version_script, "version_script")
+}
+
 // RpathFlags returns the rpath linker flags for current target to search the following directories relative
 // to the binary:
 //
@@ -36,6 +36,10 @@ type llndkLibraryProperties struct {
 	// Whether the system library uses symbol versions.
 	Unversioned *bool
 
+	// The API level to generate the LLNDK stubs for, e.g. "34", instead of the one derived from
+	// the VNDK version of the device or the stubs version of the variant.
+	Stub_version *string
+
 	// list of llndk headers to re-export include directories from.
 	Export_llndk_headers []string
 
@@ -44,6 +48,10 @@ type llndkLibraryProperties struct {
 	// any that were listed outside the llndk clause.
 	Override_export_include_dirs []string
 
+	// whether to check that each of override_export_include_dirs exists and contains header
+	// files, so that a mistyped directory doesn't silently export nothing to vendor modules.
+	Check_override_export_include_dirs *bool
+
 	// whether this module can be directly depended upon by libs that are installed
 	// to /vendor and /product.
 	// When set to true, this module can only be depended on by VNDK libraries, not
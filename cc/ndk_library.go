@@ -30,6 +30,8 @@ import (
 
 func init() {
 	pctx.HostBinToolVariable("ndkStubGenerator", "ndkstubgen")
+	pctx.HostBinToolVariable("checkSymbolFileModeTags", "check_symbol_file_mode_tags")
+	pctx.HostBinToolVariable("concatSymbolFiles", "concat_symbol_files")
 	pctx.HostBinToolVariable("stg", "stg")
 	pctx.HostBinToolVariable("stgdiff", "stgdiff")
 }
@@ -42,6 +44,22 @@ var (
 			CommandDeps: []string{"$ndkStubGenerator"},
 		}, "arch", "apiLevel", "apiMap", "flags")
 
+	// Fails if any exported symbol of the symbol file lacks an apex, systemapi
+	// or llndk tag, listing the offending symbols by name and line.
+	checkSymbolFileModeTagsRule = pctx.AndroidStaticRule("checkSymbolFileModeTags",
+		blueprint.RuleParams{
+			Command:     "$checkSymbolFileModeTags --arch $arch --api-map $apiMap --stamp $out $in",
+			CommandDeps: []string{"$checkSymbolFileModeTags"},
+		}, "arch", "apiMap")
+
+	// Combines symbol file fragments into a single symbol file, failing if a version or a
+	// symbol is defined by more than one fragment.
+	concatSymbolFilesRule = pctx.AndroidStaticRule("concatSymbolFiles",
+		blueprint.RuleParams{
+			Command:     "$concatSymbolFiles --arch $arch --api-map $apiMap --output $out $in",
+			CommandDeps: []string{"$concatSymbolFiles"},
+		}, "arch", "apiMap")
+
 	// $headersList should include paths to public headers. All types
 	// that are defined outside of public headers will be excluded from
 	// ABI monitoring.
@@ -248,12 +266,11 @@ type ndkApiOutputs struct {
 	symbolList    android.ModuleGenPath
 }
 
-func parseNativeAbiDefinition(ctx ModuleContext, symbolFile string,
-	apiLevel android.ApiLevel, genstubFlags string) ndkApiOutputs {
+func parseNativeAbiDefinition(ctx ModuleContext, symbolFilePath android.Path,
+	apiLevel android.ApiLevel, genstubFlags string, validations android.Paths) ndkApiOutputs {
 
 	stubSrcPath := android.PathForModuleGen(ctx, "stub.c")
 	versionScriptPath := android.PathForModuleGen(ctx, "stub.map")
-	symbolFilePath := android.PathForModuleSrc(ctx, symbolFile)
 	symbolListPath := android.PathForModuleGen(ctx, "abi_symbol_list.txt")
 	apiLevelsJson := android.GetApiLevelsJson(ctx)
 	ctx.Build(pctx, android.BuildParams{
@@ -261,8 +278,9 @@ func parseNativeAbiDefinition(ctx ModuleContext, symbolFile string,
 		Description: "generate stubs " + symbolFilePath.Rel(),
 		Outputs: []android.WritablePath{stubSrcPath, versionScriptPath,
 			symbolListPath},
-		Input:     symbolFilePath,
-		Implicits: []android.Path{apiLevelsJson},
+		Input:       symbolFilePath,
+		Implicits:   []android.Path{apiLevelsJson},
+		Validations: validations,
 		Args: map[string]string{
 			"arch":     ctx.Arch().ArchType.String(),
 			"apiLevel": apiLevel.String(),
@@ -278,6 +296,45 @@ func parseNativeAbiDefinition(ctx ModuleContext, symbolFile string,
 	}
 }
 
+// checkSymbolFileModeTags builds a rule that validates that every symbol of the
+// symbol file is tagged with a mode tag, and returns its stamp file. Stubs
+// generated with --no-ndk otherwise silently omit the untagged symbols.
+func checkSymbolFileModeTags(ctx ModuleContext, symbolFilePath android.Path) android.Path {
+	stampPath := android.PathForModuleGen(ctx, "symbol_file_mode_tags.stamp")
+	apiLevelsJson := android.GetApiLevelsJson(ctx)
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        checkSymbolFileModeTagsRule,
+		Description: "check mode tags " + symbolFilePath.Rel(),
+		Output:      stampPath,
+		Input:       symbolFilePath,
+		Implicit:    apiLevelsJson,
+		Args: map[string]string{
+			"arch":   ctx.Arch().ArchType.String(),
+			"apiMap": apiLevelsJson.String(),
+		},
+	})
+	return stampPath
+}
+
+// concatSymbolFiles builds a rule that combines the given symbol file fragments into a single
+// symbol file, and returns it.
+func concatSymbolFiles(ctx ModuleContext, symbolFiles android.Paths) android.Path {
+	combinedPath := android.PathForModuleGen(ctx, "combined.map.txt")
+	apiLevelsJson := android.GetApiLevelsJson(ctx)
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        concatSymbolFilesRule,
+		Description: "combine symbol files",
+		Output:      combinedPath,
+		Inputs:      symbolFiles,
+		Implicit:    apiLevelsJson,
+		Args: map[string]string{
+			"arch":   ctx.Arch().ArchType.String(),
+			"apiMap": apiLevelsJson.String(),
+		},
+	})
+	return combinedPath
+}
+
 func compileStubLibrary(ctx ModuleContext, flags Flags, src android.Path) Objects {
 	// libc/libm stubs libraries end up mismatching with clang's internal definition of these
 	// functions (which have noreturn attributes and other things). Because we just want to create a
@@ -463,8 +520,8 @@ func (c *stubDecorator) compile(ctx ModuleContext, flags Flags, deps PathDeps) O
 		return Objects{}
 	}
 
-	symbolFile := String(c.properties.Symbol_file)
-	nativeAbiResult := parseNativeAbiDefinition(ctx, symbolFile, c.apiLevel, "")
+	symbolFile := android.PathForModuleSrc(ctx, String(c.properties.Symbol_file))
+	nativeAbiResult := parseNativeAbiDefinition(ctx, symbolFile, c.apiLevel, "", nil)
 	objs := compileStubLibrary(ctx, flags, nativeAbiResult.stubSrc)
 	c.versionScriptPath = nativeAbiResult.versionScript
 	if canDumpAbi(ctx.Config()) {
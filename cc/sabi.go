@@ -39,21 +39,75 @@ type headerAbiCheckerProperties struct {
 	Exclude_symbol_versions []string
 
 	// Symbol tags that should be ignored from the symbol file
-	Exclude_symbol_tags []string
+	Exclude_symbol_tags []string `android:"arch_variant"`
 
 	// Run checks on all APIs (in addition to the ones referred by
 	// one of exported ELF symbols.)
 	Check_all_apis *bool
 
 	// Extra flags passed to header-abi-diff
-	Diff_flags []string
+	Diff_flags []string `android:"arch_variant"`
 
 	// Opt-in reference dump directories
 	Ref_dump_dirs []string
+
+	// Opt-in reference dump directories for specific products. If there is an entry for the
+	// product being built, its dirs are used instead of ref_dump_dirs.
+	Ref_dump_dirs_per_product []refDumpDirsForProduct
+
+	// API level of the reference dump that the cross-version ABI check compares against,
+	// overriding the previous finalized version. Useful when the ABI was intentionally reset
+	// after that version. Ignored for VNDK libraries, which have no cross-version check. A build
+	// warning is printed if there is no reference dump for that version.
+	Previous_version *string
+
+	// If true, the versioned reference dumps of this library are stored without the binder
+	// bitness subdirectory, e.g. prebuilts/abi-dumps/platform/<version>/<arch>/, for
+	// libraries whose ABI does not depend on the binder bitness.
+	Binder_bitness_independent *bool
+
+	// If true, the ABI dump of this library is created like for enabled, but it isn't diffed
+	// against any reference dump. Useful to collect the dump for storage. Has no effect if
+	// enabled is explicitly false.
+	Dump_only *bool
+
+	// If true, a JSON summary of the exported functions, variables and types in the ABI dump is
+	// written too, for tools that don't parse the dump themselves. The summary is available as
+	// the ":<module>{.abi_summary}" output.
+	Emit_json_summary *bool
+
+	// If true, the ABI dump of this library is only linked and diffed for the primary arch, for
+	// libraries whose ABI is the same for all arches. The reference dumps of the other arches
+	// are then not checked nor updated, so don't set it if the ABI depends on the arch, e.g.
+	// through type sizes or arch-specific export_include_dirs, which aren't detected. Arches are
+	// only skipped if the library is built for the primary arch.
+	Primary_arch_only *bool
+}
+
+// refDumpDirsForProduct lists the opt-in reference dump directories of one product.
+type refDumpDirsForProduct struct {
+	// Name of the product, as in TARGET_PRODUCT.
+	Product *string
+
+	// Opt-in reference dump directories for the product.
+	Dirs []string
+}
+
+// refDumpDirs returns the opt-in reference dump directories for the product being built,
+// falling back to Ref_dump_dirs if the product has no entry in Ref_dump_dirs_per_product.
+func (props *headerAbiCheckerProperties) refDumpDirs(config android.Config) []string {
+	if config.HasDeviceProduct() {
+		for _, entry := range props.Ref_dump_dirs_per_product {
+			if String(entry.Product) == config.DeviceProduct() {
+				return entry.Dirs
+			}
+		}
+	}
+	return props.Ref_dump_dirs
 }
 
 func (props *headerAbiCheckerProperties) enabled() bool {
-	return Bool(props.Enabled)
+	return Bool(props.Enabled) || Bool(props.Dump_only)
 }
 
 func (props *headerAbiCheckerProperties) explicitlyDisabled() bool {
@@ -1125,6 +1125,18 @@ func (s *sanitize) isSanitizerEnabled(t SanitizerType) bool {
 	return sanitizerVal != nil && *sanitizerVal == true
 }
 
+// enabledSanitizers returns the names of the sanitizers enabled for the module, in the order of
+// Sanitizers.
+func (s *sanitize) enabledSanitizers() []string {
+	var ret []string
+	for _, t := range Sanitizers {
+		if s.isSanitizerEnabled(t) {
+			ret = append(ret, t.name())
+		}
+	}
+	return ret
+}
+
 // IsSanitizableDependencyTag returns true if the dependency tag is sanitizable.
 func IsSanitizableDependencyTag(tag blueprint.DependencyTag) bool {
 	switch t := tag.(type) {
@@ -41,6 +41,17 @@ type StripProperties struct {
 
 		// keep_symbols_and_debug_frame enables stripping but keeps all symbols and debug frames.
 		Keep_symbols_and_debug_frame *bool `android:"arch_variant"`
+
+		// keep_dynamic_only enables stripping of everything that isn't needed to link against
+		// or load the module, including the symbol table and the mini debug info, but keeps the
+		// dynamic symbol table (.dynsym and .dynstr).
+		Keep_dynamic_only *bool `android:"arch_variant"`
+
+		// use_gnu_strip selects GNU strip instead of llvm-strip, e.g. to work around llvm-strip
+		// bugs with specific sections. It is only supported for host modules, which are stripped
+		// with the strip of the host GCC prebuilts on Linux and the system strip on Darwin.
+		// Defaults to true on Darwin and false elsewhere.
+		Use_gnu_strip *bool `android:"arch_variant"`
 	} `android:"arch_variant"`
 }
 
@@ -55,13 +66,22 @@ func (stripper *Stripper) NeedsStrip(actx android.ModuleContext) bool {
 	defaultEnable := (!actx.Config().KatiEnabled() || actx.Device())
 	forceEnable := Bool(stripper.StripProperties.Strip.All) ||
 		Bool(stripper.StripProperties.Strip.Keep_symbols) ||
-		Bool(stripper.StripProperties.Strip.Keep_symbols_and_debug_frame)
+		Bool(stripper.StripProperties.Strip.Keep_symbols_and_debug_frame) ||
+		Bool(stripper.StripProperties.Strip.Keep_dynamic_only)
 	return !forceDisable && (forceEnable || defaultEnable)
 }
 
 func (stripper *Stripper) strip(actx android.ModuleContext, in android.Path, out android.ModuleOutPath,
 	flags StripFlags, isStaticLib bool) {
-	if actx.Darwin() {
+	if useGnuStrip := stripper.StripProperties.Strip.Use_gnu_strip; useGnuStrip != nil {
+		if *useGnuStrip && !(actx.Host() && (actx.Os() == android.Linux || actx.Darwin())) {
+			actx.PropertyErrorf("strip.use_gnu_strip", "is only supported for Linux and Darwin host modules")
+		}
+		flags.StripUseGnuStrip = *useGnuStrip
+	} else if actx.Darwin() {
+		flags.StripUseGnuStrip = true
+	}
+	if actx.Darwin() && flags.StripUseGnuStrip {
 		transformDarwinStrip(actx, in, out)
 	} else {
 		if Bool(stripper.StripProperties.Strip.Keep_symbols) {
@@ -70,10 +90,12 @@ func (stripper *Stripper) strip(actx android.ModuleContext, in android.Path, out
 			flags.StripKeepSymbolsAndDebugFrame = true
 		} else if len(stripper.StripProperties.Strip.Keep_symbols_list) > 0 {
 			flags.StripKeepSymbolsList = strings.Join(stripper.StripProperties.Strip.Keep_symbols_list, ",")
+		} else if Bool(stripper.StripProperties.Strip.Keep_dynamic_only) {
+			flags.StripKeepDynamicOnly = true
 		} else if !Bool(stripper.StripProperties.Strip.All) {
 			flags.StripKeepMiniDebugInfo = true
 		}
-		if actx.Config().Debuggable() && !flags.StripKeepMiniDebugInfo && !isStaticLib {
+		if actx.Config().Debuggable() && !flags.StripKeepMiniDebugInfo && !flags.StripKeepDynamicOnly && !isStaticLib {
 			flags.StripAddGnuDebuglink = true
 		}
 		transformStrip(actx, in, out, flags)
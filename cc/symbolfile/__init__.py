@@ -108,6 +108,7 @@ class Symbol:
 
     name: str
     tags: Tags
+    line: int = field(default=0, compare=False)
 
 
 @dataclass
@@ -321,6 +322,56 @@ def symbol_versioned_in_api(tags: Iterable[Tag], api: int) -> bool:
     return True
 
 
+def find_symbols_without_mode_tags(versions: Iterable[Version]) -> List[Symbol]:
+    """Returns the symbols that are not tagged with any mode tag.
+
+    Symbols without mode tags are NDK symbols. They are silently dropped from
+    the stub when NDK symbols are filtered out (--no-ndk), so libraries that
+    build their stubs that way must tag every exported symbol explicitly.
+    Private and platform-only versions are never part of a stub and are
+    skipped.
+    """
+    untagged = []
+    for version in versions:
+        if version.is_private or version.tags.has_platform_only_tags:
+            continue
+        for symbol in version.symbols:
+            if not symbol.tags.has_mode_tags:
+                untagged.append(symbol)
+    return untagged
+
+
+def find_duplicate_definitions(fragments: Iterable[Tuple[str, List[Version]]],
+                               filt: Filter) -> List[str]:
+    """Returns errors for the versions and symbols defined by more than one of
+    the given (path, versions) symbol file fragments.
+
+    Versions are compared regardless of the filter, as every fragment is
+    concatenated into the same symbol file. Symbols omitted by the filter are
+    skipped.
+    """
+    version_sources: Dict[str, str] = {}
+    symbol_sources: Dict[str, str] = {}
+    errors = []
+    for path, versions in fragments:
+        for version in versions:
+            if version.name in version_sources:
+                errors.append(f'{path}: error: version "{version.name}" is '
+                              f'also defined in {version_sources[version.name]}')
+            version_sources.setdefault(version.name, path)
+            if filt.should_omit_version(version):
+                continue
+            for symbol in version.symbols:
+                if filt.should_omit_symbol(symbol):
+                    continue
+                if symbol.name in symbol_sources:
+                    errors.append(f'{path}:{symbol.line}: error: symbol '
+                                  f'"{symbol.name}" is also defined in '
+                                  f'{symbol_sources[symbol.name]}')
+                symbol_sources.setdefault(symbol.name, path)
+    return errors
+
+
 class ParseError(RuntimeError):
     """An error that occurred while parsing a symbol file."""
 
@@ -341,6 +392,7 @@ class SymbolFileParser:
         self.api_map = api_map
         self.filter = filt
         self.current_line: Optional[str] = None
+        self.line_number = 0
 
     def parse(self) -> List[Version]:
         """Parses the symbol file and returns a list of Version objects."""
@@ -434,7 +486,7 @@ class SymbolFileParser:
         # Line is now in the format "<symbol-name>; # tags"
         name, _, _ = self.current_line.strip().partition(';')
         tags = get_tags(self.current_line, self.api_map)
-        return Symbol(name, tags)
+        return Symbol(name, tags, self.line_number)
 
     def next_line(self) -> str:
         """Returns the next non-empty non-comment line.
@@ -442,8 +494,10 @@ class SymbolFileParser:
         A return value of '' indicates EOF.
         """
         line = self.input_file.readline()
+        self.line_number += 1
         while not line.strip() or line.strip().startswith('#'):
             line = self.input_file.readline()
+            self.line_number += 1
 
             # We want to skip empty lines, but '' indicates EOF.
             if not line:
@@ -0,0 +1,88 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2023 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Checks that every symbol of a symbol file is tagged with a mode tag.
+
+Stubs of non-NDK libraries are generated with --no-ndk, which silently drops
+every symbol that is not tagged with apex, systemapi or llndk.
+"""
+import argparse
+import json
+from pathlib import Path
+import sys
+
+import symbolfile
+
+
+def parse_args() -> argparse.Namespace:
+    """Parses and returns command line arguments."""
+    parser = argparse.ArgumentParser(description=__doc__)
+
+    def resolved_path(raw: str) -> Path:
+        """Returns a resolved Path for the given string."""
+        return Path(raw).resolve()
+
+    parser.add_argument(
+        '--arch', choices=symbolfile.ALL_ARCHITECTURES, required=True,
+        help='Architecture being targeted.')
+    parser.add_argument('--api-map',
+                        type=resolved_path,
+                        required=True,
+                        help='Path to the API level map JSON file.')
+    parser.add_argument('--stamp',
+                        type=resolved_path,
+                        required=True,
+                        help='File to write when the check passes.')
+    parser.add_argument('symbol_file',
+                        type=resolved_path,
+                        help='Path to symbol file.')
+
+    return parser.parse_args()
+
+
+def main() -> None:
+    """Program entry point."""
+    args = parse_args()
+
+    with args.api_map.open() as map_file:
+        api_map = json.load(map_file)
+
+    # Keep every tagged symbol so that the duplicate check done by the parser
+    # matches the one ndkstubgen does for the most permissive stub.
+    filt = symbolfile.Filter(args.arch, symbolfile.FUTURE_API_LEVEL,
+                             llndk=True, apex=True, systemapi=True, ndk=False)
+    with args.symbol_file.open() as symbol_file:
+        try:
+            versions = symbolfile.SymbolFileParser(symbol_file, api_map,
+                                                   filt).parse()
+        except (symbolfile.ParseError,
+                symbolfile.MultiplyDefinedSymbolError) as ex:
+            sys.exit(f'{args.symbol_file}: error: {ex}')
+
+    untagged = symbolfile.find_symbols_without_mode_tags(versions)
+    if untagged:
+        for symbol in untagged:
+            print(f'{args.symbol_file}:{symbol.line}: error: symbol '
+                  f'"{symbol.name}" has no apex, systemapi or llndk tag and '
+                  'would be omitted from the stub',
+                  file=sys.stderr)
+        sys.exit(1)
+
+    args.stamp.touch()
+
+
+if __name__ == '__main__':
+    main()
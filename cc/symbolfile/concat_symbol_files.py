@@ -0,0 +1,93 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2023 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Concatenates symbol file fragments into a single symbol file.
+
+Every fragment must be a valid symbol file on its own. A version block or a
+symbol must not be defined by more than one fragment.
+"""
+import argparse
+import json
+from pathlib import Path
+import sys
+from typing import List, Tuple
+
+import symbolfile
+
+
+def parse_args() -> argparse.Namespace:
+    """Parses and returns command line arguments."""
+    parser = argparse.ArgumentParser(description=__doc__)
+
+    def resolved_path(raw: str) -> Path:
+        """Returns a resolved Path for the given string."""
+        return Path(raw).resolve()
+
+    parser.add_argument(
+        '--arch', choices=symbolfile.ALL_ARCHITECTURES, required=True,
+        help='Architecture being targeted.')
+    parser.add_argument('--api-map',
+                        type=resolved_path,
+                        required=True,
+                        help='Path to the API level map JSON file.')
+    parser.add_argument('--output',
+                        type=Path,
+                        required=True,
+                        help='Path to write the combined symbol file to.')
+    parser.add_argument('symbol_files',
+                        type=resolved_path,
+                        nargs='+',
+                        help='Paths to the symbol file fragments.')
+
+    return parser.parse_args()
+
+
+def main() -> None:
+    """Program entry point."""
+    args = parse_args()
+
+    with args.api_map.open() as map_file:
+        api_map = json.load(map_file)
+
+    # Keep every symbol so that symbols of every mode are checked for
+    # duplicates.
+    filt = symbolfile.Filter(args.arch, symbolfile.FUTURE_API_LEVEL,
+                             llndk=True, apex=True, systemapi=True, ndk=True)
+
+    fragments: List[Tuple[str, List[symbolfile.Version]]] = []
+    contents = []
+    for path in args.symbol_files:
+        with path.open() as symbol_file:
+            try:
+                versions = symbolfile.SymbolFileParser(symbol_file, api_map,
+                                                       filt).parse()
+            except (symbolfile.ParseError,
+                    symbolfile.MultiplyDefinedSymbolError) as ex:
+                sys.exit(f'{path}: error: {ex}')
+        fragments.append((str(path), versions))
+        contents.append(path.read_text())
+
+    errors = symbolfile.find_duplicate_definitions(fragments, filt)
+    if errors:
+        for error in errors:
+            print(error, file=sys.stderr)
+        sys.exit(1)
+
+    args.output.write_text('\n'.join(contents))
+
+
+if __name__ == '__main__':
+    main()
@@ -16,6 +16,7 @@
 """Tests for symbolfile."""
 import io
 import textwrap
+from typing import List
 import unittest
 
 import symbolfile
@@ -591,6 +592,95 @@ class SymbolFileParseTest(unittest.TestCase):
         ]
         self.assertEqual(expected_symbols, version.symbols)
 
+    def test_find_symbols_without_mode_tags(self) -> None:
+        input_file = io.StringIO(textwrap.dedent("""\
+            VERSION_1 {
+                foo; # apex
+                # A comment.
+                bar;
+                baz; # systemapi
+            };
+
+            VERSION_2 { # platform-only
+                qux;
+            };
+
+            VERSION_3_PRIVATE {
+                quux;
+            };
+
+            VERSION_4 {
+                corge; # arm64
+            } VERSION_1;
+        """))
+        parser = symbolfile.SymbolFileParser(input_file, {}, self.filter)
+        untagged = symbolfile.find_symbols_without_mode_tags(parser.parse())
+
+        self.assertEqual([
+            Symbol('bar', Tags()),
+            Symbol('corge', Tags.from_strs(['arm64'])),
+        ], untagged)
+        self.assertEqual([4, 17], [symbol.line for symbol in untagged])
+
+
+class FindDuplicateDefinitionsTest(unittest.TestCase):
+    def setUp(self) -> None:
+        self.filter = Filter(Arch('arm'), symbolfile.FUTURE_API_LEVEL,
+                             llndk=True, apex=True, systemapi=True, ndk=True)
+
+    def parse(self, text: str) -> List[Version]:
+        input_file = io.StringIO(textwrap.dedent(text))
+        return symbolfile.SymbolFileParser(input_file, {}, self.filter).parse()
+
+    def test_no_duplicates(self) -> None:
+        foo = self.parse("""\
+            VERSION_1 {
+                foo;
+            };
+        """)
+        bar = self.parse("""\
+            VERSION_2 {
+                bar;
+            } VERSION_1;
+        """)
+        self.assertEqual([], symbolfile.find_duplicate_definitions(
+            [('foo.map.txt', foo), ('bar.map.txt', bar)], self.filter))
+
+    def test_duplicate_version(self) -> None:
+        foo = self.parse("""\
+            VERSION_1 {
+                foo;
+            };
+        """)
+        bar = self.parse("""\
+            VERSION_1 {
+                bar;
+            };
+        """)
+        self.assertEqual([
+            'bar.map.txt: error: version "VERSION_1" is also defined in '
+            'foo.map.txt',
+        ], symbolfile.find_duplicate_definitions(
+            [('foo.map.txt', foo), ('bar.map.txt', bar)], self.filter))
+
+    def test_duplicate_symbol(self) -> None:
+        foo = self.parse("""\
+            VERSION_1 {
+                foo;
+            };
+        """)
+        bar = self.parse("""\
+            VERSION_2 {
+                bar;
+                foo;
+            } VERSION_1;
+        """)
+        self.assertEqual([
+            'bar.map.txt:3: error: symbol "foo" is also defined in '
+            'foo.map.txt',
+        ], symbolfile.find_duplicate_definitions(
+            [('foo.map.txt', foo), ('bar.map.txt', bar)], self.filter))
+
 
 def main() -> None:
     suite = unittest.TestLoader().loadTestsFromName(__name__)
@@ -69,6 +69,13 @@ func flagsToBuilderFlags(in Flags) builderFlags {
 		sAbiDump:      in.SAbiDump,
 		emitXrefs:     in.EmitXrefs,
 
+		compileCommands:       in.CompileCommands,
+		maxConcurrentCompiles: in.MaxConcurrentCompiles,
+		preprocessSrcs:        in.PreprocessSrcs,
+		asmListingSrcs:        in.AsmListingSrcs,
+		perFileCflags:         in.PerFileCflags,
+		splitDwarf:            splitDwarfEnabled(in),
+
 		systemIncludeFlags: strings.Join(in.SystemIncludeFlags, " "),
 
 		assemblerWithCpp: in.AssemblerWithCpp,
@@ -82,6 +89,23 @@ func flagsToBuilderFlags(in Flags) builderFlags {
 	}
 }
 
+// splitDwarfEnabled returns true if the compile flags request split DWARF, in which case clang
+// writes the debug info of each object to a .dwo file next to it. The last flag wins.
+func splitDwarfEnabled(in Flags) bool {
+	enabled := false
+	for _, list := range [][]string{in.Global.CommonFlags, in.Global.CFlags, in.Local.CommonFlags, in.Local.CFlags} {
+		for _, flag := range list {
+			switch flag {
+			case "-gsplit-dwarf", "-gsplit-dwarf=split":
+				enabled = true
+			case "-gno-split-dwarf", "-gsplit-dwarf=single":
+				enabled = false
+			}
+		}
+	}
+	return enabled
+}
+
 func flagsToStripFlags(in Flags) StripFlags {
 	return StripFlags{Toolchain: in.Toolchain}
 }
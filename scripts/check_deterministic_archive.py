@@ -0,0 +1,82 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2023 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Checks that the members of an ar archive have zeroed timestamps, uids and gids."""
+
+import argparse
+import sys
+
+AR_MAGIC = b'!<arch>\n'
+HEADER_SIZE = 60
+
+
+def parse_int(field):
+  field = field.strip()
+  if not field:
+    return 0
+  return int(field)
+
+
+def find_nondeterministic_members(data):
+  """Returns a list of (member name, problem) for the members of the archive
+  that have a nonzero mtime, uid or gid."""
+  if not data.startswith(AR_MAGIC):
+    raise ValueError('not an ar archive')
+
+  problems = []
+  offset = len(AR_MAGIC)
+  while offset < len(data):
+    header = data[offset:offset + HEADER_SIZE]
+    if len(header) < HEADER_SIZE or header[58:60] != b'`\n':
+      raise ValueError('truncated or corrupt member header at offset %d' % offset)
+    name = header[0:16].decode('ascii', 'replace').strip()
+    for field, start, end in (('mtime', 16, 28), ('uid', 28, 34), ('gid', 34, 40)):
+      value = parse_int(header[start:end])
+      if value != 0:
+        problems.append((name, '%s is %d' % (field, value)))
+    size = parse_int(header[48:58])
+    offset += HEADER_SIZE + size
+    # Members are aligned to an even offset.
+    offset += offset % 2
+  return problems
+
+
+def main():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('archive', help='the ar archive to check')
+  parser.add_argument('--stamp', required=True,
+                      help='file to write when the archive is deterministic')
+  args = parser.parse_args()
+
+  with open(args.archive, 'rb') as f:
+    data = f.read()
+  try:
+    problems = find_nondeterministic_members(data)
+  except ValueError as e:
+    sys.exit('error: %s: %s' % (args.archive, e))
+
+  if problems:
+    for name, problem in problems:
+      print('error: %s: member %s: %s' % (args.archive, name, problem),
+            file=sys.stderr)
+    sys.exit(1)
+
+  with open(args.stamp, 'w'):
+    pass
+
+
+if __name__ == '__main__':
+  main()
@@ -0,0 +1,54 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2023 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Tests for check_deterministic_archive."""
+
+import check_deterministic_archive
+import unittest
+
+
+def member(name, content, mtime=0, uid=0, gid=0):
+  header = b'%-16s%-12d%-6d%-6d%-8s%-10d`\n' % (
+      name.encode('ascii'), mtime, uid, gid, b'644', len(content))
+  data = header + content
+  if len(content) % 2:
+    data += b'\n'
+  return data
+
+
+class CheckDeterministicArchiveTest(unittest.TestCase):
+
+  def test_deterministic(self):
+    archive = (check_deterministic_archive.AR_MAGIC +
+               member('foo.o/', b'abc') + member('bar.o/', b'defg'))
+    self.assertEqual(
+        check_deterministic_archive.find_nondeterministic_members(archive), [])
+
+  def test_nondeterministic(self):
+    archive = (check_deterministic_archive.AR_MAGIC +
+               member('foo.o/', b'abc') +
+               member('bar.o/', b'defg', mtime=1690000000, uid=1000))
+    self.assertEqual(
+        check_deterministic_archive.find_nondeterministic_members(archive),
+        [('bar.o/', 'mtime is 1690000000'), ('bar.o/', 'uid is 1000')])
+
+  def test_not_an_archive(self):
+    with self.assertRaises(ValueError):
+      check_deterministic_archive.find_nondeterministic_members(b'\x7fELF')
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
@@ -0,0 +1,94 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2023 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Checks that exported headers only include headers that are exported too.
+
+An #include is resolved like the compiler would for a consumer of the library
+that also has the library's own include directories: relative to the including
+header for quoted includes, then in the exported and in the private include
+directories. Includes that can't be resolved in any of these, e.g. system
+headers, are ignored.
+"""
+
+import argparse
+import os
+import re
+import sys
+
+INCLUDE_RE = re.compile(r'^\s*#\s*include\s*([<"])([^">]+)[">]', re.MULTILINE)
+
+
+def is_under(path, dirs):
+  for d in dirs:
+    d = os.path.normpath(d)
+    if os.path.commonpath([path, d]) == d:
+      return True
+  return False
+
+
+def resolve(header, quoted, name, dirs):
+  candidates = []
+  if quoted:
+    candidates.append(os.path.join(os.path.dirname(header), name))
+  candidates.extend(os.path.join(d, name) for d in dirs)
+  for candidate in candidates:
+    if os.path.isfile(candidate):
+      return os.path.normpath(candidate)
+  return None
+
+
+def find_private_includes(headers, exported_dirs, include_dirs):
+  """Returns (header, include, resolved path) for each private include."""
+  private = []
+  for header in headers:
+    with open(header, errors='replace') as f:
+      text = f.read()
+    for match in INCLUDE_RE.finditer(text):
+      quoted = match.group(1) == '"'
+      name = match.group(2)
+      resolved = resolve(header, quoted, name, exported_dirs + include_dirs)
+      if resolved is not None and not is_under(resolved, exported_dirs):
+        private.append((header, name, resolved))
+  return private
+
+
+def main():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('headers', nargs='*', help='the exported headers to check')
+  parser.add_argument('--exported-dir', action='append', default=[],
+                      help='an exported include directory, including the '
+                      'ones reexported from dependencies')
+  parser.add_argument('--include-dir', action='append', default=[],
+                      help='a private include directory of the library')
+  parser.add_argument('--stamp', required=True,
+                      help='file to touch if the check passes')
+  args = parser.parse_args()
+
+  private = find_private_includes(args.headers, args.exported_dir,
+                                  args.include_dir)
+  for header, name, resolved in private:
+    print('error: exported header %s includes "%s" (%s), which is not under '
+          'any exported include directory' % (header, name, resolved),
+          file=sys.stderr)
+  if private:
+    sys.exit(1)
+
+  with open(args.stamp, 'w'):
+    pass
+
+
+if __name__ == '__main__':
+  main()
@@ -0,0 +1,75 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2023 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Tests for check_exported_header_closure."""
+
+import os
+import tempfile
+import unittest
+
+import check_exported_header_closure
+
+
+class CheckExportedHeaderClosureTest(unittest.TestCase):
+
+  def setUp(self):
+    self.tmp = tempfile.TemporaryDirectory()
+    self.addCleanup(self.tmp.cleanup)
+
+  def write(self, path, content=''):
+    path = os.path.join(self.tmp.name, path)
+    os.makedirs(os.path.dirname(path), exist_ok=True)
+    with open(path, 'w') as f:
+      f.write(content)
+    return path
+
+  def dir(self, path):
+    return os.path.join(self.tmp.name, path)
+
+  def test_exported_includes(self):
+    header = self.write('include/foo.h',
+                        '#include "foo/bar.h"\n#include <stdio.h>\n')
+    self.write('include/foo/bar.h')
+    self.assertEqual(
+        check_exported_header_closure.find_private_includes(
+            [header], [self.dir('include')], [self.dir('src')]), [])
+
+  def test_private_include(self):
+    header = self.write('include/foo.h', '#  include "private.h"\n')
+    private = self.write('src/private.h')
+    self.assertEqual(
+        check_exported_header_closure.find_private_includes(
+            [header], [self.dir('include')], [self.dir('src')]),
+        [(header, 'private.h', private)])
+
+  def test_relative_include_outside_exported_dir(self):
+    header = self.write('include/foo.h', '#include "../src/private.h"\n')
+    private = self.write('src/private.h')
+    self.assertEqual(
+        check_exported_header_closure.find_private_includes(
+            [header], [self.dir('include')], []),
+        [(header, '../src/private.h', private)])
+
+  def test_reexported_include(self):
+    header = self.write('include/foo.h', '#include <dep.h>\n')
+    self.write('dep/include/dep.h')
+    self.assertEqual(
+        check_exported_header_closure.find_private_includes(
+            [header], [self.dir('include'), self.dir('dep/include')], []), [])
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
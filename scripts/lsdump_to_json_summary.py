@@ -0,0 +1,80 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2023 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Writes a JSON summary of the exported functions, variables and types of a
+linked ABI dump (.lsdump) written by header-abi-linker.
+
+The summary lists, sorted by name, each exported function and variable with
+its symbol and each record and enum type, all with the header declaring them.
+"""
+
+import argparse
+import json
+import sys
+
+TYPE_KINDS = [('record_types', 'record'), ('enum_types', 'enum')]
+
+
+def summarize(dump):
+  """Returns the summary of the parsed linked dump."""
+  functions = [{
+      'name': f.get('function_name', ''),
+      'symbol': f.get('linker_set_key', ''),
+      'source_file': f.get('source_file', ''),
+  } for f in dump.get('functions', [])]
+  variables = [{
+      'name': v.get('name', ''),
+      'symbol': v.get('linker_set_key', ''),
+      'source_file': v.get('source_file', ''),
+  } for v in dump.get('global_vars', [])]
+  types = []
+  for key, kind in TYPE_KINDS:
+    types.extend({
+        'name': t.get('name', ''),
+        'kind': kind,
+        'source_file': t.get('source_file', ''),
+    } for t in dump.get(key, []))
+
+  def by_name(entry):
+    return (entry['name'], entry.get('symbol', entry.get('kind')))
+
+  return {
+      'functions': sorted(functions, key=by_name),
+      'variables': sorted(variables, key=by_name),
+      'types': sorted(types, key=by_name),
+  }
+
+
+def main():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('lsdump', help='the linked ABI dump, in JSON format')
+  parser.add_argument('--output', required=True,
+                      help='file to write the JSON summary to')
+  args = parser.parse_args()
+
+  try:
+    with open(args.lsdump) as f:
+      dump = json.load(f)
+  except ValueError as e:
+    sys.exit('error: %s is not a JSON ABI dump: %s' % (args.lsdump, e))
+
+  with open(args.output, 'w') as f:
+    json.dump(summarize(dump), f, indent=2, sort_keys=True)
+    f.write('\n')
+
+
+if __name__ == '__main__':
+  main()
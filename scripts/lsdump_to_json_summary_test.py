@@ -0,0 +1,101 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2023 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Tests for lsdump_to_json_summary."""
+
+import unittest
+
+import lsdump_to_json_summary
+
+
+class LsdumpToJsonSummaryTest(unittest.TestCase):
+
+  def test_summarize(self):
+    dump = {
+        'functions': [
+            {
+                'function_name': 'foo_open',
+                'linker_set_key': '_Z8foo_openv',
+                'source_file': 'include/foo.h',
+                'return_type': '_ZTIi',
+            },
+            {
+                'function_name': 'foo_close',
+                'linker_set_key': 'foo_close',
+                'source_file': 'include/foo.h',
+            },
+        ],
+        'global_vars': [{
+            'name': 'foo_version',
+            'linker_set_key': 'foo_version',
+            'source_file': 'include/foo.h',
+        }],
+        'record_types': [{
+            'name': 'foo_handle',
+            'linker_set_key': '_ZTI10foo_handle',
+            'source_file': 'include/foo.h',
+            'size': 8,
+        }],
+        'enum_types': [{
+            'name': 'foo_mode',
+            'source_file': 'include/foo.h',
+        }],
+        'pointer_types': [{'name': 'foo_handle *'}],
+    }
+    self.assertEqual(
+        lsdump_to_json_summary.summarize(dump), {
+            'functions': [
+                {
+                    'name': 'foo_close',
+                    'symbol': 'foo_close',
+                    'source_file': 'include/foo.h',
+                },
+                {
+                    'name': 'foo_open',
+                    'symbol': '_Z8foo_openv',
+                    'source_file': 'include/foo.h',
+                },
+            ],
+            'variables': [{
+                'name': 'foo_version',
+                'symbol': 'foo_version',
+                'source_file': 'include/foo.h',
+            }],
+            'types': [
+                {
+                    'name': 'foo_handle',
+                    'kind': 'record',
+                    'source_file': 'include/foo.h',
+                },
+                {
+                    'name': 'foo_mode',
+                    'kind': 'enum',
+                    'source_file': 'include/foo.h',
+                },
+            ],
+        })
+
+  def test_empty_dump(self):
+    self.assertEqual(
+        lsdump_to_json_summary.summarize({}), {
+            'functions': [],
+            'variables': [],
+            'types': [],
+        })
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
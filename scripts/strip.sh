@@ -19,16 +19,19 @@
 #  Environment:
 #   CLANG_BIN: path to the clang bin directory
 #   XZ: path to the xz binary
+#   GNU_STRIP: path to the GNU strip binary, used with --use-gnu-strip
 #  Arguments:
 #   -i ${file}: input file (required)
 #   -o ${file}: output file (required)
 #   -d ${file}: deps file (required)
 #   -k symbols: Symbols to keep (optional)
 #   --add-gnu-debuglink
+#   --keep-dynamic-symbols-only
 #   --keep-mini-debug-info
 #   --keep-symbols
 #   --keep-symbols-and-debug-frame
 #   --remove-build-id
+#   --use-gnu-strip
 #   --windows
 
 set -o pipefail
@@ -40,10 +43,12 @@ usage() {
 Usage: strip.sh [options] -k symbols -i in-file -o out-file -d deps-file
 Options:
         --add-gnu-debuglink             Add a gnu-debuglink section to out-file
+        --keep-dynamic-symbols-only     Keep only the dynamic symbol table in out-file
         --keep-mini-debug-info          Keep compressed debug info in out-file
         --keep-symbols                  Keep symbols in out-file
         --keep-symbols-and-debug-frame  Keep symbols and .debug_frame in out-file
         --remove-build-id               Remove the gnu build-id section in out-file
+        --use-gnu-strip                 Strip with the GNU strip in GNU_STRIP instead of llvm-strip
         --windows                       Input file is Windows DLL or executable
 EOF
     exit 1
@@ -56,7 +61,14 @@ do_strip() {
     if [ -n "${windows}" ]; then
       keep_section=
     fi
-    "${CLANG_BIN}/llvm-strip" --strip-all ${keep_section} "${infile}" -o "${outfile}.tmp"
+    "${strip_cmd}" --strip-all ${keep_section} "${infile}" -o "${outfile}.tmp"
+}
+
+do_strip_keep_dynamic_symbols_only() {
+    # --strip-all never removes the allocated .dynsym and .dynstr sections, they are listed to
+    # document what is kept.
+    "${strip_cmd}" --strip-all --keep-section=.ARM.attributes --keep-section=.dynsym \
+        --keep-section=.dynstr --remove-section=.comment "${infile}" -o "${outfile}.tmp"
 }
 
 do_strip_keep_symbols_and_debug_frame() {
@@ -80,7 +92,7 @@ do_strip_keep_symbol_list() {
 do_strip_keep_mini_debug_info_darwin() {
     rm -f "${outfile}.dynsyms" "${outfile}.funcsyms" "${outfile}.keep_symbols" "${outfile}.debug" "${outfile}.mini_debuginfo" "${outfile}.mini_debuginfo.xz"
     local fail=
-    "${CLANG_BIN}/llvm-strip" --strip-all --keep-section=.ARM.attributes --remove-section=.comment "${infile}" -o "${outfile}.tmp" || fail=true
+    "${strip_cmd}" --strip-all --keep-section=.ARM.attributes --remove-section=.comment "${infile}" -o "${outfile}.tmp" || fail=true
 
     if [ -z $fail ]; then
         "${CLANG_BIN}/llvm-objcopy" --only-keep-debug "${infile}" "${outfile}.debug"
@@ -101,7 +113,7 @@ do_strip_keep_mini_debug_info_darwin() {
 do_strip_keep_mini_debug_info_linux() {
     rm -f "${outfile}.mini_debuginfo.xz"
     local fail=
-    "${CLANG_BIN}/llvm-strip" --strip-all --keep-section=.ARM.attributes --remove-section=.comment "${infile}" -o "${outfile}.tmp" || fail=true
+    "${strip_cmd}" --strip-all --keep-section=.ARM.attributes --remove-section=.comment "${infile}" -o "${outfile}.tmp" || fail=true
 
     if [ -z $fail ]; then
         # create_minidebuginfo has issues with compressed debug sections. Just
@@ -151,10 +163,12 @@ while getopts $OPTSTRING opt; do
         -)
             case "${OPTARG}" in
                 add-gnu-debuglink) add_gnu_debuglink=true ;;
+                keep-dynamic-symbols-only) keep_dynamic_symbols_only=true ;;
                 keep-mini-debug-info) keep_mini_debug_info=true ;;
                 keep-symbols) keep_symbols=true ;;
                 keep-symbols-and-debug-frame) keep_symbols_and_debug_frame=true ;;
                 remove-build-id) remove_build_id=true ;;
+                use-gnu-strip) use_gnu_strip=true ;;
                 windows) windows=true ;;
                 *) echo "Unknown option --${OPTARG}"; usage ;;
             esac;;
@@ -198,11 +212,21 @@ if [ ! -z "${symbols_to_keep}" -a ! -z "${keep_symbols}" ]; then
     usage
 fi
 
+if [ ! -z "${keep_dynamic_symbols_only}" ] && [ ! -z "${keep_symbols}${symbols_to_keep}${keep_mini_debug_info}${keep_symbols_and_debug_frame}" ]; then
+    echo "--keep-dynamic-symbols-only cannot be used with other options to keep symbols or debug info"
+    usage
+fi
+
 if [ ! -z "${add_gnu_debuglink}" -a ! -z "${keep_mini_debug_info}" ]; then
     echo "--add-gnu-debuglink cannot be used with --keep-mini-debug-info"
     usage
 fi
 
+strip_cmd="${CLANG_BIN}/llvm-strip"
+if [ ! -z "${use_gnu_strip}" ]; then
+    strip_cmd="${GNU_STRIP}"
+fi
+
 rm -f "${outfile}.tmp"
 
 if [ ! -z "${keep_symbols}" ]; then
@@ -213,6 +237,8 @@ elif [ ! -z "${keep_mini_debug_info}" ]; then
     do_strip_keep_mini_debug_info
 elif [ ! -z "${keep_symbols_and_debug_frame}" ]; then
     do_strip_keep_symbols_and_debug_frame
+elif [ ! -z "${keep_dynamic_symbols_only}" ]; then
+    do_strip_keep_dynamic_symbols_only
 else
     do_strip
 fi
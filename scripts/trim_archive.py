@@ -0,0 +1,118 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2023 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Drops the members of a static library that are not reachable from a set of
+root symbols.
+
+A member is kept if it defines one of the root symbols, or a symbol that is
+undefined in a kept member, like a linker pulls members out of an archive.
+Weak undefined references don't keep a member, as they don't for the linker
+either.
+"""
+
+import argparse
+import re
+import shutil
+import subprocess
+import sys
+
+# A line of `llvm-nm -A --format=posix` for an archive, e.g.
+# "libfoo.a[foo.o]: foo_init T 0 10".
+NM_LINE_RE = re.compile(r'^.*\[(?P<member>[^\]]+)\]: (?P<symbol>\S+) (?P<type>\S)')
+
+# The symbol types of global definitions, i.e. all upper case types but 'U'
+# (undefined) and 'w'/'v' (weak undefined).
+DEFINED_TYPES = set('ABCDGRSTVWi')
+
+
+def parse_nm(output):
+  """Returns {member: (defined symbols, undefined symbols)} for nm output."""
+  members = {}
+  for line in output.splitlines():
+    match = NM_LINE_RE.match(line)
+    if not match:
+      continue
+    defined, undefined = members.setdefault(match.group('member'),
+                                            (set(), set()))
+    if match.group('type') == 'U':
+      undefined.add(match.group('symbol'))
+    elif match.group('type') in DEFINED_TYPES:
+      defined.add(match.group('symbol'))
+  return members
+
+
+def parse_roots(text):
+  """Returns the symbols of a roots file, one per line, ignoring comments."""
+  roots = []
+  for line in text.splitlines():
+    line = line.split('#', 1)[0].strip()
+    if line:
+      roots.append(line)
+  return roots
+
+
+def reachable_members(members, roots):
+  """Returns the set of members reachable from the root symbols."""
+  definitions = {}
+  for member, (defined, _) in sorted(members.items()):
+    for symbol in defined:
+      definitions.setdefault(symbol, member)
+
+  kept = set()
+  pending = list(roots)
+  seen = set()
+  while pending:
+    symbol = pending.pop()
+    if symbol in seen:
+      continue
+    seen.add(symbol)
+    member = definitions.get(symbol)
+    if member is None or member in kept:
+      continue
+    kept.add(member)
+    pending.extend(members[member][1])
+  return kept
+
+
+def main():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('archive', help='the static library to trim')
+  parser.add_argument('--nm', required=True, help='path to llvm-nm')
+  parser.add_argument('--ar', required=True, help='path to llvm-ar')
+  parser.add_argument('--roots', required=True,
+                      help='file listing the root symbols, one per line')
+  parser.add_argument('--output', required=True,
+                      help='file to write the trimmed static library to')
+  args = parser.parse_args()
+
+  with open(args.roots) as f:
+    roots = parse_roots(f.read())
+  nm_output = subprocess.run(
+      [args.nm, '-A', '--format=posix', args.archive],
+      check=True, stdout=subprocess.PIPE, universal_newlines=True).stdout
+  members = parse_nm(nm_output)
+  unreferenced = sorted(set(members) - reachable_members(members, roots))
+
+  shutil.copyfile(args.archive, args.output)
+  if unreferenced:
+    result = subprocess.run([args.ar, 'dP', args.output] + unreferenced)
+    if result.returncode != 0:
+      sys.exit('error: failed to remove the unreferenced members of %s' %
+               args.archive)
+
+
+if __name__ == '__main__':
+  main()
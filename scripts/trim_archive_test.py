@@ -0,0 +1,67 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2023 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Tests for trim_archive."""
+
+import unittest
+
+import trim_archive
+
+NM_OUTPUT = """\
+libfoo.a[out/obj/api.o]: foo_init T 0 10
+libfoo.a[out/obj/api.o]: helper U
+libfoo.a[out/obj/api.o]: optional_hook w
+libfoo.a[out/obj/api.o]: local_fn t 10 4
+libfoo.a[out/obj/helper.o]: helper T 0 8
+libfoo.a[out/obj/helper.o]: malloc U
+libfoo.a[out/obj/hook.o]: optional_hook T 0 8
+libfoo.a[out/obj/unused.o]: unused_fn T 0 8
+libfoo.a[out/obj/unused.o]: helper U
+"""
+
+
+class TrimArchiveTest(unittest.TestCase):
+
+  def test_parse_nm(self):
+    members = trim_archive.parse_nm(NM_OUTPUT)
+    self.assertEqual(members['out/obj/api.o'],
+                     ({'foo_init'}, {'helper'}))
+    self.assertEqual(members['out/obj/helper.o'], ({'helper'}, {'malloc'}))
+
+  def test_parse_roots(self):
+    self.assertEqual(
+        trim_archive.parse_roots('# public API\nfoo_init\n\n bar  # old\n'),
+        ['foo_init', 'bar'])
+
+  def test_reachable_members(self):
+    members = trim_archive.parse_nm(NM_OUTPUT)
+    self.assertEqual(
+        trim_archive.reachable_members(members, ['foo_init']),
+        {'out/obj/api.o', 'out/obj/helper.o'})
+
+  def test_weak_reference_does_not_keep_member(self):
+    members = trim_archive.parse_nm(NM_OUTPUT)
+    self.assertNotIn('out/obj/hook.o',
+                     trim_archive.reachable_members(members, ['foo_init']))
+
+  def test_unknown_root(self):
+    members = trim_archive.parse_nm(NM_OUTPUT)
+    self.assertEqual(trim_archive.reachable_members(members, ['missing']),
+                     set())
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
@@ -0,0 +1,87 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2023 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Lists the symbols exported by the global sections of a linker version script.
+
+Wildcard patterns and extern "C++" blocks are skipped, as they do not name
+symbols exactly.
+"""
+
+import argparse
+import re
+
+TOKEN_RE = re.compile(r'"[^"]*"|[{};:]|[^\s{};:"]+')
+WILDCARD_CHARS = set('*?[')
+
+
+def strip_comments(text):
+  text = re.sub(r'/\*.*?\*/', ' ', text, flags=re.DOTALL)
+  return re.sub(r'#[^\n]*', ' ', text)
+
+
+def exported_symbols(text):
+  """Returns the sorted list of symbols of the global sections of text."""
+  tokens = TOKEN_RE.findall(strip_comments(text))
+  symbols = set()
+  # Each entry is True for a version node and False for an extern block.
+  blocks = []
+  global_scope = True
+  i = 0
+  while i < len(tokens):
+    token = tokens[i]
+    following = tokens[i + 1] if i + 1 < len(tokens) else None
+    if token == '{':
+      blocks.append(True)
+      global_scope = True
+    elif token == 'extern' and following is not None and following.startswith('"'):
+      # extern "lang" {
+      blocks.append(False)
+      i += 2
+    elif token == '}':
+      if not blocks:
+        raise ValueError('unbalanced }')
+      blocks.pop()
+    elif token in ('global', 'local') and following == ':':
+      global_scope = token == 'global'
+      i += 1
+    elif blocks and following == ';':
+      if (all(blocks) and global_scope and
+          not WILDCARD_CHARS.intersection(token)):
+        symbols.add(token)
+      i += 1
+    i += 1
+  if blocks:
+    raise ValueError('unterminated version node')
+  return sorted(symbols)
+
+
+def main():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('version_script', help='the version script to read')
+  parser.add_argument('--output', required=True,
+                      help='file to write the symbols to, one per line')
+  args = parser.parse_args()
+
+  with open(args.version_script) as f:
+    symbols = exported_symbols(f.read())
+
+  with open(args.output, 'w') as f:
+    for symbol in symbols:
+      f.write(symbol + '\n')
+
+
+if __name__ == '__main__':
+  main()
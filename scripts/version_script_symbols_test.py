@@ -0,0 +1,59 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2023 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Tests for version_script_symbols."""
+
+import unittest
+
+import version_script_symbols
+
+
+class VersionScriptSymbolsTest(unittest.TestCase):
+
+  def test_global_section(self):
+    script = '''
+      LIBFOO_1 {
+        global:
+          foo; # introduced=29
+          bar;
+        local:
+          *;
+      };
+
+      LIBFOO_2 {
+        baz;
+        /* a
+           comment */
+        extern "C++" {
+          "ns::qux()";
+        };
+        prefix_*;
+      } LIBFOO_1;
+    '''
+    self.assertEqual(
+        version_script_symbols.exported_symbols(script), ['bar', 'baz', 'foo'])
+
+  def test_local_symbols_are_skipped(self):
+    script = '{ global: foo; local: bar; };'
+    self.assertEqual(version_script_symbols.exported_symbols(script), ['foo'])
+
+  def test_unterminated(self):
+    with self.assertRaises(ValueError):
+      version_script_symbols.exported_symbols('LIBFOO { foo;')
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
@@ -173,6 +173,10 @@ type syspropLibraryProperties struct {
 
 		// Linker flags used to build binary
 		Ldflags []string
+
+		// Also export the generated headers under this prefix, so that clients can include
+		// them as <prefix/...>. Forwarded to cc_library.sysprop.export_prefix
+		Export_prefix *string
 	}
 
 	Java struct {
@@ -392,7 +396,8 @@ type ccLibraryProperties struct {
 	Device_specific  *bool
 	Product_specific *bool
 	Sysprop          struct {
-		Platform *bool
+		Platform      *bool
+		Export_prefix *string
 	}
 	Target struct {
 		Android struct {
@@ -484,6 +489,7 @@ func syspropLibraryHook(ctx android.LoadHookContext, m *syspropLibrary) {
 	ccProps.Device_specific = proptools.BoolPtr(ctx.DeviceSpecific())
 	ccProps.Product_specific = proptools.BoolPtr(ctx.ProductSpecific())
 	ccProps.Sysprop.Platform = proptools.BoolPtr(isOwnerPlatform)
+	ccProps.Sysprop.Export_prefix = m.properties.Cpp.Export_prefix
 	ccProps.Target.Android.Header_libs = []string{"libbase_headers"}
 	ccProps.Target.Android.Shared_libs = []string{"liblog"}
 	ccProps.Target.Host.Static_libs = []string{"libbase", "liblog"}
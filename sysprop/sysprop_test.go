@@ -376,3 +376,35 @@ func TestMinSdkVersionIsForwarded(t *testing.T) {
 	propFromJava := javaModule.MinSdkVersionString()
 	android.AssertStringEquals(t, "min_sdk_version forwarding to java module", "30", propFromJava)
 }
+
+func TestExportPrefixIsForwarded(t *testing.T) {
+	result := test(t, `
+		sysprop_library {
+			name: "sysprop-platform-on-product",
+			srcs: ["android/sysprop/PlatformProperties.sysprop"],
+			api_packages: ["android.sysprop"],
+			property_owner: "Platform",
+			product_specific: true,
+			cpp: {
+				export_prefix: "sysprop_prefix",
+			},
+		}
+
+		cc_library {
+			name: "cc-client-product",
+			srcs: ["d.cpp"],
+			product_specific: true,
+			static_libs: ["libsysprop-platform-on-product"],
+		}
+	`)
+
+	productVariant := "android_product.29_arm64_armv8-a_static"
+	library := result.ModuleForTests("libsysprop-platform-on-product", productVariant)
+	stagedHeader := library.Output("gen/sysprop_export/include/sysprop_prefix/android/sysprop/PlatformProperties.sysprop.h")
+	android.AssertStringDoesContain(t, "staged header source", stagedHeader.Input.String(),
+		"gen/sysprop/public/include/android/sysprop/PlatformProperties.sysprop.h")
+
+	productFlags := result.ModuleForTests("cc-client-product", productVariant).Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "flags for product", productFlags,
+		"libsysprop-platform-on-product/android_product.29_arm64_armv8-a_static/gen/sysprop_export/include")
+}